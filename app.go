@@ -1,3 +1,5 @@
+//go:build !headless
+
 package main
 
 import (
@@ -12,24 +14,27 @@ import (
 
 	"github.com/djherbis/times"
 	"github.com/getlantern/systray"
-	"github.com/spf13/viper"
+	"github.com/omriharel/filtersnatch/filter"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // App struct
 type App struct {
-	Paused bool
+	ctx    context.Context
+	config *Config
+	engine *Engine
+
+	// configErr is why the config couldn't be loaded, if it couldn't
+	configErr error
 
-	ctx     context.Context
-	config  *viper.Viper
-	watcher *Watcher
+	lintCache *lintCache
 
 	version string
 }
 
 // NewApp creates a new App application struct
 func NewApp() *App {
-	return &App{}
+	return &App{lintCache: newLintCache()}
 }
 
 func (a *App) setVersion(version string) {
@@ -41,15 +46,26 @@ func (a *App) setVersion(version string) {
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
 
+	log := wailsLogger{ctx: ctx}
+
 	var err error
-	a.config, err = NewConfig(a.ctx)
+	a.config, err = NewConfig(log)
 	if err != nil {
-		runtime.LogErrorf(a.ctx, "Failed to init config: %w", err)
+		runtime.LogErrorf(a.ctx, "Failed to init config: %v", err)
+
+		// keep going with the defaults, so the window can still open and tell the user what's wrong.
+		// This config has no file behind it, so nothing can be saved over the broken one
+		a.config = newDefaultConfig()
+		a.configErr = err
 	}
 
-	a.watcher, err = NewWatcher(a)
+	for _, issue := range validateConfig(a.config) {
+		runtime.LogWarningf(a.ctx, "Config problem: %s", issue)
+	}
+
+	a.engine, err = NewEngine(ctx, a.config, log, wailsEmitter{ctx: ctx})
 	if err != nil {
-		runtime.LogErrorf(a.ctx, "Failed to init watcher: %w", err)
+		runtime.LogErrorf(a.ctx, "Failed to init engine: %v", err)
 	}
 
 	go func() {
@@ -59,25 +75,15 @@ func (a *App) startup(ctx context.Context) {
 
 // domReady is called when the DOM is ready
 func (a *App) domReady(ctx context.Context) {
-	a.watcher.Start()
+	a.engine.Start()
 
 	if !a.config.GetBool(configKeyWindowStartInTray) {
 		runtime.WindowShow(ctx)
 	}
-
-	filtersDirectory := a.config.GetString(configKeyFiltersDirectory)
-	if filtersDirectory != "" && dirExists(filtersDirectory) {
-		a.watcher.SetFiltersDirectory(filtersDirectory)
-	}
-
-	downloadsDirectory := a.config.GetString(configKeyDownloadsDirectory)
-	if downloadsDirectory != "" && dirExists(downloadsDirectory) {
-		a.watcher.SetDownloadsDirectory(downloadsDirectory)
-	}
 }
 
 func (a *App) shutdown(ctx context.Context) {
-	a.watcher.Stop()
+	a.engine.Stop()
 }
 
 // chooseDirFromConfigAndUpdateConfig allows the user to choose a directory for a given purpose.
@@ -99,7 +105,7 @@ func (a *App) chooseDirFromConfigAndUpdateConfig(configKey string, title string,
 
 	chosenPath, err := runtime.OpenDirectoryDialog(a.ctx, options)
 	if err != nil || chosenPath == "" {
-		runtime.LogErrorf(a.ctx, "Failed to choose directory: %w", err)
+		runtime.LogErrorf(a.ctx, "Failed to choose directory: %v", err)
 		return "", nil
 	} else {
 
@@ -115,16 +121,18 @@ func (a *App) chooseDirFromConfigAndUpdateConfig(configKey string, title string,
 
 		runtime.LogDebugf(a.ctx, "Chosen new path %s for key '%s', updating config", chosenPath, configKey)
 		a.config.Set(configKey, chosenPath)
-		if err = a.config.WriteConfig(); err != nil {
-			runtime.LogErrorf(a.ctx, "Failed to update config key '%s': %w", configKey, err)
+		if err = a.engine.writeConfig(configKey); err != nil {
+			runtime.LogErrorf(a.ctx, "Failed to update config key '%s': %v", configKey, err)
 		}
 
 		// update watcher
 		if configKey == configKeyFiltersDirectory {
-			a.watcher.SetFiltersDirectory(chosenPath)
+			a.engine.watcher.SetFiltersDirectory(chosenPath)
 		} else if configKey == configKeyDownloadsDirectory {
-			a.watcher.SetDownloadsDirectory(chosenPath)
+			a.engine.watcher.SetDownloadsDirectory(chosenPath)
 		}
+
+		a.engine.refreshStatus()
 	}
 
 	return chosenPath, nil
@@ -138,37 +146,135 @@ func (a *App) GetStartInTrayFromConfig() bool {
 
 func (a *App) SetStartInTrayAndUpdateConfig(startInTray bool) {
 	a.config.Set(configKeyWindowStartInTray, startInTray)
-	if err := a.config.WriteConfig(); err != nil {
-		runtime.LogErrorf(a.ctx, "Failed to update config: %w", err)
+	if err := a.engine.writeConfig(configKeyWindowStartInTray); err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to update config: %v", err)
 	}
 }
 
-func (a *App) SetFiltersStrategyAndUpdateConfig(strategy string, fileName string) {
+func (a *App) SetFiltersStrategyAndUpdateConfig(strategy string, fileName string) error {
+	overwriteStrategy, ok := parseOverwriteStrategy(strategy)
+	if !ok {
+		runtime.LogErrorf(a.ctx, "Unknown filters overwrite strategy: %s", strategy)
+		return fmt.Errorf("unknown filters overwrite strategy: %s", strategy)
+	}
+
+	// named files may not exist yet, so make sure they at least can
+	if overwriteStrategy == OverwriteNamedFile && fileName != "" {
+		if err := validateTargetTemplate(fileName); err != nil {
+			runtime.LogErrorf(a.ctx, "Refusing to use named filter file: %v", err)
+			return err
+		}
+	}
+
 	a.config.Set(configKeyFiltersOverwriteStrategy, strategy)
 	a.config.Set(configKeyFiltersSelectedFile, fileName)
 
-	if err := a.config.WriteConfig(); err != nil {
-		runtime.LogErrorf(a.ctx, "Failed to update config: %w", err)
+	if err := a.engine.writeConfig(configKeyFiltersOverwriteStrategy, configKeyFiltersSelectedFile); err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to update config: %v", err)
 	}
+
+	a.engine.refreshStatus()
+
+	return nil
 }
 
 func (a *App) SetDownloadsStrategyAndUpdateConfig(strategy string, fileName string) {
 	a.config.Set(configKeyDownloadsWatchStrategy, strategy)
 	a.config.Set(configKeyDownloadsNamedFile, fileName)
 
-	if err := a.config.WriteConfig(); err != nil {
-		runtime.LogErrorf(a.ctx, "Failed to update config: %w", err)
+	if err := a.engine.writeConfig(configKeyDownloadsWatchStrategy, configKeyDownloadsNamedFile); err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to update config: %v", err)
 	}
+
+	a.engine.refreshStatus()
 }
 
-func (a *App) TogglePause() {
-	if !a.Paused {
-		runtime.LogInfo(a.ctx, "Pausing")
-	} else {
-		runtime.LogInfo(a.ctx, "Resuming")
+func (a *App) GetRememberPausedFromConfig() bool {
+	return a.config.GetBool(configKeyWatcherRememberPaused)
+}
+
+func (a *App) SetRememberPausedAndUpdateConfig(rememberPaused bool) {
+	a.config.Set(configKeyWatcherRememberPaused, rememberPaused)
+	a.config.Set(configKeyWatcherPaused, rememberPaused && a.IsPaused())
+	if err := a.engine.writeConfig(configKeyWatcherRememberPaused, configKeyWatcherPaused); err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to update config: %v", err)
+	}
+}
+
+func (a *App) GetStrictValidationFromConfig() bool {
+	return a.config.GetString(configKeyValidationMode) == string(ValidationStrict)
+}
+
+func (a *App) SetStrictValidationAndUpdateConfig(strict bool) {
+	mode := ValidationLenient
+	if strict {
+		mode = ValidationStrict
+	}
+
+	a.config.Set(configKeyValidationMode, string(mode))
+	if err := a.engine.writeConfig(configKeyValidationMode); err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to update config: %v", err)
+	}
+}
+
+func (a *App) GetAllowDowngradeFromConfig() bool {
+	return a.config.GetBool(configKeyFiltersAllowDowngrade)
+}
+
+func (a *App) SetAllowDowngradeAndUpdateConfig(allowDowngrade bool) {
+	a.config.Set(configKeyFiltersAllowDowngrade, allowDowngrade)
+	if err := a.engine.writeConfig(configKeyFiltersAllowDowngrade); err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to update config: %v", err)
+	}
+}
+
+func (a *App) GetCopySoundsFromDownloadsFromConfig() bool {
+	return a.config.GetBool(configKeyAssetsCopyFromDownloads)
+}
+
+func (a *App) SetCopySoundsFromDownloadsAndUpdateConfig(copySounds bool) {
+	a.config.Set(configKeyAssetsCopyFromDownloads, copySounds)
+	if err := a.engine.writeConfig(configKeyAssetsCopyFromDownloads); err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to update config: %v", err)
+	}
+}
+
+// GetMissingAssets lists the custom sounds that filters in the filters directory play, but which aren't there
+func (a *App) GetMissingAssets() []MissingAsset {
+	filtersDirectory := a.engine.filtersDirectory()
+	if !dirExists(filtersDirectory) {
+		return []MissingAsset{}
+	}
+
+	missing, err := findMissingAssets(filtersDirectory)
+	if err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to look for missing sounds: %v", err)
+		return []MissingAsset{}
+	}
+
+	return missing
+}
+
+func (a *App) IsPaused() bool {
+	return a.engine.IsPaused()
+}
+
+// TogglePause flips the paused state, and returns the new one
+func (a *App) TogglePause() bool {
+	return a.engine.TogglePause()
+}
+
+func (a *App) GetStatus() Status {
+	return a.engine.computeStatus()
+}
+
+// GetConfigIssues returns everything wrong with the config, or why it couldn't be loaded at all
+func (a *App) GetConfigIssues() []ConfigIssue {
+	if a.configErr != nil {
+		return []ConfigIssue{{Message: fmt.Sprintf("couldn't load config.yaml, using the defaults until it's fixed: %v", a.configErr)}}
 	}
 
-	a.Paused = !a.Paused
+	return validateConfig(a.config)
 }
 
 type ConfigJSON struct {
@@ -180,7 +286,11 @@ type ConfigJSON struct {
 	DownloadsWatchStrategy string `json:"downloads_watch_strategy"`
 	DownloadsNamedFile     string `json:"downloads_named_file"`
 
-	StartInTray bool `json:"start_in_tray"`
+	StartInTray      bool `json:"start_in_tray"`
+	RememberPaused   bool `json:"remember_paused"`
+	StrictValidation bool `json:"strict_validation"`
+	AllowDowngrade   bool `json:"allow_downgrade"`
+	CopySounds       bool `json:"copy_sounds_from_downloads"`
 }
 
 func (a *App) GetConfigJSON() ConfigJSON {
@@ -192,6 +302,10 @@ func (a *App) GetConfigJSON() ConfigJSON {
 		DownloadsWatchStrategy:   a.config.GetString(configKeyDownloadsWatchStrategy),
 		DownloadsNamedFile:       a.config.GetString(configKeyDownloadsNamedFile),
 		StartInTray:              a.config.GetBool(configKeyWindowStartInTray),
+		RememberPaused:           a.config.GetBool(configKeyWatcherRememberPaused),
+		StrictValidation:         a.GetStrictValidationFromConfig(),
+		AllowDowngrade:           a.config.GetBool(configKeyFiltersAllowDowngrade),
+		CopySounds:               a.config.GetBool(configKeyAssetsCopyFromDownloads),
 	}
 }
 
@@ -226,6 +340,16 @@ func (a *App) ChooseDownloadsDir() string {
 type FileListEntry struct {
 	Name        string `json:"name"`
 	CreatedTime string `json:"created_time"`
+
+	// from the filter's header, if it has one
+	Version    string `json:"version,omitempty"`
+	Strictness string `json:"strictness,omitempty"`
+	Style      string `json:"style,omitempty"`
+	Source     string `json:"source,omitempty"`
+
+	// LintIssues counts the filter's lint warnings, of which Lint has the first few
+	LintIssues int                `json:"lint_issues"`
+	Lint       []filter.LintIssue `json:"lint,omitempty"`
 }
 
 func (a *App) ListFiltersInDir(dir string) ([]FileListEntry, error) {
@@ -254,9 +378,25 @@ func (a *App) ListFiltersInDir(dir string) ([]FileListEntry, error) {
 				createdTime = fileTimes.BirthTime()
 			}
 
+			metadata, err := readFilterMetadata(filepath.Join(expandedDir, file.Name()))
+			if err != nil {
+				runtime.LogWarningf(a.ctx, "Failed to read metadata of %s: %v", file.Name(), err)
+			}
+
+			lint, err := a.lintCache.lint(filepath.Join(expandedDir, file.Name()), file)
+			if err != nil {
+				runtime.LogWarningf(a.ctx, "Failed to lint %s: %v", file.Name(), err)
+			}
+
 			filterFiles = append(filterFiles, FileListEntry{
 				Name:        file.Name(),
 				CreatedTime: createdTime.Format(time.RFC3339),
+				Version:     metadata.Version,
+				Strictness:  metadata.Strictness,
+				Style:       metadata.Style,
+				Source:      metadata.Source,
+				LintIssues:  len(lint),
+				Lint:        truncateLintIssues(lint, fileListMaxLintIssues),
 			})
 		}
 	}
@@ -264,3 +404,98 @@ func (a *App) ListFiltersInDir(dir string) ([]FileListEntry, error) {
 	runtime.LogDebugf(a.ctx, "Found %d filter files in %s", len(filterFiles), expandedDir)
 	return filterFiles, nil
 }
+
+func (a *App) ListBackups(targetFile string) ([]BackupEntry, error) {
+	filtersDirectory := a.engine.filtersDirectory()
+	if !dirExists(filtersDirectory) {
+		return nil, fmt.Errorf("directory %s does not exist", filtersDirectory)
+	}
+
+	backups, err := a.engine.newBackupStore(filtersDirectory).List(targetFile)
+	if err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to list backups of %s: %v", targetFile, err)
+		return nil, err
+	}
+
+	runtime.LogDebugf(a.ctx, "Found %d backups of %s", len(backups), targetFile)
+	return backups, nil
+}
+
+func (a *App) RestoreBackup(targetFile string, backupID string) error {
+	filtersDirectory := a.engine.filtersDirectory()
+	if !dirExists(filtersDirectory) {
+		return fmt.Errorf("directory %s does not exist", filtersDirectory)
+	}
+
+	runtime.LogInfof(a.ctx, "Restoring backup %s of %s", backupID, targetFile)
+	if err := a.engine.newBackupStore(filtersDirectory).Restore(targetFile, backupID); err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to restore backup %s of %s: %v", backupID, targetFile, err)
+		return err
+	}
+
+	a.engine.emit(BackupRestored{TargetFile: targetFile, BackupID: backupID})
+	a.engine.refreshStatus()
+	return nil
+}
+
+// GetReceipts returns the latest verified replacement of every target filter file
+func (a *App) GetReceipts() []ReplacementReceipt {
+	if a.engine.receipts == nil {
+		return []ReplacementReceipt{}
+	}
+
+	return a.engine.receipts.All()
+}
+
+// GetHistory returns up to limit history entries matching the given filter (newest first), skipping offset of them
+func (a *App) GetHistory(limit int, offset int, historyFilter HistoryFilter) ([]HistoryEntry, error) {
+	if a.engine.history == nil {
+		return []HistoryEntry{}, nil
+	}
+
+	entries, err := a.engine.history.Query(limit, offset, historyFilter)
+	if err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to query action history: %v", err)
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// GetRules returns the configured replacement rules, including disabled ones
+func (a *App) GetRules() []ReplacementRule {
+	rules, err := a.engine.configuredRules()
+	if err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to get replacement rules from config: %v", err)
+		return []ReplacementRule{}
+	}
+
+	return rules
+}
+
+// SetRulesAndUpdateConfig replaces all replacement rules, in order. Nothing is saved if any of them is invalid
+func (a *App) SetRulesAndUpdateConfig(rules []ReplacementRule) error {
+	if err := a.engine.setRules(rules); err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to update replacement rules: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// DiffFilters summarizes what changed between two filter files, block by block
+func (a *App) DiffFilters(beforePath string, afterPath string) (*filter.Diff, error) {
+	diff, err := diffFilterFiles(beforePath, afterPath)
+	if err != nil {
+		runtime.LogErrorf(a.ctx, "Failed to diff filters %s and %s: %v", beforePath, afterPath, err)
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+// GetEventPayloads is never called by the frontend. It only exists so that every event payload type gets
+// generated into the frontend's models (see EventPayloads)
+func (a *App) GetEventPayloads() EventPayloads {
+	return EventPayloads{}
+}
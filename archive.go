@@ -0,0 +1,277 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	archiveExtension = ".zip"
+
+	// archives are extracted into a hidden directory in the downloads directory, and removed once handled
+	extractedDirectoryName = ".filtersnatch-extracted"
+
+	// filter archives only hold a few filters and sounds, so anything bigger than this is refused
+	maxArchiveMemberSize = 64 << 20
+	maxArchiveMembers    = 1000
+)
+
+// soundFileExtensions are the custom alert sound formats Path of Exile can play
+var soundFileExtensions = []string{".mp3", ".wav"}
+
+// extractedArchive is everything of interest that was taken out of a downloaded archive
+type extractedArchive struct {
+	// directory holds the extracted files, and should be removed once they're handled
+	directory string
+
+	// filters are the paths of the extracted filters, in the order they appear in the archive
+	filters []string
+
+	sounds []extractedSound
+}
+
+type extractedSound struct {
+	path string
+
+	// relativePath is where the sound goes relative to the filters directory, which keeps the layout
+	// it had next to the filters in the archive (filters refer to their sounds by relative paths)
+	relativePath string
+}
+
+// archiveDownload keeps track of a downloaded archive while its filters are handled one by one
+type archiveDownload struct {
+	name string
+
+	// replacedTargets maps filter files that were replaced to the archive member that replaced them,
+	// so that two filters in the same archive don't fight over one target
+	replacedTargets map[string]string
+
+	// sounds are installed along with the first filter that replaces something, so they're there by
+	// the time its sounds are checked
+	sounds []extractedSound
+}
+
+func newArchiveDownload(name string, sounds []extractedSound) *archiveDownload {
+	return &archiveDownload{
+		name:            name,
+		replacedTargets: make(map[string]string),
+		sounds:          sounds,
+	}
+}
+
+// fileName is the archive's file name, or empty for filters that weren't in an archive
+func (a *archiveDownload) fileName() string {
+	if a == nil {
+		return ""
+	}
+
+	return a.name
+}
+
+func (a *archiveDownload) replacedTarget(targetFile string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+
+	member, ok := a.replacedTargets[strings.ToLower(targetFile)]
+	return member, ok
+}
+
+func (a *archiveDownload) recordReplacement(targetFile, member string) {
+	if a != nil {
+		a.replacedTargets[strings.ToLower(targetFile)] = member
+	}
+}
+
+// takeSounds returns the archive's sounds the first time it's called, and nothing after that
+func (a *archiveDownload) takeSounds() []extractedSound {
+	if a == nil {
+		return nil
+	}
+
+	sounds := a.sounds
+	a.sounds = nil
+	return sounds
+}
+
+func isArchive(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), archiveExtension)
+}
+
+func isSoundFile(name string) bool {
+	for _, extension := range soundFileExtensions {
+		if strings.EqualFold(filepath.Ext(name), extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// extractArchive extracts the filters and sounds in a zip archive into a new directory under stagingRoot.
+// Everything else in the archive is ignored
+func extractArchive(archivePath, stagingRoot string) (*extractedArchive, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open archive")
+	}
+	defer reader.Close()
+
+	if len(reader.File) > maxArchiveMembers {
+		return nil, errors.Errorf("archive has too many files in it (%d)", len(reader.File))
+	}
+
+	if err := os.MkdirAll(stagingRoot, 0755); err != nil {
+		return nil, errors.Wrap(err, "create extraction directory")
+	}
+
+	directory, err := os.MkdirTemp(stagingRoot,
+		fmt.Sprintf("%s-%s-", time.Now().UTC().Format(backupTimeFormat), strings.TrimSuffix(filepath.Base(archivePath), filepath.Ext(archivePath))))
+	if err != nil {
+		return nil, errors.Wrap(err, "create extraction directory")
+	}
+
+	extracted := &extractedArchive{directory: directory}
+	soundMembers := make([]string, 0)
+	soundPaths := make(map[string]string)
+	filterDirectories := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, member := range reader.File {
+		if member.FileInfo().IsDir() {
+			continue
+		}
+
+		isFilter := strings.EqualFold(path.Ext(member.Name), ".filter")
+		if !isFilter && !isSoundFile(member.Name) {
+			continue
+		}
+
+		memberName, err := cleanArchiveMemberName(member.Name)
+		if err != nil {
+			os.RemoveAll(directory)
+			return nil, err
+		}
+
+		// archives can have several members with the same name (or names only differing in case, which are the
+		// same file on windows). Only the first one is extracted, like most archive tools would ask to
+		if seen[strings.ToLower(memberName)] {
+			continue
+		}
+		seen[strings.ToLower(memberName)] = true
+
+		extractedPath, err := extractArchiveMember(member, directory, memberName)
+		if err != nil {
+			os.RemoveAll(directory)
+			return nil, errors.Wrapf(err, "extract %s", member.Name)
+		}
+
+		if isFilter {
+			extracted.filters = append(extracted.filters, extractedPath)
+			filterDirectories = append(filterDirectories, path.Dir(memberName))
+		} else {
+			soundMembers = append(soundMembers, memberName)
+			soundPaths[memberName] = extractedPath
+		}
+	}
+
+	// sounds are placed relative to the filters, so find the directory the filters are in (the shallowest one
+	// if there are several). Sounds outside of it go right next to the filters
+	filtersRoot := "."
+	if len(filterDirectories) > 0 {
+		depth := func(directory string) int {
+			if directory == "." {
+				return 0
+			}
+
+			return strings.Count(directory, "/") + 1
+		}
+
+		sort.SliceStable(filterDirectories, func(i, j int) bool {
+			return depth(filterDirectories[i]) < depth(filterDirectories[j])
+		})
+		filtersRoot = filterDirectories[0]
+	}
+
+	for _, memberName := range soundMembers {
+		relativePath := path.Base(memberName)
+		if filtersRoot == "." {
+			relativePath = memberName
+		} else if strings.HasPrefix(memberName, filtersRoot+"/") {
+			relativePath = strings.TrimPrefix(memberName, filtersRoot+"/")
+		}
+
+		extracted.sounds = append(extracted.sounds, extractedSound{
+			path:         soundPaths[memberName],
+			relativePath: filepath.FromSlash(relativePath),
+		})
+	}
+
+	return extracted, nil
+}
+
+// cleanArchiveMemberName turns an archive member's name into a clean, relative, slash-separated path,
+// refusing any that would end up outside of the directory it's extracted to ("zip slip")
+func cleanArchiveMemberName(name string) (string, error) {
+	normalized := strings.ReplaceAll(name, `\`, "/")
+
+	if strings.HasPrefix(normalized, "/") || filepath.VolumeName(normalized) != "" ||
+		(len(normalized) > 1 && normalized[1] == ':') {
+		return "", errors.Wrapf(errPathEscapesDirectory, "archive member %s has an absolute path", name)
+	}
+
+	cleaned := path.Clean(normalized)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errors.Wrapf(errPathEscapesDirectory, "archive member %s is outside of the archive", name)
+	}
+
+	return cleaned, nil
+}
+
+func extractArchiveMember(member *zip.File, directory, memberName string) (string, error) {
+	if member.UncompressedSize64 > maxArchiveMemberSize {
+		return "", errors.Errorf("too big (%d bytes)", member.UncompressedSize64)
+	}
+
+	targetPath, err := resolvePathUnderDirectory(directory, filepath.FromSlash(memberName))
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return "", err
+	}
+
+	source, err := member.Open()
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(targetPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer target.Close()
+
+	// the size in the archive's directory can't be trusted, so the limit is enforced while extracting too
+	written, err := io.Copy(target, io.LimitReader(source, maxArchiveMemberSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	if written > maxArchiveMemberSize {
+		return "", errors.Errorf("too big (over %d bytes)", maxArchiveMemberSize)
+	}
+
+	return targetPath, nil
+}
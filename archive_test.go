@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type testArchiveMember struct {
+	name     string
+	contents string
+}
+
+// writeTestArchive writes a zip archive with the given members, in order, and returns its path
+func writeTestArchive(t *testing.T, directory string, members ...testArchiveMember) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+
+	for _, member := range members {
+		file, err := writer.Create(member.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := file.Write([]byte(member.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(directory, "NeverSink.zip")
+	writeTestFile(t, archivePath, buf.String())
+
+	return archivePath
+}
+
+func TestCleanArchiveMemberName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "NeverSink.filter", want: "NeverSink.filter"},
+		{name: "NeverSink/sounds/alert.mp3", want: "NeverSink/sounds/alert.mp3"},
+		{name: `NeverSink\sounds\alert.mp3`, want: "NeverSink/sounds/alert.mp3"},
+		{name: "./NeverSink.filter", want: "NeverSink.filter"},
+		{name: "a/../x.filter", want: "x.filter"},
+
+		{name: "../x.filter"},
+		{name: "a/../../x.filter"},
+		{name: "/abs.filter"},
+		{name: `C:\x.filter`},
+		{name: "C:x.filter"},
+		{name: `..\x.wav`},
+		{name: `\\server\share\x.filter`},
+		{name: ".."},
+		{name: "."},
+	}
+
+	for _, test := range tests {
+		cleaned, err := cleanArchiveMemberName(test.name)
+
+		if test.want == "" {
+			if !errors.Is(err, errPathEscapesDirectory) {
+				t.Errorf("%s: got %q, %v; want it refused", test.name, cleaned, err)
+			}
+
+			continue
+		}
+
+		if err != nil || cleaned != test.want {
+			t.Errorf("%s: got %q, %v; want %q", test.name, cleaned, err, test.want)
+		}
+	}
+}
+
+func TestExtractArchiveRefusesEscapes(t *testing.T) {
+	for _, name := range []string{"../x.filter", "a/../../x.filter", "/abs.filter", `C:\x.filter`, `..\x.wav`} {
+		t.Run(name, func(t *testing.T) {
+			downloadsDirectory := t.TempDir()
+			stagingRoot := filepath.Join(downloadsDirectory, extractedDirectoryName)
+
+			archivePath := writeTestArchive(t, downloadsDirectory,
+				testArchiveMember{"NeverSink.filter", "Show\n"},
+				testArchiveMember{name, "Hide\n"})
+
+			if _, err := extractArchive(archivePath, stagingRoot); !errors.Is(err, errPathEscapesDirectory) {
+				t.Fatalf("got %v, want the archive refused", err)
+			}
+
+			// the members extracted before the bad one are cleaned up, and nothing landed anywhere else
+			if leftovers, _ := os.ReadDir(stagingRoot); len(leftovers) > 0 {
+				t.Fatalf("extraction directory left behind: %v", leftovers)
+			}
+
+			if fileExists(filepath.Join(downloadsDirectory, "x.filter")) || fileExists(filepath.Join(filepath.Dir(downloadsDirectory), "x.filter")) {
+				t.Fatal("member was extracted outside of the extraction directory")
+			}
+		})
+	}
+}
+
+func TestExtractArchiveRefusesOversizedMembers(t *testing.T) {
+	// a member that says it's too big is refused without reading it
+	t.Run("declared size", func(t *testing.T) {
+		directory := t.TempDir()
+
+		var buf bytes.Buffer
+		writer := zip.NewWriter(&buf)
+		file, err := writer.CreateRaw(&zip.FileHeader{
+			Name:               "NeverSink.filter",
+			Method:             zip.Store,
+			CompressedSize64:   5,
+			UncompressedSize64: maxArchiveMemberSize + 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file.Write([]byte("Show\n"))
+		writer.Close()
+
+		archivePath := filepath.Join(directory, "NeverSink.zip")
+		writeTestFile(t, archivePath, buf.String())
+
+		if _, err := extractArchive(archivePath, filepath.Join(directory, extractedDirectoryName)); err == nil {
+			t.Fatal("extracted a member declared bigger than the limit")
+		}
+	})
+
+	// a member that lies about its size is cut off once it goes over the limit
+	t.Run("actual size", func(t *testing.T) {
+		directory := t.TempDir()
+
+		var compressed bytes.Buffer
+		compressor, err := flate.NewWriter(&compressed, flate.BestSpeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		compressor.Write(make([]byte, maxArchiveMemberSize+1024))
+		compressor.Close()
+
+		var buf bytes.Buffer
+		writer := zip.NewWriter(&buf)
+		file, err := writer.CreateRaw(&zip.FileHeader{
+			Name:               "NeverSink.filter",
+			Method:             zip.Deflate,
+			CompressedSize64:   uint64(compressed.Len()),
+			UncompressedSize64: 1024,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file.Write(compressed.Bytes())
+		writer.Close()
+
+		archivePath := filepath.Join(directory, "NeverSink.zip")
+		writeTestFile(t, archivePath, buf.String())
+
+		stagingRoot := filepath.Join(directory, extractedDirectoryName)
+		if _, err := extractArchive(archivePath, stagingRoot); err == nil {
+			t.Fatal("extracted a member bigger than the limit")
+		}
+
+		if leftovers, _ := os.ReadDir(stagingRoot); len(leftovers) > 0 {
+			t.Fatalf("extraction directory left behind: %v", leftovers)
+		}
+	})
+}
+
+func TestExtractArchiveSoundLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+
+		wantFilters []string
+		wantSounds  []string
+	}{
+		{
+			name:        "everything at the root",
+			members:     []string{"NeverSink.filter", "sounds/alert.mp3", "drop.wav"},
+			wantFilters: []string{"NeverSink.filter"},
+			wantSounds:  []string{"drop.wav", "sounds/alert.mp3"},
+		},
+		{
+			name:        "filters in a directory",
+			members:     []string{"NeverSink/NeverSink.filter", "NeverSink/sounds/alert.mp3", "NeverSink/drop.wav"},
+			wantFilters: []string{"NeverSink.filter"},
+			wantSounds:  []string{"drop.wav", "sounds/alert.mp3"},
+		},
+		{
+			name:        "sounds outside of the filters' directory",
+			members:     []string{"NeverSink/NeverSink.filter", "extras/sounds/alert.mp3", "drop.wav"},
+			wantFilters: []string{"NeverSink.filter"},
+			wantSounds:  []string{"alert.mp3", "drop.wav"},
+		},
+		{
+			name:        "filters in nested directories",
+			members:     []string{"Pack/Strict/NeverSink-Strict.filter", "Pack/NeverSink.filter", "Pack/sounds/alert.mp3"},
+			wantFilters: []string{"NeverSink-Strict.filter", "NeverSink.filter"},
+			wantSounds:  []string{"sounds/alert.mp3"},
+		},
+		{
+			name:        "backslashes",
+			members:     []string{`NeverSink\NeverSink.filter`, `NeverSink\sounds\alert.mp3`},
+			wantFilters: []string{"NeverSink.filter"},
+			wantSounds:  []string{"sounds/alert.mp3"},
+		},
+		{
+			name:        "no filters",
+			members:     []string{"sounds/alert.mp3"},
+			wantFilters: []string{},
+			wantSounds:  []string{"sounds/alert.mp3"},
+		},
+		{
+			name:        "other files",
+			members:     []string{"NeverSink.filter", "README.md", "sounds/alert.ogg"},
+			wantFilters: []string{"NeverSink.filter"},
+			wantSounds:  []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+
+			members := make([]testArchiveMember, 0, len(test.members))
+			for _, name := range test.members {
+				members = append(members, testArchiveMember{name, name})
+			}
+
+			extracted, err := extractArchive(writeTestArchive(t, directory, members...), filepath.Join(directory, extractedDirectoryName))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			filters := make([]string, 0)
+			for _, path := range extracted.filters {
+				filters = append(filters, filepath.Base(path))
+			}
+
+			sounds := make([]string, 0)
+			for _, sound := range extracted.sounds {
+				sounds = append(sounds, filepath.ToSlash(sound.relativePath))
+
+				if !fileExists(sound.path) {
+					t.Errorf("sound %s wasn't extracted", sound.relativePath)
+				}
+			}
+			sort.Strings(sounds)
+
+			if !reflect.DeepEqual(filters, test.wantFilters) || !reflect.DeepEqual(sounds, test.wantSounds) {
+				t.Fatalf("got filters %v and sounds %v, want %v and %v", filters, sounds, test.wantFilters, test.wantSounds)
+			}
+		})
+	}
+}
+
+func TestExtractArchiveDuplicateMembers(t *testing.T) {
+	directory := t.TempDir()
+	archivePath := writeTestArchive(t, directory,
+		testArchiveMember{"NeverSink.filter", "Show\n"},
+		testArchiveMember{"sounds/alert.mp3", "first"},
+		testArchiveMember{"NeverSink.filter", "Hide\n"},
+		testArchiveMember{"NEVERSINK.filter", "Hide\n"},
+		testArchiveMember{`sounds\alert.mp3`, "second"})
+
+	extracted, err := extractArchive(archivePath, filepath.Join(directory, extractedDirectoryName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(extracted.filters) != 1 || len(extracted.sounds) != 1 {
+		t.Fatalf("got filters %v and sounds %+v, want one of each", extracted.filters, extracted.sounds)
+	}
+
+	assertFileContents(t, extracted.filters[0], "Show\n")
+	assertFileContents(t, extracted.sounds[0].path, "first")
+}
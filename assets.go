@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/omriharel/filtersnatch/filter"
+	"github.com/pkg/errors"
+)
+
+// SoundAsset is a custom alert sound a filter refers to, and whether it's where the game will look for it
+type SoundAsset struct {
+	filter.SoundReference
+
+	Exists bool `json:"exists"`
+
+	// CopiedFrom is set when the sound was missing, and got copied over from the downloads directory
+	CopiedFrom string `json:"copied_from,omitempty"`
+}
+
+// MissingAsset is a sound some filter in the filters directory plays, but which isn't there
+type MissingAsset struct {
+	Filter   string `json:"filter"`
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// soundAssetPath is where the game looks for a sound a filter refers to
+func soundAssetPath(filtersDirectory, soundPath string) string {
+	soundPath = filepath.FromSlash(strings.ReplaceAll(soundPath, `\`, "/"))
+	if filepath.IsAbs(soundPath) {
+		return soundPath
+	}
+
+	return filepath.Join(filtersDirectory, soundPath)
+}
+
+// checkSoundAssets lists the custom sounds the given filter refers to, and which of them exist
+func checkSoundAssets(filterPath, filtersDirectory string) ([]SoundAsset, error) {
+	parsed, err := filter.ParseFile(filterPath)
+	if parsed == nil {
+		return nil, errors.Wrap(err, "parse filter")
+	}
+
+	assets := make([]SoundAsset, 0)
+	for _, sound := range parsed.CustomSounds() {
+		assets = append(assets, SoundAsset{
+			SoundReference: sound,
+			Exists:         fileExists(soundAssetPath(filtersDirectory, sound.Path)),
+		})
+	}
+
+	return assets, nil
+}
+
+// findMissingAssets checks every filter in the filters directory for sounds that aren't there
+func findMissingAssets(filtersDirectory string) ([]MissingAsset, error) {
+	entries, err := os.ReadDir(filtersDirectory)
+	if err != nil {
+		return nil, errors.Wrap(err, "list filters directory")
+	}
+
+	missing := make([]MissingAsset, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".filter") {
+			continue
+		}
+
+		assets, err := checkSoundAssets(filepath.Join(filtersDirectory, entry.Name()), filtersDirectory)
+		if err != nil {
+			return nil, errors.Wrapf(err, "check sounds of %s", entry.Name())
+		}
+
+		for _, asset := range assets {
+			if !asset.Exists {
+				missing = append(missing, MissingAsset{
+					Filter:   entry.Name(),
+					Path:     asset.Path,
+					Line:     asset.Line,
+					Optional: asset.Optional,
+				})
+			}
+		}
+	}
+
+	return missing, nil
+}
+
+// findSoundInDirectory looks for a sound file with the same name as the one a filter refers to
+// (case-insensitively) directly inside the given directory
+func findSoundInDirectory(directory, soundPath string) (string, bool) {
+	wanted := filepath.Base(filepath.FromSlash(strings.ReplaceAll(soundPath, `\`, "/")))
+	if !isSoundFile(wanted) {
+		return "", false
+	}
+
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return "", false
+	}
+
+	// prefer an exact match, in case the directory has several files differing only in case
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name() == wanted && entries[j].Name() != wanted
+	})
+
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(entry.Name(), wanted) {
+			return filepath.Join(directory, entry.Name()), true
+		}
+	}
+
+	return "", false
+}
+
+// copyMissingSound copies a sound from sourcePath to where the game looks for it. Sounds outside of the
+// filters directory are never written to
+func copyMissingSound(filtersDirectory, soundPath, sourcePath string) error {
+	soundPath = filepath.FromSlash(strings.ReplaceAll(soundPath, `\`, "/"))
+
+	targetPath, err := resolvePathUnderDirectory(filtersDirectory, soundPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return errors.Wrap(err, "create sound directory")
+	}
+
+	return copyFileContents(sourcePath, targetPath)
+}
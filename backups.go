@@ -0,0 +1,229 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	backupsDirectoryName = ".filtersnatch-backups"
+	backupFileExtension  = ".bak"
+	backupTimeFormat     = "2006-01-02T15-04-05.000000000"
+)
+
+// BackupStore keeps timestamped copies of filter files before they get overwritten.
+// Backups live in a hidden directory inside the filters directory, with one subdirectory per target file
+type BackupStore struct {
+	filtersDirectory string
+
+	maxCount int
+	maxAge   time.Duration
+}
+
+type BackupEntry struct {
+	ID         string `json:"id"`
+	TargetFile string `json:"target_file"`
+	CreatedAt  string `json:"created_at"`
+	Size       int64  `json:"size"`
+
+	createdTime time.Time
+}
+
+func NewBackupStore(filtersDirectory string, maxCount int, maxAge time.Duration) *BackupStore {
+	return &BackupStore{
+		filtersDirectory: filtersDirectory,
+		maxCount:         maxCount,
+		maxAge:           maxAge,
+	}
+}
+
+// Backup copies the current contents of the given target file into the store, then enforces retention.
+// Returns an empty entry (and no error) if there's nothing to back up yet
+func (s *BackupStore) Backup(targetFile string) (BackupEntry, error) {
+	entry, err := s.backupWithoutPruning(targetFile)
+	if err != nil || entry.ID == "" {
+		return entry, err
+	}
+
+	if err := s.prune(targetFile); err != nil {
+		return entry, errors.Wrap(err, "prune old backups")
+	}
+
+	return entry, nil
+}
+
+func (s *BackupStore) backupWithoutPruning(targetFile string) (BackupEntry, error) {
+	targetPath, err := resolvePathInDirectory(s.filtersDirectory, targetFile)
+	if err != nil {
+		return BackupEntry{}, err
+	}
+
+	if !fileExists(targetPath) {
+		return BackupEntry{}, nil
+	}
+
+	backupDirectory, err := s.backupDirectoryFor(targetFile)
+	if err != nil {
+		return BackupEntry{}, err
+	}
+
+	if err := os.MkdirAll(backupDirectory, 0755); err != nil {
+		return BackupEntry{}, errors.Wrap(err, "create backup directory")
+	}
+
+	now := time.Now()
+	backupID := now.UTC().Format(backupTimeFormat)
+	backupPath := filepath.Join(backupDirectory, backupID+backupFileExtension)
+
+	if err := copyFileContents(targetPath, backupPath); err != nil {
+		return BackupEntry{}, errors.Wrap(err, "copy filter file to backup")
+	}
+
+	return s.entryFromPath(targetFile, backupPath)
+}
+
+// List returns all backups of the given target file, newest first
+func (s *BackupStore) List(targetFile string) ([]BackupEntry, error) {
+	backupDirectory, err := s.backupDirectoryFor(targetFile)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(backupDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []BackupEntry{}, nil
+		}
+
+		return nil, err
+	}
+
+	entries := make([]BackupEntry, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != backupFileExtension {
+			continue
+		}
+
+		entry, err := s.entryFromPath(targetFile, filepath.Join(backupDirectory, file.Name()))
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].createdTime.After(entries[j].createdTime)
+	})
+
+	return entries, nil
+}
+
+// Restore copies the given backup over its target file. The target's current contents are
+// backed up first, so a restore can itself be rolled back. Retention is only enforced once the
+// restore is done, so that it can't prune the very backup being restored
+func (s *BackupStore) Restore(targetFile string, backupID string) error {
+	backupPath, err := s.backupPath(targetFile, backupID)
+	if err != nil {
+		return err
+	}
+
+	if !fileExists(backupPath) {
+		return errors.Errorf("backup %s of %s doesn't exist", backupID, targetFile)
+	}
+
+	targetPath, err := resolvePathInDirectory(s.filtersDirectory, targetFile)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.backupWithoutPruning(targetFile); err != nil {
+		return errors.Wrap(err, "back up current filter before restoring")
+	}
+
+	if err := copyFileContents(backupPath, targetPath); err != nil {
+		return err
+	}
+
+	if err := s.prune(targetFile); err != nil {
+		return errors.Wrap(err, "prune old backups")
+	}
+
+	return nil
+}
+
+func (s *BackupStore) prune(targetFile string) error {
+	entries, err := s.List(targetFile)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for idx, entry := range entries {
+
+		// always keep the newest backup, no matter how old it is
+		if idx == 0 {
+			continue
+		}
+
+		tooMany := s.maxCount > 0 && idx >= s.maxCount
+		tooOld := s.maxAge > 0 && now.Sub(entry.createdTime) > s.maxAge
+
+		if tooMany || tooOld {
+			backupPath, err := s.backupPath(targetFile, entry.ID)
+			if err != nil {
+				return err
+			}
+
+			if err := os.Remove(backupPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *BackupStore) backupDirectoryFor(targetFile string) (string, error) {
+	if err := validateFilterFileName(targetFile); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(s.filtersDirectory, backupsDirectoryName, targetFile), nil
+}
+
+func (s *BackupStore) backupPath(targetFile string, backupID string) (string, error) {
+	backupDirectory, err := s.backupDirectoryFor(targetFile)
+	if err != nil {
+		return "", err
+	}
+
+	return resolvePathInDirectory(backupDirectory, backupID+backupFileExtension)
+}
+
+func (s *BackupStore) entryFromPath(targetFile string, backupPath string) (BackupEntry, error) {
+	backupID := strings.TrimSuffix(filepath.Base(backupPath), backupFileExtension)
+
+	createdTime, err := time.Parse(backupTimeFormat, backupID)
+	if err != nil {
+		return BackupEntry{}, errors.Wrapf(err, "parse backup timestamp %s", backupID)
+	}
+
+	fileInfo, err := os.Stat(backupPath)
+	if err != nil {
+		return BackupEntry{}, err
+	}
+
+	return BackupEntry{
+		ID:          backupID,
+		TargetFile:  targetFile,
+		CreatedAt:   createdTime.Local().Format(time.RFC3339),
+		Size:        fileInfo.Size(),
+		createdTime: createdTime,
+	}, nil
+}
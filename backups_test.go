@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeTestBackup puts a backup of the target file taken at the given time straight into the store
+func writeTestBackup(t *testing.T, store *BackupStore, targetFile string, createdAt time.Time, contents string) string {
+	t.Helper()
+
+	backupID := createdAt.UTC().Format(backupTimeFormat)
+	writeTestFile(t, filepath.Join(store.filtersDirectory, backupsDirectoryName, targetFile, backupID+backupFileExtension), contents)
+
+	return backupID
+}
+
+func backupIDs(t *testing.T, store *BackupStore, targetFile string) []string {
+	t.Helper()
+
+	entries, err := store.List(targetFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+
+	return ids
+}
+
+func TestBackupStoreList(t *testing.T) {
+	store := NewBackupStore(t.TempDir(), 0, 0)
+
+	if ids := backupIDs(t, store, "installed.filter"); len(ids) != 0 {
+		t.Fatalf("got %v before any backups", ids)
+	}
+
+	now := time.Now()
+	middle := writeTestBackup(t, store, "installed.filter", now.Add(-time.Hour), "middle")
+	newest := writeTestBackup(t, store, "installed.filter", now.Add(-time.Minute), "newest")
+	oldest := writeTestBackup(t, store, "installed.filter", now.Add(-24*time.Hour), "oldest")
+	writeTestBackup(t, store, "other.filter", now, "other")
+
+	// anything else in the directory isn't a backup
+	backupDirectory := filepath.Join(store.filtersDirectory, backupsDirectoryName, "installed.filter")
+	writeTestFile(t, filepath.Join(backupDirectory, "notes.txt"), "")
+	writeTestFile(t, filepath.Join(backupDirectory, "not a time.bak"), "")
+	if err := os.Mkdir(filepath.Join(backupDirectory, "directory.bak"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := backupIDs(t, store, "installed.filter"), []string{newest, middle, oldest}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	entries, _ := store.List("installed.filter")
+	if entries[0].TargetFile != "installed.filter" || entries[0].Size != int64(len("newest")) {
+		t.Fatalf("got %+v", entries[0])
+	}
+
+	if _, err := store.List("../installed.filter"); err == nil {
+		t.Fatal("listed backups of a file outside of the filters directory")
+	}
+}
+
+func TestBackupStoreBackup(t *testing.T) {
+	store := NewBackupStore(t.TempDir(), 0, 0)
+
+	entry, err := store.Backup("installed.filter")
+	if err != nil || entry.ID != "" {
+		t.Fatalf("got %+v, %v; want nothing backed up", entry, err)
+	}
+
+	writeTestFile(t, filepath.Join(store.filtersDirectory, "installed.filter"), "Show\n")
+
+	entry, err = store.Backup("installed.filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := backupIDs(t, store, "installed.filter"); !reflect.DeepEqual(ids, []string{entry.ID}) {
+		t.Fatalf("got %v, want %s", ids, entry.ID)
+	}
+
+	assertFileContents(t, filepath.Join(store.filtersDirectory, backupsDirectoryName, "installed.filter", entry.ID+backupFileExtension), "Show\n")
+}
+
+func TestBackupStoreRetention(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		maxCount int
+		maxAge   time.Duration
+		ages     []time.Duration // of the backups already there, newest first
+
+		// which of them are kept, besides the new one
+		wantKept []int
+	}{
+		{name: "no limits", ages: []time.Duration{time.Minute, time.Hour, 24 * time.Hour}, wantKept: []int{0, 1, 2}},
+		{name: "max count", maxCount: 3, ages: []time.Duration{time.Minute, time.Hour, 2 * time.Hour, 3 * time.Hour},
+			wantKept: []int{0, 1}},
+		{name: "max age", maxAge: 90 * time.Minute, ages: []time.Duration{time.Minute, time.Hour, 2 * time.Hour, 48 * time.Hour},
+			wantKept: []int{0, 1}},
+		{name: "both", maxCount: 2, maxAge: 90 * time.Minute, ages: []time.Duration{time.Minute, time.Hour, 2 * time.Hour},
+			wantKept: []int{0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewBackupStore(t.TempDir(), test.maxCount, test.maxAge)
+
+			ids := make([]string, 0, len(test.ages))
+			for _, age := range test.ages {
+				ids = append(ids, writeTestBackup(t, store, "installed.filter", now.Add(-age), age.String()))
+			}
+
+			writeTestFile(t, filepath.Join(store.filtersDirectory, "installed.filter"), "Show\n")
+			entry, err := store.Backup("installed.filter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := []string{entry.ID}
+			for _, idx := range test.wantKept {
+				want = append(want, ids[idx])
+			}
+
+			if got := backupIDs(t, store, "installed.filter"); !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	// the newest backup is kept however old it is, so there's always something to go back to
+	t.Run("newest is always kept", func(t *testing.T) {
+		store := NewBackupStore(t.TempDir(), 1, time.Hour)
+		newest := writeTestBackup(t, store, "installed.filter", now.Add(-48*time.Hour), "newest")
+		writeTestBackup(t, store, "installed.filter", now.Add(-72*time.Hour), "oldest")
+
+		if err := store.prune("installed.filter"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := backupIDs(t, store, "installed.filter"); !reflect.DeepEqual(got, []string{newest}) {
+			t.Fatalf("got %v, want %s", got, newest)
+		}
+	})
+}
+
+func TestBackupStoreRestore(t *testing.T) {
+	store := NewBackupStore(t.TempDir(), 2, 0)
+	targetPath := filepath.Join(store.filtersDirectory, "installed.filter")
+
+	now := time.Now()
+	oldest := writeTestBackup(t, store, "installed.filter", now.Add(-2*time.Hour), "oldest")
+	writeTestBackup(t, store, "installed.filter", now.Add(-time.Hour), "newer")
+	writeTestFile(t, targetPath, "current")
+
+	// restoring the oldest backup when only two are kept mustn't prune it before it's restored
+	if err := store.Restore("installed.filter", oldest); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFileContents(t, targetPath, "oldest")
+
+	entries, err := store.List("installed.filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("got %d backups, want 2", len(entries))
+	}
+
+	// what was there before the restore was backed up, so the restore can be undone
+	assertFileContents(t, filepath.Join(store.filtersDirectory, backupsDirectoryName, "installed.filter", entries[0].ID+backupFileExtension), "current")
+
+	for _, backupID := range []string{"2001-01-01T00-00-00.000000000", "../../installed.filter", ""} {
+		if err := store.Restore("installed.filter", backupID); err == nil {
+			t.Errorf("restored backup %q", backupID)
+		}
+	}
+
+	if err := store.Restore("../installed.filter", oldest); err == nil {
+		t.Error("restored a file outside of the filters directory")
+	}
+}
+
+func assertFileContents(t *testing.T, path, want string) {
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+
+	if string(got) != want {
+		t.Fatalf("%s has %q, want %q", filepath.Base(path), got, want)
+	}
+}
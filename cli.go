@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const cliUsage = `Usage: filtersnatch <command> [options]
+
+Commands:
+  watch     Watch a downloads directory and replace a filter file, without a window or tray
+  version   Print the version and exit
+  help      Show this message
+
+Run 'filtersnatch watch -h' for the watch command's options.
+`
+
+var cliCommands = map[string]bool{
+	"watch":   true,
+	"version": true,
+	"help":    true,
+	"-h":      true,
+	"-help":   true,
+	"--help":  true,
+}
+
+func isCLICommand(arg string) bool {
+	return cliCommands[arg]
+}
+
+// runCLI runs a single command and returns the process exit code
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "watch":
+		return runWatchCommand(args[1:], os.Stderr)
+
+	case "version":
+		versionString := buildVersionString()
+		if versionString == "" {
+			versionString = "Version unknown (development build)"
+		}
+
+		fmt.Println(versionString)
+		return 0
+
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n%s", args[0], cliUsage)
+	return 2
+}
+
+func runWatchCommand(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	flags.SetOutput(out)
+
+	filtersDirectory := flags.String("filters", "", "Path of Exile filters directory (required)")
+	downloadsDirectory := flags.String("downloads", "", "downloads directory to watch (required)")
+	target := flags.String("target", "", "name of the filter file to (over)write in the filters directory, which can use "+describeTargetTemplatePlaceholders()+" (required)")
+	source := flags.String("source", "", "only take downloads with this exact file name (default: any new .filter file)")
+	eventSource := flags.String("event-source", "", "how to get file events: auto, fsnotify or polling (default: from config)")
+	pollInterval := flags.Duration("poll-interval", 0, "how often to poll directories, if polling (default: from config)")
+	validation := flags.String("validation", "", "how to treat syntax errors and unknown keywords in downloads: strict or lenient (default: from config)")
+	allowDowngrade := flags.Bool("allow-downgrade", false, "replace the filter even with an older version of it")
+	copySounds := flags.Bool("copy-sounds", false, "copy custom sounds the filter is missing from the downloads directory")
+	dryRun := flags.Bool("dry-run", false, "detect downloads, but don't actually replace anything")
+	logLevel := flags.String("log-level", "info", "trace, debug, info, warning or error")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	level, ok := parseLogLevel(*logLevel)
+	if !ok {
+		fmt.Fprintf(out, "Unknown log level: %s\n", *logLevel)
+		return 2
+	}
+
+	log := newConsoleLogger(out, level)
+
+	if *filtersDirectory == "" || *downloadsDirectory == "" || *target == "" {
+		fmt.Fprintln(out, "The -filters, -downloads and -target options are all required")
+		flags.Usage()
+		return 2
+	}
+
+	for _, directory := range []string{*filtersDirectory, *downloadsDirectory} {
+		if !dirExists(directory) {
+			log.Errorf("Directory doesn't exist: %s", directory)
+			return 1
+		}
+	}
+
+	if lowerFileNamesEqual(*filtersDirectory, *downloadsDirectory) {
+		log.Error("The filters and downloads directories can't be the same")
+		return 1
+	}
+
+	if err := validateTargetTemplate(*target); err != nil {
+		log.Errorf("Invalid target file name: %v", err)
+		return 1
+	}
+
+	config, err := NewConfig(log)
+	if err != nil {
+		log.Errorf("Failed to init config: %v", err)
+		return 1
+	}
+
+	// everything from the command line only applies to this run, and isn't saved to the config
+	config.Set(configKeyFiltersDirectory, *filtersDirectory)
+	config.Set(configKeyDownloadsDirectory, *downloadsDirectory)
+	config.Set(configKeyFiltersOverwriteStrategy, string(OverwriteNamedFile))
+	config.Set(configKeyFiltersSelectedFile, *target)
+	config.Set(configKeyRules, []ReplacementRule{})
+	config.Set(configKeyWatcherRememberPaused, false)
+	config.Set(configKeyFiltersAllowDowngrade, *allowDowngrade)
+	config.Set(configKeyAssetsCopyFromDownloads, *copySounds)
+
+	if *source != "" {
+		config.Set(configKeyDownloadsWatchStrategy, string(WatchNamedFile))
+		config.Set(configKeyDownloadsNamedFile, *source)
+	} else {
+		config.Set(configKeyDownloadsWatchStrategy, string(WatchNewestFilterFile))
+	}
+
+	if *eventSource != "" {
+		if _, ok := parseEventSourceKind(*eventSource); !ok {
+			log.Errorf("Unknown event source: %s", *eventSource)
+			return 2
+		}
+
+		config.Set(configKeyWatcherEventSource, *eventSource)
+	}
+
+	if *pollInterval > 0 {
+		config.Set(configKeyWatcherPollInterval, *pollInterval)
+	}
+
+	if *validation != "" {
+		if _, ok := parseValidationMode(*validation); !ok {
+			log.Errorf("Unknown validation mode: %s", *validation)
+			return 2
+		}
+
+		config.Set(configKeyValidationMode, *validation)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	engine, err := NewEngine(ctx, config, log, logEmitter{log: log})
+	if err != nil {
+		return 1
+	}
+
+	engine.watcher.dryRun = *dryRun
+
+	log.Infof("Watching %s for new filters, replacing %s in %s", *downloadsDirectory, *target, *filtersDirectory)
+	engine.Start()
+
+	<-ctx.Done()
+	log.Info("Shutting down")
+
+	// give whatever's in flight a moment, but don't hang around forever
+	stopped := make(chan error, 1)
+	go func() { stopped <- engine.Stop() }()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			return 1
+		}
+	case <-time.After(time.Second * 5):
+		log.Warning("Timed out waiting for the watcher to stop")
+		return 1
+	}
+
+	return 0
+}
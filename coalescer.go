@@ -0,0 +1,189 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// events are held back until nothing new happened for this long, so a burst turns into a single event
+	coalesceQuietPeriod = time.Millisecond * 300
+
+	// under a constant stream of changes, events still go out at least this often
+	coalesceMaxDelay = time.Second
+)
+
+// FileChangeDirectory tells which of the watched directories a change happened in
+type FileChangeDirectory string
+
+const (
+	FileChangeFilters   FileChangeDirectory = "filters"
+	FileChangeDownloads FileChangeDirectory = "downloads"
+)
+
+// FileChange is a single file that changed in one of the watched directories
+type FileChange struct {
+	Directory FileChangeDirectory `json:"directory"`
+	File      string              `json:"file"`
+
+	// Op is the file operation, as fsnotify names it (CREATE, WRITE, REMOVE, RENAME, CHMOD)
+	Op string `json:"op"`
+}
+
+// FileChangesEvent is sent along with eventWatchEventTriggered and eventFilterFileReplaced. Changes has every
+// file that changed since the previous event of the same kind, once each (with its latest operation)
+type FileChangesEvent struct {
+	Changes []FileChange `json:"changes"`
+}
+
+// eventCoalescer batches file changes into events, and sends them from its own goroutine once things
+// quiet down. Adding changes never blocks, and nothing is dropped: whatever's pending when the burst
+// ends (or when the coalescer stops) is always sent
+type eventCoalescer struct {
+	target EventEmitter
+
+	quietPeriod time.Duration
+	maxDelay    time.Duration
+
+	lock         sync.Mutex
+	pending      map[string][]FileChange
+	order        []string
+	firstPending time.Time
+	lastPending  time.Time
+
+	wake        chan struct{}
+	stopChannel chan struct{}
+	done        chan struct{}
+}
+
+func newEventCoalescer(target EventEmitter, quietPeriod, maxDelay time.Duration) *eventCoalescer {
+	c := &eventCoalescer{
+		target:      target,
+		quietPeriod: quietPeriod,
+		maxDelay:    maxDelay,
+		pending:     make(map[string][]FileChange),
+		wake:        make(chan struct{}, 1),
+		stopChannel: make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	go c.run()
+	return c
+}
+
+// Add queues a change to be sent along with the given event
+func (c *eventCoalescer) Add(eventName string, change FileChange) {
+	now := time.Now()
+
+	c.lock.Lock()
+	if len(c.order) == 0 {
+		c.firstPending = now
+	}
+	c.lastPending = now
+
+	changes, ok := c.pending[eventName]
+	if !ok {
+		c.order = append(c.order, eventName)
+	}
+	c.pending[eventName] = mergeFileChange(changes, change)
+	c.lock.Unlock()
+
+	// the goroutine only needs to know something changed, not how many times
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop sends whatever's still pending, and waits for the coalescer's goroutine to end
+func (c *eventCoalescer) Stop() {
+	close(c.stopChannel)
+	<-c.done
+}
+
+func (c *eventCoalescer) run() {
+	defer close(c.done)
+
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+
+	for {
+		select {
+		case <-c.wake:
+			if deadline, ok := c.deadline(); ok {
+				resetTimer(timer, time.Until(deadline))
+			}
+
+		case <-timer.C:
+
+			// more changes may have come in since the timer was set, which pushes the deadline back
+			if deadline, ok := c.deadline(); ok && time.Now().Before(deadline) {
+				timer.Reset(time.Until(deadline))
+				continue
+			}
+
+			c.flush()
+
+		case <-c.stopChannel:
+			timer.Stop()
+			c.flush()
+			return
+		}
+	}
+}
+
+// deadline is when pending events should be sent, if there are any
+func (c *eventCoalescer) deadline() (time.Time, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.order) == 0 {
+		return time.Time{}, false
+	}
+
+	deadline := c.lastPending.Add(c.quietPeriod)
+	if latest := c.firstPending.Add(c.maxDelay); latest.Before(deadline) {
+		deadline = latest
+	}
+
+	return deadline, true
+}
+
+// flush sends every pending event, in the order they were first added. Sending happens outside the lock,
+// so a slow target can't hold up Add
+func (c *eventCoalescer) flush() {
+	c.lock.Lock()
+	pending, order := c.pending, c.order
+	c.pending = make(map[string][]FileChange)
+	c.order = nil
+	c.lock.Unlock()
+
+	for _, eventName := range order {
+		c.target.Emit(eventName, FileChangesEvent{Changes: pending[eventName]})
+	}
+}
+
+// mergeFileChange adds change to changes, replacing an earlier change to the same file
+func mergeFileChange(changes []FileChange, change FileChange) []FileChange {
+	for idx, existing := range changes {
+		if existing.Directory == change.Directory && existing.File == change.File {
+			changes[idx].Op = change.Op
+			return changes
+		}
+	}
+
+	return append(changes, change)
+}
+
+func resetTimer(timer *time.Timer, duration time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+
+	timer.Reset(duration)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventCoalescerMergesBursts(t *testing.T) {
+	events := newRecordingEmitter()
+	coalescer := newEventCoalescer(events, 50*time.Millisecond, time.Hour)
+	defer coalescer.Stop()
+
+	for _, change := range []FileChange{
+		{Directory: FileChangeDownloads, File: "NeverSink.filter.crdownload", Op: "CREATE"},
+		{Directory: FileChangeDownloads, File: "NeverSink.filter.crdownload", Op: "WRITE"},
+		{Directory: FileChangeDownloads, File: "NeverSink.filter", Op: "CREATE"},
+		{Directory: FileChangeDownloads, File: "NeverSink.filter.crdownload", Op: "RENAME"},
+		{Directory: FileChangeFilters, File: "NeverSink.filter", Op: "WRITE"},
+	} {
+		coalescer.Add(eventWatchEventTriggered, change)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	got := events.WaitFor(t, eventWatchEventTriggered).(FileChangesEvent)
+
+	// once per file, with its latest operation, in the order they first changed
+	want := []FileChange{
+		{Directory: FileChangeDownloads, File: "NeverSink.filter.crdownload", Op: "RENAME"},
+		{Directory: FileChangeDownloads, File: "NeverSink.filter", Op: "CREATE"},
+		{Directory: FileChangeFilters, File: "NeverSink.filter", Op: "WRITE"},
+	}
+	if !reflect.DeepEqual(got.Changes, want) {
+		t.Fatalf("got %+v, want %+v", got.Changes, want)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if count := events.Count(eventWatchEventTriggered); count != 1 {
+		t.Fatalf("burst was sent as %d events, want 1", count)
+	}
+}
+
+func TestEventCoalescerSeparatesQuietBursts(t *testing.T) {
+	events := newRecordingEmitter()
+	coalescer := newEventCoalescer(events, 20*time.Millisecond, time.Hour)
+	defer coalescer.Stop()
+
+	coalescer.Add(eventWatchEventTriggered, FileChange{Directory: FileChangeDownloads, File: "first.filter", Op: "CREATE"})
+	first := events.WaitFor(t, eventWatchEventTriggered).(FileChangesEvent)
+
+	coalescer.Add(eventWatchEventTriggered, FileChange{Directory: FileChangeDownloads, File: "second.filter", Op: "CREATE"})
+	second := events.WaitForNth(t, eventWatchEventTriggered, 2).(FileChangesEvent)
+
+	if len(first.Changes) != 1 || first.Changes[0].File != "first.filter" || len(second.Changes) != 1 || second.Changes[0].File != "second.filter" {
+		t.Fatalf("got %+v and %+v", first, second)
+	}
+}
+
+func TestEventCoalescerMaxDelay(t *testing.T) {
+	events := newRecordingEmitter()
+	coalescer := newEventCoalescer(events, 100*time.Millisecond, 200*time.Millisecond)
+
+	// changes keep coming quicker than the quiet period, which on its own would hold them back forever
+	for idx := 0; idx < 50; idx++ {
+		coalescer.Add(eventWatchEventTriggered, FileChange{Directory: FileChangeDownloads, File: fmt.Sprintf("%d.filter", idx), Op: "WRITE"})
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if count := events.Count(eventWatchEventTriggered); count < 2 {
+		t.Fatalf("sent %d events during a second of constant changes, want the max delay to send some", count)
+	}
+
+	// nothing is lost or sent twice along the way
+	coalescer.Stop()
+
+	sent := make(map[string]int)
+	for n := 1; n <= events.Count(eventWatchEventTriggered); n++ {
+		for _, change := range events.WaitForNth(t, eventWatchEventTriggered, n).(FileChangesEvent).Changes {
+			sent[change.File]++
+		}
+	}
+
+	for idx := 0; idx < 50; idx++ {
+		if file := fmt.Sprintf("%d.filter", idx); sent[file] != 1 {
+			t.Errorf("%s was sent %d times", file, sent[file])
+		}
+	}
+}
+
+func TestEventCoalescerStopFlushes(t *testing.T) {
+	events := newRecordingEmitter()
+	coalescer := newEventCoalescer(events, time.Hour, time.Hour)
+
+	coalescer.Add(eventWatchEventTriggered, FileChange{Directory: FileChangeDownloads, File: "NeverSink.filter", Op: "CREATE"})
+	coalescer.Add(eventFilterFileReplaced, FileChange{Directory: FileChangeFilters, File: "installed.filter", Op: "WRITE"})
+	coalescer.Stop()
+
+	// Stop waits for the trailing events to go out
+	if watch, replaced := events.Count(eventWatchEventTriggered), events.Count(eventFilterFileReplaced); watch != 1 || replaced != 1 {
+		t.Fatalf("sent %d watch and %d replacement events on stop, want 1 of each", watch, replaced)
+	}
+
+	events.lock.Lock()
+	order := []string{events.events[0].name, events.events[1].name}
+	events.lock.Unlock()
+
+	if want := []string{eventWatchEventTriggered, eventFilterFileReplaced}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("sent %v, want %v", order, want)
+	}
+}
+
+func TestEventCoalescerStopWithNothingPending(t *testing.T) {
+	events := newRecordingEmitter()
+	newEventCoalescer(events, time.Millisecond, time.Millisecond).Stop()
+
+	if count := len(events.events); count != 0 {
+		t.Fatalf("sent %d events with nothing pending", count)
+	}
+}
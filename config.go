@@ -1,59 +1,230 @@
 package main
 
 import (
-	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/adrg/xdg"
 	"github.com/spf13/viper"
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 const configDirAndName = "filtersnatch/config.yaml"
 
-func NewConfig(ctx context.Context) (*viper.Viper, error) {
+// Config is the user's settings. viper isn't safe for concurrent use, and the config is read by the watcher
+// and status goroutines while the UI (or tray) changes it, so every read and write goes through the lock
+type Config struct {
+	lock  sync.RWMutex
+	viper *viper.Viper
+}
+
+func newConfig(v *viper.Viper) *Config {
+	return &Config{viper: v}
+}
+
+func (c *Config) GetString(key string) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.viper.GetString(key)
+}
+
+func (c *Config) GetBool(key string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.viper.GetBool(key)
+}
+
+func (c *Config) GetInt(key string) int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.viper.GetInt(key)
+}
+
+func (c *Config) GetDuration(key string) time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.viper.GetDuration(key)
+}
+
+func (c *Config) UnmarshalKey(key string, target interface{}, opts ...viper.DecoderConfigOption) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.viper.UnmarshalKey(key, target, opts...)
+}
+
+func (c *Config) Set(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.viper.Set(key, value)
+}
+
+// WriteConfig saves the config to its file. Nothing can change it while it's being written
+func (c *Config) WriteConfig() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.viper.WriteConfig()
+}
+
+func NewConfig(log Logger) (*Config, error) {
 	configPath, err := xdg.ConfigFile(configDirAndName)
 	if err != nil {
 		return nil, err
 	}
 
-	config := viper.New()
+	config := newDefaultViper()
 	config.SetConfigName("config")
 	config.AddConfigPath(filepath.Dir(configPath))
-	config.SetConfigType("yaml")
 
-	config.SetDefault(configKeyFiltersDirectory, os.ExpandEnv(defaultLootFilterDirectory))
-	config.SetDefault(configKeyFiltersOverwriteStrategy, OverwriteSelectedFile)
-	config.SetDefault(configKeyFiltersSelectedFile, nil)
-
-	config.SetDefault(configKeyDownloadsDirectory, os.ExpandEnv(xdg.UserDirs.Download))
-	config.SetDefault(configKeyDownloadsWatchStrategy, WatchNewestFilterFile)
-	config.SetDefault(configKeyDownloadsNamedFile, nil)
-
-	config.SetDefault(configKeyWindowStartInTray, false)
+	// an old config that can't be upgraded is still loaded, whatever it has that's still valid is better than nothing
+	if err := migrateConfigFile(configPath, log); err != nil {
+		log.Errorf("Failed to upgrade config, loading it as it is: %v", err)
+	}
 
 	err = config.ReadInConfig()
 	if err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			runtime.LogWarningf(ctx, "Config not found, creating at path: %s", configPath)
+			log.Warningf("Config not found, creating at path: %s", configPath)
 
 			// create config file at target path if doesn't exist
+			config.Set(configKeyVersion, currentConfigVersion)
 			err = config.SafeWriteConfig()
 			if err != nil {
-				runtime.LogErrorf(ctx, "Failed to write config: %w", err)
+				log.Errorf("Failed to write config: %v", err)
 				return nil, err
 			}
 
-			return config, nil
+			return newConfig(config), nil
 		}
 
 		// return error in any other error case
-		runtime.LogErrorf(ctx, "Another error loading config: %w", err)
+		log.Errorf("Another error loading config: %v", err)
 		return nil, err
 	}
 
 	// return loaded config
-	runtime.LogInfo(ctx, "Loaded config successfully")
-	return config, nil
+	log.Info("Loaded config successfully")
+	return newConfig(config), nil
+}
+
+// newDefaultConfig returns a config with nothing but the defaults, and no file behind it
+func newDefaultConfig() *Config {
+	return newConfig(newDefaultViper())
+}
+
+func newDefaultViper() *viper.Viper {
+	config := viper.New()
+	config.SetConfigType("yaml")
+
+	config.SetDefault(configKeyFiltersDirectory, os.ExpandEnv(defaultLootFilterDirectory))
+	config.SetDefault(configKeyFiltersOverwriteStrategy, string(OverwriteSelectedFile))
+	config.SetDefault(configKeyFiltersSelectedFile, nil)
+	config.SetDefault(configKeyFiltersAllowDowngrade, false)
+
+	config.SetDefault(configKeyDownloadsDirectory, os.ExpandEnv(xdg.UserDirs.Download))
+	config.SetDefault(configKeyDownloadsWatchStrategy, string(WatchNewestFilterFile))
+	config.SetDefault(configKeyDownloadsNamedFile, nil)
+
+	config.SetDefault(configKeyBackupsEnabled, true)
+	config.SetDefault(configKeyBackupsMaxCount, 10)
+	config.SetDefault(configKeyBackupsMaxAge, time.Hour*24*30)
+
+	config.SetDefault(configKeyWatcherPaused, false)
+	config.SetDefault(configKeyWatcherRememberPaused, false)
+	config.SetDefault(configKeyWatcherEventSource, string(EventSourceAuto))
+	config.SetDefault(configKeyWatcherPollInterval, defaultPollInterval)
+
+	config.SetDefault(configKeyValidationMode, string(ValidationLenient))
+
+	config.SetDefault(configKeyRules, []ReplacementRule{})
+
+	config.SetDefault(configKeyAssetsCopyFromDownloads, false)
+
+	config.SetDefault(configKeyWindowStartInTray, false)
+
+	return config
+}
+
+// ConfigIssue is something wrong with the loaded config. Key is the setting it's about, if it's about one
+type ConfigIssue struct {
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+func (i ConfigIssue) String() string {
+	if i.Key == "" {
+		return i.Message
+	}
+
+	return fmt.Sprintf("%s: %s", i.Key, i.Message)
+}
+
+// validateConfig checks the loaded config for settings filtersnatch can't make sense of. These don't stop it
+// from loading: settings that are only needed by some modes are only a problem once those modes are used
+func validateConfig(config *Config) []ConfigIssue {
+	issues := make([]ConfigIssue, 0)
+
+	if version := config.GetInt(configKeyVersion); version > currentConfigVersion {
+		issues = append(issues, ConfigIssue{
+			Key: configKeyVersion,
+			Message: fmt.Sprintf("saved by a newer version of filtersnatch (version %d, this one reads up to %d), some settings may be ignored",
+				version, currentConfigVersion),
+		})
+	} else if version < currentConfigVersion {
+		issues = append(issues, ConfigIssue{
+			Key:     configKeyVersion,
+			Message: fmt.Sprintf("version %d couldn't be upgraded to %d, see the log for why", version, currentConfigVersion),
+		})
+	}
+
+	if strategy := config.GetString(configKeyFiltersOverwriteStrategy); strategy != "" {
+		if _, ok := parseOverwriteStrategy(strategy); !ok {
+			issues = append(issues, ConfigIssue{Key: configKeyFiltersOverwriteStrategy,
+				Message: fmt.Sprintf("unknown filter overwrite mode %q", strategy)})
+		}
+	}
+
+	if strategy := config.GetString(configKeyDownloadsWatchStrategy); strategy != "" {
+		if _, ok := parseWatchStrategy(strategy); !ok {
+			issues = append(issues, ConfigIssue{Key: configKeyDownloadsWatchStrategy,
+				Message: fmt.Sprintf("unknown download watch mode %q", strategy)})
+		}
+	}
+
+	if mode := config.GetString(configKeyValidationMode); mode != "" {
+		if _, ok := parseValidationMode(mode); !ok {
+			issues = append(issues, ConfigIssue{Key: configKeyValidationMode,
+				Message: fmt.Sprintf("unknown validation mode %q", mode)})
+		}
+	}
+
+	if kind := config.GetString(configKeyWatcherEventSource); kind != "" {
+		if _, ok := parseEventSourceKind(kind); !ok {
+			issues = append(issues, ConfigIssue{Key: configKeyWatcherEventSource,
+				Message: fmt.Sprintf("unknown event source %q", kind)})
+		}
+	}
+
+	for _, key := range []string{configKeyFiltersDirectory, configKeyDownloadsDirectory} {
+		if directory := config.GetString(key); directory != "" && !dirExists(os.ExpandEnv(directory)) {
+			issues = append(issues, ConfigIssue{Key: key,
+				Message: fmt.Sprintf("directory %s doesn't exist", directory)})
+		}
+	}
+
+	if rules, err := readRules(config); err != nil {
+		issues = append(issues, ConfigIssue{Key: configKeyRules, Message: fmt.Sprintf("can't be read: %v", err)})
+	} else if err := validateRules(rules); err != nil {
+		issues = append(issues, ConfigIssue{Key: configKeyRules, Message: err.Error()})
+	}
+
+	return issues
 }
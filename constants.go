@@ -38,21 +38,73 @@ func parseWatchStrategy(strategy string) (WatchStrategy, bool) {
 	return "", false
 }
 
+type ValidationMode string
+
+const (
+	ValidationStrict  ValidationMode = "strict"
+	ValidationLenient ValidationMode = "lenient"
+)
+
+func parseValidationMode(mode string) (ValidationMode, bool) {
+	switch mode {
+	case string(ValidationStrict):
+		return ValidationStrict, true
+	case string(ValidationLenient):
+		return ValidationLenient, true
+	}
+
+	return "", false
+}
+
 const (
 	eventWatchEventTriggered = "watch_event_triggered"
 	eventFilterFileReplaced  = "filter_file_replaced"
+	eventBackupRestored      = "backup_restored"
+	eventPausedStateChanged  = "paused_state_changed"
+	eventStatusChanged       = "status_changed"
+	eventDownloadQuarantined = "download_quarantined"
+
+	eventDownloadDetected     = "download_detected"
+	eventDownloadIgnored      = "download_ignored"
+	eventReplacementSucceeded = "replacement_succeeded"
+	eventReplacementFailed    = "replacement_failed"
+	eventDirectoryMissing     = "directory_missing"
+	eventDirectoryRestored    = "directory_restored"
+	eventConfigChanged        = "config_changed"
 )
 
 const (
+	configKeyVersion = "version"
+
 	configKeyFiltersDirectory         = "filters.directory"
 	configKeyFiltersOverwriteStrategy = "filters.overwrite_strategy"
 	configKeyFiltersSelectedFile      = "filters.selected_file"
+	configKeyFiltersAllowDowngrade    = "filters.allow_downgrade"
 
 	configKeyDownloadsDirectory     = "downloads.directory"
 	configKeyDownloadsWatchStrategy = "downloads.watch_strategy"
 	configKeyDownloadsNamedFile     = "downloads.named_file"
 
+	configKeyBackupsEnabled  = "backups.enabled"
+	configKeyBackupsMaxCount = "backups.max_count"
+	configKeyBackupsMaxAge   = "backups.max_age"
+
+	configKeyWatcherPaused         = "watcher.paused"
+	configKeyWatcherRememberPaused = "watcher.remember_paused"
+	configKeyWatcherEventSource    = "watcher.event_source"
+	configKeyWatcherPollInterval   = "watcher.poll_interval"
+
+	configKeyValidationMode = "validation.mode"
+
+	configKeyRules = "rules"
+
+	configKeyAssetsCopyFromDownloads = "assets.copy_from_downloads"
+
 	configKeyWindowStartInTray = "window.start_in_tray"
 )
 
-var errBannedDirectory = errors.New("banned directory")
+var (
+	errBannedDirectory      = errors.New("banned directory")
+	errInvalidFileName      = errors.New("invalid file name")
+	errPathEscapesDirectory = errors.New("path escapes directory")
+)
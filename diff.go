@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/omriharel/filtersnatch/filter"
+	"github.com/pkg/errors"
+)
+
+// how many blocks of each kind a diff stored with a history entry may list
+const historyMaxDiffEntries = 50
+
+// diffFilterFiles compares two filter files block by block. Syntax errors don't stop the comparison,
+// since whatever couldn't be parsed is still kept around as unknown statements
+func diffFilterFiles(beforePath, afterPath string) (*filter.Diff, error) {
+	before, err := filter.ParseFile(beforePath)
+	if before == nil {
+		return nil, errors.Wrap(err, "parse first filter")
+	}
+
+	after, err := filter.ParseFile(afterPath)
+	if after == nil {
+		return nil, errors.Wrap(err, "parse second filter")
+	}
+
+	return filter.Compare(before, after), nil
+}
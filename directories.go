@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// watchedDirectory is a directory the watcher was asked to watch. It can go away (and come back) at any time,
+// like when OneDrive relocates Documents or a USB drive is unplugged, so whether the event source is actually
+// watching it is tracked separately
+type watchedDirectory struct {
+	kind FileChangeDirectory
+	path string
+
+	// armed is set while the event source is watching the directory
+	armed bool
+
+	// info is the directory as it was when it was armed, to notice it being replaced by a different one
+	info os.FileInfo
+
+	// failing is set while the directory exists but can't be watched, so the failure is only logged once
+	failing bool
+}
+
+// setDirectory replaces the watched directory in current (one of the watcher's directory fields) with the given one.
+// The lock is held throughout, so concurrent changes can't leave the event source watching a stale directory.
+// Directories that don't exist yet are watched once they do (see checkDirectoryHealth)
+func (w *Watcher) setDirectory(current *watchedDirectory, directory string) {
+	w.directoriesLock.Lock()
+	defer w.directoriesLock.Unlock()
+
+	if current.path == directory && current.armed {
+		w.engine.log.Debugf("Already watching %s directory %s", current.kind, directory)
+		return
+	}
+
+	if current.armed {
+		w.engine.log.Debugf("Removing watch on previous %s directory %s", current.kind, current.path)
+		w.disarmDirectory(current)
+	}
+
+	current.path = directory
+	current.failing = false
+
+	if !dirExists(directory) {
+		w.engine.log.Warningf("The %s directory %s doesn't exist, it'll be watched once it does", current.kind, directory)
+		return
+	}
+
+	if err := w.armDirectory(current); err != nil {
+		w.engine.log.Errorf("Failed to watch %s directory %s: %v", current.kind, directory, err)
+		current.failing = true
+		return
+	}
+
+	w.engine.log.Debugf("Now watching %s directory %s", current.kind, directory)
+}
+
+// armDirectory starts watching the directory. Must be called with directoriesLock held
+func (w *Watcher) armDirectory(directory *watchedDirectory) error {
+	info, err := os.Stat(directory.path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return errors.New("not a directory")
+	}
+
+	if err := w.source.Add(directory.path); err != nil {
+		return err
+	}
+
+	directory.armed = true
+	directory.info = info
+	directory.failing = false
+	return nil
+}
+
+// disarmDirectory stops watching the directory. Must be called with directoriesLock held
+func (w *Watcher) disarmDirectory(directory *watchedDirectory) {
+
+	// the event source may have already dropped the watch by itself (fsnotify does when a directory is removed)
+	w.source.Remove(directory.path)
+
+	directory.armed = false
+	directory.info = nil
+}
+
+// checkDirectoryHealth makes sure every configured directory is watched if (and only if) it exists. Directories
+// that went away are let go of, and ones that came back (or were replaced by another directory at the same path,
+// which leaves the old watch dead) are watched again
+func (w *Watcher) checkDirectoryHealth() {
+	events := make([]Event, 0)
+
+	w.directoriesLock.Lock()
+	for _, directory := range []*watchedDirectory{&w.filtersDirectory, &w.downloadsDirectory} {
+		if directory.path == "" {
+			continue
+		}
+
+		info, err := os.Stat(directory.path)
+		exists := err == nil && info.IsDir()
+
+		switch {
+		case directory.armed && !exists:
+			w.engine.log.Warningf("The %s directory %s is gone, it'll be watched again once it's back", directory.kind, directory.path)
+			w.disarmDirectory(directory)
+			events = append(events, DirectoryMissing{Directory: directory.kind, Path: directory.path})
+			continue
+
+		case directory.armed && os.SameFile(directory.info, info):
+			continue
+
+		case directory.armed:
+			w.engine.log.Infof("The %s directory %s was replaced by a different one, watching the new one", directory.kind, directory.path)
+			w.disarmDirectory(directory)
+
+		case !exists:
+			continue
+		}
+
+		if err := w.armDirectory(directory); err != nil {
+			if !directory.failing {
+				w.engine.log.Errorf("Failed to watch %s directory %s: %v", directory.kind, directory.path, err)
+				directory.failing = true
+			}
+
+			continue
+		}
+
+		w.engine.log.Infof("The %s directory %s exists now, watching it", directory.kind, directory.path)
+		events = append(events, DirectoryRestored{Directory: directory.kind, Path: directory.path})
+	}
+	w.directoriesLock.Unlock()
+
+	for _, event := range events {
+		w.engine.emit(event)
+	}
+
+	if len(events) > 0 {
+		w.engine.refreshStatus()
+	}
+}
+
+// isWatchedDirectoryEvent tells whether the event is about one of the watched directories itself going away
+func (w *Watcher) isWatchedDirectoryEvent(event *fsnotify.Event) bool {
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) == 0 {
+		return false
+	}
+
+	name := filepath.Clean(event.Name)
+	for _, directory := range []string{w.currentFiltersDirectory(), w.currentDownloadsDirectory()} {
+		if directory != "" && name == filepath.Clean(directory) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *Watcher) currentFiltersDirectory() string {
+	w.directoriesLock.RLock()
+	defer w.directoriesLock.RUnlock()
+
+	return w.filtersDirectory.path
+}
+
+func (w *Watcher) currentDownloadsDirectory() string {
+	w.directoriesLock.RLock()
+	defer w.directoriesLock.RUnlock()
+
+	return w.downloadsDirectory.path
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// how long a finished-looking download must sit unchanged before we consider it complete
+	downloadSettleDuration = time.Millisecond * 500
+
+	// how long to keep tracking a download whose file is nowhere to be found
+	downloadAbandonDuration = time.Second * 30
+
+	downloadPollInterval = time.Millisecond * 250
+)
+
+// partialDownloadExtensions are the extensions browsers give files while they're still being downloaded.
+// Once done, the partial file is renamed to its final name (which is the partial name minus this extension)
+var partialDownloadExtensions = []string{
+	".crdownload", // chrome, edge, and other chromium-based browsers
+	".part",       // firefox
+	".download",   // safari
+}
+
+// partialFilterDownloadExtension is what some download managers give filters while they're downloading.
+// Lots of other tools leave .tmp files around too, so only "<name>.filter.tmp" counts as a partial download
+const partialFilterDownloadExtension = ".filter.tmp"
+
+// splitPartialDownloadName returns the name a partial download will eventually be renamed to,
+// and whether the given name is a partial download at all
+func splitPartialDownloadName(name string) (string, bool) {
+	lowerName := strings.ToLower(name)
+	for _, extension := range partialDownloadExtensions {
+		if strings.HasSuffix(lowerName, extension) {
+			return name[:len(name)-len(extension)], true
+		}
+	}
+
+	if strings.HasSuffix(lowerName, partialFilterDownloadExtension) {
+		return strings.TrimSuffix(name, filepath.Ext(name)), true
+	}
+
+	return name, false
+}
+
+type pendingDownload struct {
+	startTime    time.Time
+	lastActivity time.Time
+
+	// a partial file for this download exists, meaning the browser isn't done with it yet
+	partialActive bool
+
+	// the last size and modification time we saw, and when we first saw them
+	lastSize    int64
+	lastModTime time.Time
+	stableSince time.Time
+}
+
+type completedDownload struct {
+	Path     string
+	Duration time.Duration
+}
+
+// downloadDetector follows file events in a downloads directory and decides when a download is complete.
+// A download is complete once its final file exists, no partial file for it is left, and its size and
+// modification time have stayed the same for a little while. It's only ever driven by a single goroutine
+type downloadDetector struct {
+	settleDuration  time.Duration
+	abandonDuration time.Duration
+
+	stat func(name string) (os.FileInfo, error)
+	now  func() time.Time
+
+	// only files with these extensions (after stripping partial download extensions) are tracked
+	extensions []string
+
+	pending map[string]*pendingDownload
+}
+
+func newDownloadDetector(extensions ...string) *downloadDetector {
+	return &downloadDetector{
+		settleDuration:  downloadSettleDuration,
+		abandonDuration: downloadAbandonDuration,
+		stat:            os.Stat,
+		now:             time.Now,
+		extensions:      extensions,
+		pending:         make(map[string]*pendingDownload),
+	}
+}
+
+// Relevant tells whether an event is about a file this detector cares about (partial or not)
+func (d *downloadDetector) Relevant(name string) bool {
+	finalName, _ := splitPartialDownloadName(name)
+
+	for _, extension := range d.extensions {
+		if strings.EqualFold(filepath.Ext(finalName), extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Observe feeds a single file event into the detector.
+// Returns true if this event started tracking a new download
+func (d *downloadDetector) Observe(event fsnotify.Event) bool {
+	if !d.Relevant(event.Name) {
+		return false
+	}
+
+	now := d.now()
+	finalName, partial := splitPartialDownloadName(event.Name)
+	download, tracked := d.pending[finalName]
+
+	switch {
+
+	// a new file (or one renamed into place) means a download started, or moved along to its next name
+	case event.Op&fsnotify.Create == fsnotify.Create:
+		started := false
+		if !tracked {
+			download = &pendingDownload{startTime: now}
+			d.pending[finalName] = download
+			started = true
+		}
+
+		download.lastActivity = now
+		download.stableSince = time.Time{}
+		if partial {
+			download.partialActive = true
+		}
+
+		return started
+
+	case !tracked:
+		return false
+
+	case event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Chmod == fsnotify.Chmod:
+		download.lastActivity = now
+		download.stableSince = time.Time{}
+
+	// a partial file going away means the browser either finished (and is renaming it) or gave up.
+	// the final file going away means the download is gone altogether
+	case event.Op&fsnotify.Remove == fsnotify.Remove || event.Op&fsnotify.Rename == fsnotify.Rename:
+		download.lastActivity = now
+		download.stableSince = time.Time{}
+
+		if partial {
+			download.partialActive = false
+		} else {
+			delete(d.pending, finalName)
+		}
+	}
+
+	return false
+}
+
+// Poll checks every pending download, and returns the ones that completed since the last poll.
+// Downloads that seem to have been abandoned are dropped, and returned separately
+func (d *downloadDetector) Poll() (completed []completedDownload, abandoned []string) {
+	now := d.now()
+
+	for name, download := range d.pending {
+		if download.partialActive {
+			continue
+		}
+
+		info, err := d.stat(name)
+		if err != nil || info.IsDir() {
+			if now.Sub(download.lastActivity) > d.abandonDuration {
+				delete(d.pending, name)
+				abandoned = append(abandoned, name)
+			}
+
+			continue
+		}
+
+		// browsers often create an empty placeholder before the actual contents show up
+		if info.Size() == 0 {
+			if now.Sub(download.lastActivity) > d.abandonDuration {
+				delete(d.pending, name)
+				abandoned = append(abandoned, name)
+			}
+
+			continue
+		}
+
+		if download.stableSince.IsZero() || info.Size() != download.lastSize || !info.ModTime().Equal(download.lastModTime) {
+			download.lastSize = info.Size()
+			download.lastModTime = info.ModTime()
+			download.stableSince = now
+			continue
+		}
+
+		if now.Sub(download.stableSince) >= d.settleDuration && now.Sub(download.lastActivity) >= d.settleDuration {
+			delete(d.pending, name)
+			completed = append(completed, completedDownload{
+				Path:     name,
+				Duration: now.Sub(download.startTime),
+			})
+		}
+	}
+
+	return completed, abandoned
+}
+
+func (d *downloadDetector) PendingCount() int {
+	return len(d.pending)
+}
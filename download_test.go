@@ -0,0 +1,296 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeFileInfo is just enough of a file for downloadDetector to look at
+type fakeFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i fakeFileInfo) Name() string       { return i.name }
+func (i fakeFileInfo) Size() int64        { return i.size }
+func (i fakeFileInfo) Mode() os.FileMode  { return 0644 }
+func (i fakeFileInfo) ModTime() time.Time { return i.modTime }
+func (i fakeFileInfo) IsDir() bool        { return false }
+func (i fakeFileInfo) Sys() interface{}   { return nil }
+
+// downloadReplay drives a downloadDetector with recorded events, a fake clock and fake files
+type downloadReplay struct {
+	detector *downloadDetector
+
+	now   time.Time
+	files map[string]fakeFileInfo
+
+	started   int
+	completed []completedDownload
+	abandoned []string
+}
+
+func newDownloadReplay() *downloadReplay {
+	r := &downloadReplay{
+		detector: newDownloadDetector(".filter", archiveExtension),
+		now:      time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		files:    make(map[string]fakeFileInfo),
+	}
+
+	r.detector.now = func() time.Time { return r.now }
+	r.detector.stat = func(name string) (os.FileInfo, error) {
+		info, ok := r.files[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+
+		return info, nil
+	}
+
+	return r
+}
+
+// downloadStep is a single recorded event. For creates and writes, size is the file's size right after it
+type downloadStep struct {
+	op   fsnotify.Op
+	name string
+	size int64
+}
+
+func (r *downloadReplay) apply(step downloadStep) {
+	switch step.op {
+	case fsnotify.Create, fsnotify.Write:
+		r.files[step.name] = fakeFileInfo{name: step.name, size: step.size, modTime: r.now}
+	case fsnotify.Remove, fsnotify.Rename:
+		delete(r.files, step.name)
+	}
+
+	if r.detector.Observe(fsnotify.Event{Name: step.name, Op: step.op}) {
+		r.started++
+	}
+}
+
+// advance moves the clock forward by duration, polling the detector like the watcher does
+func (r *downloadReplay) advance(duration time.Duration) {
+	for end := r.now.Add(duration); r.now.Before(end); {
+		r.now = r.now.Add(downloadPollInterval)
+
+		completed, abandoned := r.detector.Poll()
+		r.completed = append(r.completed, completed...)
+		r.abandoned = append(r.abandoned, abandoned...)
+	}
+}
+
+func TestDownloadDetectorReplays(t *testing.T) {
+	in := func(name string) string { return filepath.Join("downloads", name) }
+
+	tests := []struct {
+		name  string
+		steps []downloadStep
+
+		// the download that completes or is abandoned in the end, if any
+		wantCompleted string
+		wantAbandoned string
+	}{
+		{
+			name: "chrome",
+			steps: []downloadStep{
+				{fsnotify.Create, in("Unconfirmed 123456.crdownload"), 0},
+				{fsnotify.Write, in("Unconfirmed 123456.crdownload"), 16384},
+				{fsnotify.Rename, in("Unconfirmed 123456.crdownload"), 0},
+				{fsnotify.Create, in("NeverSink.filter.crdownload"), 16384},
+				{fsnotify.Write, in("NeverSink.filter.crdownload"), 65536},
+				{fsnotify.Write, in("NeverSink.filter.crdownload"), 131072},
+				{fsnotify.Rename, in("NeverSink.filter.crdownload"), 0},
+				{fsnotify.Create, in("NeverSink.filter"), 131072},
+			},
+			wantCompleted: in("NeverSink.filter"),
+		},
+		{
+			name: "firefox",
+			steps: []downloadStep{
+				{fsnotify.Create, in("NeverSink.filter"), 0},
+				{fsnotify.Create, in("NeverSink.filter.part"), 0},
+				{fsnotify.Write, in("NeverSink.filter.part"), 65536},
+				{fsnotify.Write, in("NeverSink.filter.part"), 131072},
+				{fsnotify.Rename, in("NeverSink.filter.part"), 0},
+				{fsnotify.Create, in("NeverSink.filter"), 131072},
+			},
+			wantCompleted: in("NeverSink.filter"),
+		},
+		{
+			name: "safari",
+			steps: []downloadStep{
+				{fsnotify.Create, in("NeverSink.filter.download"), 0},
+				{fsnotify.Write, in("NeverSink.filter.download"), 131072},
+				{fsnotify.Rename, in("NeverSink.filter.download"), 0},
+				{fsnotify.Create, in("NeverSink.filter"), 131072},
+			},
+			wantCompleted: in("NeverSink.filter"),
+		},
+		{
+			name: "download manager",
+			steps: []downloadStep{
+				{fsnotify.Create, in("NeverSink.filter.tmp"), 0},
+				{fsnotify.Write, in("NeverSink.filter.tmp"), 65536},
+				{fsnotify.Write, in("NeverSink.filter.tmp"), 131072},
+				{fsnotify.Rename, in("NeverSink.filter.tmp"), 0},
+				{fsnotify.Create, in("NeverSink.filter"), 131072},
+			},
+			wantCompleted: in("NeverSink.filter"),
+		},
+		{
+			name: "editor's temp file",
+			steps: []downloadStep{
+				{fsnotify.Create, in("NeverSink.zip.tmp"), 0},
+				{fsnotify.Write, in("NeverSink.zip.tmp"), 65536},
+				{fsnotify.Remove, in("NeverSink.zip.tmp"), 0},
+			},
+		},
+		{
+			name: "copied in without a partial file",
+			steps: []downloadStep{
+				{fsnotify.Create, in("NeverSink.filter"), 0},
+				{fsnotify.Write, in("NeverSink.filter"), 65536},
+				{fsnotify.Write, in("NeverSink.filter"), 131072},
+			},
+			wantCompleted: in("NeverSink.filter"),
+		},
+		{
+			name: "chrome download cancelled",
+			steps: []downloadStep{
+				{fsnotify.Create, in("NeverSink.filter.crdownload"), 0},
+				{fsnotify.Write, in("NeverSink.filter.crdownload"), 65536},
+				{fsnotify.Remove, in("NeverSink.filter.crdownload"), 0},
+			},
+			wantAbandoned: in("NeverSink.filter"),
+		},
+		{
+			name: "firefox placeholder left empty",
+			steps: []downloadStep{
+				{fsnotify.Create, in("NeverSink.filter"), 0},
+				{fsnotify.Create, in("NeverSink.filter.part"), 0},
+				{fsnotify.Remove, in("NeverSink.filter.part"), 0},
+			},
+			wantAbandoned: in("NeverSink.filter"),
+		},
+		{
+			name: "not a filter",
+			steps: []downloadStep{
+				{fsnotify.Create, in("notes.txt.crdownload"), 0},
+				{fsnotify.Rename, in("notes.txt.crdownload"), 0},
+				{fsnotify.Create, in("notes.txt"), 100},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newDownloadReplay()
+
+			// events come in quicker than the detector settles, so nothing may complete halfway through
+			for idx, step := range test.steps {
+				r.apply(step)
+				r.advance(downloadPollInterval)
+
+				if len(r.completed) > 0 {
+					t.Fatalf("completed after step %d (%s %s), before the download was done", idx, step.op, step.name)
+				}
+			}
+
+			r.advance(downloadAbandonDuration + time.Second)
+
+			wantStarted := 1
+			if test.wantCompleted == "" && test.wantAbandoned == "" {
+				wantStarted = 0
+			}
+
+			if r.started != wantStarted {
+				t.Errorf("started %d downloads, want %d", r.started, wantStarted)
+			}
+
+			var completed []string
+			for _, download := range r.completed {
+				completed = append(completed, download.Path)
+			}
+
+			if !reflect.DeepEqual(completed, optionalList(test.wantCompleted)) {
+				t.Errorf("completed %v, want %q", completed, test.wantCompleted)
+			}
+
+			if !reflect.DeepEqual(r.abandoned, optionalList(test.wantAbandoned)) {
+				t.Errorf("abandoned %v, want %q", r.abandoned, test.wantAbandoned)
+			}
+
+			if r.detector.PendingCount() != 0 {
+				t.Errorf("%d downloads still pending", r.detector.PendingCount())
+			}
+		})
+	}
+}
+
+// optionalList is a list of just item, or an empty (nil) one if there's no item
+func optionalList(item string) []string {
+	if item == "" {
+		return nil
+	}
+
+	return []string{item}
+}
+
+func TestDownloadDetectorWaitsForWritesToSettle(t *testing.T) {
+	r := newDownloadReplay()
+	name := filepath.Join("downloads", "NeverSink.filter")
+
+	r.apply(downloadStep{fsnotify.Create, name, 1024})
+
+	// a download that keeps growing is never complete, no matter how long it takes
+	for size := int64(2048); size < 64*1024; size += 1024 {
+		r.advance(downloadSettleDuration / 2)
+		r.apply(downloadStep{fsnotify.Write, name, size})
+	}
+
+	if len(r.completed) > 0 {
+		t.Fatalf("completed while still being written: %v", r.completed)
+	}
+
+	r.advance(downloadSettleDuration * 3)
+	if len(r.completed) != 1 {
+		t.Fatalf("completed %v once writes stopped, want just %s", r.completed, name)
+	}
+}
+
+func TestSplitPartialDownloadName(t *testing.T) {
+	tests := []struct {
+		name        string
+		wantFinal   string
+		wantPartial bool
+	}{
+		{"NeverSink.filter.crdownload", "NeverSink.filter", true},
+		{"NeverSink.filter.CRDOWNLOAD", "NeverSink.filter", true},
+		{"NeverSink.filter.part", "NeverSink.filter", true},
+		{"NeverSink.filter.download", "NeverSink.filter", true},
+		{"NeverSink.filter", "NeverSink.filter", false},
+
+		{"NeverSink.filter.tmp", "NeverSink.filter", true},
+		{"NeverSink.FILTER.TMP", "NeverSink.FILTER", true},
+
+		// editors and other tools leave .tmp files around, which have nothing to do with downloads
+		{"NeverSink.zip.tmp", "NeverSink.zip.tmp", false},
+		{"notes.tmp", "notes.tmp", false},
+		{"NeverSink.filter~.tmp", "NeverSink.filter~.tmp", false},
+	}
+
+	for _, test := range tests {
+		final, partial := splitPartialDownloadName(test.name)
+		if final != test.wantFinal || partial != test.wantPartial {
+			t.Errorf("%s: got %q, %v; want %q, %v", test.name, final, partial, test.wantFinal, test.wantPartial)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// Engine is everything filtersnatch does that doesn't need a window: watching, replacing,
+// and keeping track of what happened. The UI (or the CLI) drives it, and listens to its events
+type Engine struct {
+	ctx    context.Context
+	config *Config
+	log    Logger
+	events EventEmitter
+
+	watcher  *Watcher
+	receipts *ReceiptStore
+	history  *History
+
+	pausedLock sync.RWMutex
+	paused     bool
+
+	// transitionLock makes pause transitions happen one at a time, so they're announced in the order they happened
+	transitionLock sync.Mutex
+
+	listenersLock        sync.Mutex
+	onPausedStateChanged []func(paused bool)
+	onStatusChanged      []func(status Status)
+
+	statusLock sync.Mutex
+	lastStatus *Status
+}
+
+// NewEngine sets up everything the engine needs. Only failing to create the watcher is fatal,
+// anything else just means some of the bookkeeping won't happen
+func NewEngine(ctx context.Context, config *Config, log Logger, events EventEmitter) (*Engine, error) {
+	e := &Engine{
+		ctx:    ctx,
+		config: config,
+		log:    log,
+		events: events,
+	}
+
+	if config.GetBool(configKeyWatcherRememberPaused) && config.GetBool(configKeyWatcherPaused) {
+		log.Info("Starting paused, as remembered from last run")
+		e.paused = true
+	}
+
+	var err error
+	e.receipts, err = NewReceiptStore()
+	if err != nil {
+		log.Errorf("Failed to init replacement receipts: %v", err)
+	}
+
+	e.history, err = NewHistory()
+	if err != nil {
+		log.Errorf("Failed to init action history: %v", err)
+	}
+
+	e.watcher, err = NewWatcher(e, e.newEventSource())
+	if err != nil {
+		log.Errorf("Failed to init watcher: %v", err)
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Start begins watching the configured directories. Ones that don't exist yet are watched once they do
+func (e *Engine) Start() {
+	e.watcher.Start()
+	go e.watchStatus()
+
+	if filtersDirectory := e.filtersDirectory(); filtersDirectory != "" {
+		e.watcher.SetFiltersDirectory(filtersDirectory)
+	}
+
+	if downloadsDirectory := e.downloadsDirectory(); downloadsDirectory != "" {
+		e.watcher.SetDownloadsDirectory(downloadsDirectory)
+	}
+}
+
+// filtersDirectory is the configured filters directory with environment variables (like ${USERPROFILE}) expanded,
+// which is the one the watcher watches. Anything else looking in the filters directory should use it too
+func (e *Engine) filtersDirectory() string {
+	return os.ExpandEnv(e.config.GetString(configKeyFiltersDirectory))
+}
+
+// downloadsDirectory is the configured downloads directory, expanded like filtersDirectory
+func (e *Engine) downloadsDirectory() string {
+	return os.ExpandEnv(e.config.GetString(configKeyDownloadsDirectory))
+}
+
+func (e *Engine) Stop() error {
+	return e.watcher.Stop()
+}
+
+// IsPaused is safe to call from any goroutine, including the watcher's
+func (e *Engine) IsPaused() bool {
+	e.pausedLock.RLock()
+	defer e.pausedLock.RUnlock()
+
+	return e.paused
+}
+
+// SetPaused moves the engine into the given paused state. Setting the state it's already in does nothing
+func (e *Engine) SetPaused(paused bool) {
+	e.transitionPaused(func(bool) bool { return paused })
+}
+
+// TogglePause flips the paused state, and returns the new one
+func (e *Engine) TogglePause() bool {
+	return e.transitionPaused(func(current bool) bool { return !current })
+}
+
+// transitionPaused atomically moves from the current paused state to the one picked by next.
+// Every actual change is recorded in the history, persisted if the user asked for it, and announced with an event
+func (e *Engine) transitionPaused(next func(current bool) bool) bool {
+	e.transitionLock.Lock()
+	defer e.transitionLock.Unlock()
+
+	e.pausedLock.Lock()
+	current := e.paused
+	paused := next(current)
+	e.paused = paused
+	e.pausedLock.Unlock()
+
+	if paused == current {
+		return paused
+	}
+
+	if paused {
+		e.log.Info("Pausing")
+		e.recordHistory(HistoryEntry{Kind: HistoryPaused})
+	} else {
+		e.log.Info("Resuming")
+		e.recordHistory(HistoryEntry{Kind: HistoryResumed})
+	}
+
+	if e.config.GetBool(configKeyWatcherRememberPaused) {
+		e.config.Set(configKeyWatcherPaused, paused)
+		if err := e.writeConfig(configKeyWatcherPaused); err != nil {
+			e.log.Errorf("Failed to update config: %v", err)
+		}
+	}
+
+	e.emit(PausedStateChanged{Paused: paused})
+	for _, listener := range e.pausedStateListeners() {
+		listener(paused)
+	}
+
+	e.refreshStatus()
+	return paused
+}
+
+// OnPausedStateChanged calls listener with the new paused state every time it changes. Go code (like the tray)
+// has to use this instead of listening for eventPausedStateChanged, because the frontend unsubscribing from
+// an event removes every listener of it, Go ones included
+func (e *Engine) OnPausedStateChanged(listener func(paused bool)) {
+	e.listenersLock.Lock()
+	defer e.listenersLock.Unlock()
+
+	e.onPausedStateChanged = append(e.onPausedStateChanged, listener)
+}
+
+func (e *Engine) pausedStateListeners() []func(paused bool) {
+	e.listenersLock.Lock()
+	defer e.listenersLock.Unlock()
+
+	return append([]func(paused bool){}, e.onPausedStateChanged...)
+}
+
+func (e *Engine) newBackupStore(filtersDirectory string) *BackupStore {
+	return NewBackupStore(filtersDirectory,
+		e.config.GetInt(configKeyBackupsMaxCount),
+		e.config.GetDuration(configKeyBackupsMaxAge))
+}
+
+// recordHistory appends an entry to the action history. Failing to do so is never fatal
+func (e *Engine) recordHistory(entry HistoryEntry) {
+	if e.history == nil {
+		return
+	}
+
+	if err := e.history.Append(entry); err != nil {
+		e.log.Warningf("Failed to record action history: %v", err)
+	}
+}
+
+// newEventSource creates the event source the config asks for, falling back to polling if that fails
+func (e *Engine) newEventSource() EventSource {
+	kind, ok := parseEventSourceKind(e.config.GetString(configKeyWatcherEventSource))
+	if !ok {
+		e.log.Warningf("Unknown event source '%s' in config, using %s", e.config.GetString(configKeyWatcherEventSource), EventSourceAuto)
+		kind = EventSourceAuto
+	}
+
+	pollInterval := e.config.GetDuration(configKeyWatcherPollInterval)
+
+	source, err := NewEventSource(kind, pollInterval)
+	if err != nil {
+		e.log.Errorf("Failed to create %s event source, polling instead: %v", kind, err)
+		source, _ = NewEventSource(EventSourcePolling, pollInterval)
+	}
+
+	e.log.Debugf("Using %s event source", kind)
+	return source
+}
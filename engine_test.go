@@ -0,0 +1,166 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// useTestConfigFile makes the engine save its config to a temp file, and returns the file's path
+func useTestConfigFile(t *testing.T, engine *Engine) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	engine.config.viper.SetConfigFile(path)
+
+	return path
+}
+
+// readTestConfigFile reads back what the engine saved
+func readTestConfigFile(t *testing.T, path string) *viper.Viper {
+	t.Helper()
+
+	saved := viper.New()
+	saved.SetConfigFile(path)
+	if err := saved.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	return saved
+}
+
+func TestPausedStateListenersFollowTransitions(t *testing.T) {
+	engine := newTestEngine(t, nopEmitter{})
+
+	var lock sync.Mutex
+	var heard []bool
+	engine.OnPausedStateChanged(func(paused bool) {
+		lock.Lock()
+		heard = append(heard, paused)
+		lock.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			engine.TogglePause()
+		}()
+	}
+	wg.Wait()
+
+	engine.SetPaused(engine.IsPaused())
+
+	// every toggle is a change, and listeners hear about them in the order they happened
+	if len(heard) != 50 {
+		t.Fatalf("listeners heard %d changes, want 50", len(heard))
+	}
+
+	for idx, paused := range heard {
+		if paused != (idx%2 == 0) {
+			t.Fatalf("change %d was to paused=%v, out of order: %v", idx, paused, heard)
+		}
+	}
+
+	if heard[len(heard)-1] != engine.IsPaused() {
+		t.Fatalf("last change heard was paused=%v, but the engine is paused=%v", heard[len(heard)-1], engine.IsPaused())
+	}
+}
+
+func TestStatusListenersHearTheLatestStatusLast(t *testing.T) {
+	engine := newTestEngine(t, nopEmitter{})
+
+	var lock sync.Mutex
+	var heard []Status
+	engine.OnStatusChanged(func(status Status) {
+		lock.Lock()
+		heard = append(heard, status)
+		lock.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			engine.TogglePause()
+		}()
+		go func() {
+			defer wg.Done()
+			engine.refreshStatus()
+		}()
+	}
+	wg.Wait()
+
+	// whatever order the refreshes ran in, the last status anyone heard is the current one
+	if len(heard) == 0 || heard[len(heard)-1].Paused != engine.IsPaused() {
+		t.Fatalf("last status heard doesn't match the engine's paused state (%v): %+v", engine.IsPaused(), heard)
+	}
+
+	for idx := 1; idx < len(heard); idx++ {
+		if heard[idx].Paused == heard[idx-1].Paused {
+			t.Fatalf("status %d was sent without changing", idx)
+		}
+	}
+}
+
+func TestRememberedPauseIsSavedWhileConfigIsRead(t *testing.T) {
+	engine := newTestEngine(t, nopEmitter{})
+	path := useTestConfigFile(t, engine)
+	engine.config.Set(configKeyWatcherRememberPaused, true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			engine.TogglePause()
+		}()
+
+		// the watcher and status goroutines read the config while pausing saves it
+		go func() {
+			defer wg.Done()
+			engine.replacementRules()
+			engine.computeStatus()
+		}()
+	}
+	wg.Wait()
+
+	engine.SetPaused(true)
+
+	if !readTestConfigFile(t, path).GetBool(configKeyWatcherPaused) {
+		t.Fatal("paused state wasn't saved")
+	}
+}
+
+func TestStatusRefreshesWhileConfigChanges(t *testing.T) {
+	engine := newTestEngine(t, nopEmitter{})
+	directories := []string{t.TempDir(), t.TempDir()}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			engine.config.Set(configKeyFiltersDirectory, directories[i%2])
+			engine.config.Set(configKeyRules, []ReplacementRule{})
+		}(i)
+
+		// what the status ticker does
+		go func() {
+			defer wg.Done()
+			engine.refreshStatus()
+		}()
+	}
+	wg.Wait()
+
+	engine.config.Set(configKeyFiltersDirectory, directories[0])
+	engine.refreshStatus()
+
+	if status := engine.computeStatus(); status.FiltersDirectory.Path != directories[0] || !status.FiltersDirectory.Exists {
+		t.Fatalf("status has %+v, want %s", status.FiltersDirectory, directories[0])
+	}
+}
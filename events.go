@@ -0,0 +1,180 @@
+package main
+
+// Event is the payload of one of the engine's events. Every event is sent under its EventName, with the
+// payload as its only argument, so whoever listens (the frontend, the CLI's log, or anything else) can
+// tell exactly what happened without asking again. File changes are batched by the coalescer, which sends
+// them the same way (see FileChangesEvent)
+type Event interface {
+	EventName() string
+}
+
+// DownloadDetected is sent when a filter (or an archive of them) starts downloading
+type DownloadDetected struct {
+	DownloadedFile string `json:"downloaded_file"`
+}
+
+// DownloadIgnored is sent when a downloaded filter doesn't replace anything, along with why
+type DownloadIgnored struct {
+	DownloadedFile string `json:"downloaded_file"`
+	Archive        string `json:"archive,omitempty"`
+	TargetFile     string `json:"target_file,omitempty"`
+	Rule           string `json:"rule,omitempty"`
+	Reason         string `json:"reason"`
+}
+
+// ReplacementSucceeded is sent once a downloaded filter replaced (or, in dry runs, would have replaced)
+// a filter file
+type ReplacementSucceeded struct {
+	DownloadedFile string `json:"downloaded_file"`
+	Archive        string `json:"archive,omitempty"`
+	TargetFile     string `json:"target_file"`
+	Rule           string `json:"rule,omitempty"`
+
+	// Receipt is nil in dry runs
+	Receipt *ReplacementReceipt `json:"receipt,omitempty"`
+
+	// Changes summarizes how the filter changed, if there was one to compare with
+	Changes string `json:"changes,omitempty"`
+
+	// LintIssues counts the lint warnings kept in the history, which has at most historyMaxLintIssues of them
+	LintIssues    int      `json:"lint_issues"`
+	MissingSounds []string `json:"missing_sounds,omitempty"`
+}
+
+// ReplacementFailed is sent when a downloaded filter should have replaced a filter file, but couldn't
+type ReplacementFailed struct {
+	DownloadedFile string `json:"downloaded_file"`
+	Archive        string `json:"archive,omitempty"`
+	TargetFile     string `json:"target_file,omitempty"`
+	Rule           string `json:"rule,omitempty"`
+	Error          string `json:"error"`
+}
+
+// DirectoryMissing is sent when a watched directory goes away. It's watched again once it's back
+type DirectoryMissing struct {
+	Directory FileChangeDirectory `json:"directory"`
+	Path      string              `json:"path"`
+}
+
+// DirectoryRestored is sent when a configured directory that was missing (or was replaced by another
+// directory at the same path) is being watched again
+type DirectoryRestored struct {
+	Directory FileChangeDirectory `json:"directory"`
+	Path      string              `json:"path"`
+}
+
+// BackupRestored is sent when a filter file is rolled back to one of its backups
+type BackupRestored struct {
+	TargetFile string `json:"target_file"`
+	BackupID   string `json:"backup_id"`
+}
+
+// PausedStateChanged is sent whenever the watcher is paused or resumed
+type PausedStateChanged struct {
+	Paused bool `json:"paused"`
+}
+
+// ConfigChanged is sent whenever the config is saved, with the keys that changed
+type ConfigChanged struct {
+	Keys []string `json:"keys"`
+}
+
+func (DownloadDetected) EventName() string     { return eventDownloadDetected }
+func (DownloadIgnored) EventName() string      { return eventDownloadIgnored }
+func (ReplacementSucceeded) EventName() string { return eventReplacementSucceeded }
+func (ReplacementFailed) EventName() string    { return eventReplacementFailed }
+func (DirectoryMissing) EventName() string     { return eventDirectoryMissing }
+func (DirectoryRestored) EventName() string    { return eventDirectoryRestored }
+func (BackupRestored) EventName() string       { return eventBackupRestored }
+func (PausedStateChanged) EventName() string   { return eventPausedStateChanged }
+func (ConfigChanged) EventName() string        { return eventConfigChanged }
+
+// EventPayloads has a field for every event payload type. Wails only generates frontend models for types
+// that bound methods use, so App.GetEventPayloads returns it just to get them all generated
+type EventPayloads struct {
+	DownloadDetected     DownloadDetected         `json:"download_detected"`
+	DownloadIgnored      DownloadIgnored          `json:"download_ignored"`
+	DownloadQuarantined  DownloadQuarantinedEvent `json:"download_quarantined"`
+	ReplacementSucceeded ReplacementSucceeded     `json:"replacement_succeeded"`
+	ReplacementFailed    ReplacementFailed        `json:"replacement_failed"`
+	DirectoryMissing     DirectoryMissing         `json:"directory_missing"`
+	DirectoryRestored    DirectoryRestored        `json:"directory_restored"`
+	BackupRestored       BackupRestored           `json:"backup_restored"`
+	PausedStateChanged   PausedStateChanged       `json:"paused_state_changed"`
+	ConfigChanged        ConfigChanged            `json:"config_changed"`
+	FileChanges          FileChangesEvent         `json:"file_changes"`
+}
+
+// emit sends a typed event
+func (e *Engine) emit(event Event) {
+	e.events.Emit(event.EventName(), event)
+}
+
+// report records what happened in the history, and sends the matching event
+func (e *Engine) report(entry HistoryEntry) {
+	e.recordHistory(entry)
+
+	if event, ok := eventForHistoryEntry(entry); ok {
+		e.emit(event)
+	}
+}
+
+// eventForHistoryEntry turns a history entry about a download into the event announcing it
+func eventForHistoryEntry(entry HistoryEntry) (Event, bool) {
+	switch entry.Kind {
+	case HistoryDownloadDetected:
+		return DownloadDetected{DownloadedFile: entry.DownloadedFile}, true
+
+	case HistoryDownloadSkipped:
+		return DownloadIgnored{
+			DownloadedFile: entry.DownloadedFile,
+			Archive:        entry.Archive,
+			TargetFile:     entry.TargetFile,
+			Rule:           entry.Rule,
+			Reason:         entry.Reason,
+		}, true
+
+	case HistoryReplacement:
+		event := ReplacementSucceeded{
+			DownloadedFile: entry.DownloadedFile,
+			Archive:        entry.Archive,
+			TargetFile:     entry.TargetFile,
+			Rule:           entry.Rule,
+			Receipt:        entry.Receipt,
+			LintIssues:     len(entry.Lint),
+		}
+
+		if entry.Diff != nil {
+			event.Changes = entry.Diff.Summary()
+		}
+
+		for _, sound := range entry.Sounds {
+			if !sound.Exists && !sound.Optional {
+				event.MissingSounds = append(event.MissingSounds, sound.Path)
+			}
+		}
+
+		return event, true
+
+	case HistoryReplacementFailed:
+		return ReplacementFailed{
+			DownloadedFile: entry.DownloadedFile,
+			Archive:        entry.Archive,
+			TargetFile:     entry.TargetFile,
+			Rule:           entry.Rule,
+			Error:          entry.Error,
+		}, true
+	}
+
+	return nil, false
+}
+
+// writeConfig saves the config, and announces which keys changed
+func (e *Engine) writeConfig(keys ...string) error {
+	if err := e.config.WriteConfig(); err != nil {
+		return err
+	}
+
+	e.emit(ConfigChanged{Keys: keys})
+	return nil
+}
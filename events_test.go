@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEventPayloadsAreEvents(t *testing.T) {
+	eventType := reflect.TypeOf((*Event)(nil)).Elem()
+	payloads := reflect.TypeOf(EventPayloads{})
+
+	for idx := 0; idx < payloads.NumField(); idx++ {
+		field := payloads.Field(idx)
+
+		// file changes are sent under two names, so they can't have an EventName
+		if field.Type == reflect.TypeOf(FileChangesEvent{}) {
+			continue
+		}
+
+		if !field.Type.Implements(eventType) {
+			t.Errorf("%s isn't an Event", field.Type.Name())
+			continue
+		}
+
+		if name := reflect.Zero(field.Type).Interface().(Event).EventName(); name != field.Tag.Get("json") {
+			t.Errorf("%s is sent as %s, but listed as %s", field.Type.Name(), name, field.Tag.Get("json"))
+		}
+	}
+}
+
+func TestPausedStateChangedEvent(t *testing.T) {
+	events := newRecordingEmitter()
+	engine := newTestEngine(t, events)
+
+	engine.TogglePause()
+	engine.TogglePause()
+
+	for n, want := range []bool{true, false} {
+		if event := events.WaitForNth(t, eventPausedStateChanged, n+1); event != (PausedStateChanged{Paused: want}) {
+			t.Fatalf("event %d is %#v, want paused=%v", n+1, event, want)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// EventSource delivers file events for a set of watched directories (non-recursively).
+// Events use fsnotify's types regardless of where they actually come from
+type EventSource interface {
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Add(directory string) error
+	Remove(directory string) error
+	Close() error
+}
+
+type EventSourceKind string
+
+const (
+	EventSourceAuto     EventSourceKind = "auto"
+	EventSourceFsnotify EventSourceKind = "fsnotify"
+	EventSourcePolling  EventSourceKind = "polling"
+)
+
+func parseEventSourceKind(kind string) (EventSourceKind, bool) {
+	switch kind {
+	case string(EventSourceAuto):
+		return EventSourceAuto, true
+	case string(EventSourceFsnotify):
+		return EventSourceFsnotify, true
+	case string(EventSourcePolling):
+		return EventSourcePolling, true
+	}
+
+	return "", false
+}
+
+const (
+	defaultPollInterval = time.Second
+	eventChannelSize    = 64
+)
+
+// NewEventSource creates an event source of the given kind. Auto uses native notifications wherever
+// they're expected to work, and polls directories on filesystems that are known not to send them
+func NewEventSource(kind EventSourceKind, pollInterval time.Duration) (EventSource, error) {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	switch kind {
+	case EventSourceFsnotify:
+		return newFsnotifyEventSource()
+
+	case EventSourcePolling:
+		return newPollingEventSource(pollInterval), nil
+
+	case EventSourceAuto:
+
+		// no native notifications at all is still something we can work with
+		native, err := newFsnotifyEventSource()
+		if err != nil {
+			return newPollingEventSource(pollInterval), nil
+		}
+
+		return newHybridEventSource(native, newPollingEventSource(pollInterval), isRemoteFilesystem), nil
+	}
+
+	return nil, errors.Errorf("unknown event source: %s", kind)
+}
+
+type fsnotifyEventSource struct {
+	watcher *fsnotify.Watcher
+}
+
+func newFsnotifyEventSource() (*fsnotifyEventSource, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fsnotifyEventSource{watcher: watcher}, nil
+}
+
+func (s *fsnotifyEventSource) Events() <-chan fsnotify.Event { return s.watcher.Events }
+func (s *fsnotifyEventSource) Errors() <-chan error          { return s.watcher.Errors }
+func (s *fsnotifyEventSource) Add(directory string) error    { return s.watcher.Add(directory) }
+func (s *fsnotifyEventSource) Remove(directory string) error { return s.watcher.Remove(directory) }
+func (s *fsnotifyEventSource) Close() error                  { return s.watcher.Close() }
+
+type fileSnapshot struct {
+	size    int64
+	modTime time.Time
+}
+
+// pollingEventSource compares directory listings every interval, and turns the differences into events.
+// It's slower than native notifications, but works on filesystems that never send any
+type pollingEventSource struct {
+	interval time.Duration
+
+	events chan fsnotify.Event
+	errors chan error
+
+	lock        sync.Mutex
+	directories map[string]map[string]fileSnapshot
+	failing     map[string]bool
+
+	stopChannel chan struct{}
+	closeOnce   sync.Once
+}
+
+func newPollingEventSource(interval time.Duration) *pollingEventSource {
+	s := &pollingEventSource{
+		interval:    interval,
+		events:      make(chan fsnotify.Event, eventChannelSize),
+		errors:      make(chan error, eventChannelSize),
+		directories: make(map[string]map[string]fileSnapshot),
+		failing:     make(map[string]bool),
+		stopChannel: make(chan struct{}),
+	}
+
+	go s.run()
+	return s
+}
+
+func (s *pollingEventSource) Events() <-chan fsnotify.Event { return s.events }
+func (s *pollingEventSource) Errors() <-chan error          { return s.errors }
+
+func (s *pollingEventSource) Add(directory string) error {
+	snapshot, err := snapshotDirectory(directory)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.directories[filepath.Clean(directory)] = snapshot
+	return nil
+}
+
+func (s *pollingEventSource) Remove(directory string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	directory = filepath.Clean(directory)
+	if _, ok := s.directories[directory]; !ok {
+		return errors.Errorf("can't remove non-existent polled directory: %s", directory)
+	}
+
+	delete(s.directories, directory)
+	delete(s.failing, directory)
+	return nil
+}
+
+func (s *pollingEventSource) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stopChannel)
+	})
+
+	return nil
+}
+
+func (s *pollingEventSource) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			events, errs := s.poll()
+
+			// sending happens outside the lock, so a slow consumer can't block Add and Remove
+			for _, event := range events {
+				select {
+				case s.events <- event:
+				case <-s.stopChannel:
+					return
+				}
+			}
+
+			for _, err := range errs {
+				select {
+				case s.errors <- err:
+				case <-s.stopChannel:
+					return
+				}
+			}
+
+		case <-s.stopChannel:
+			return
+		}
+	}
+}
+
+func (s *pollingEventSource) poll() ([]fsnotify.Event, []error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var events []fsnotify.Event
+	var errs []error
+
+	for directory, previous := range s.directories {
+		current, err := snapshotDirectory(directory)
+		if err != nil {
+
+			// only complain once per failure streak, not on every tick
+			if !s.failing[directory] {
+				errs = append(errs, errors.Wrapf(err, "poll directory %s", directory))
+				s.failing[directory] = true
+			}
+
+			continue
+		}
+
+		delete(s.failing, directory)
+		events = append(events, diffSnapshots(directory, previous, current)...)
+		s.directories[directory] = current
+	}
+
+	return events, errs
+}
+
+func snapshotDirectory(directory string) (map[string]fileSnapshot, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]fileSnapshot, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		snapshot[entry.Name()] = fileSnapshot{size: info.Size(), modTime: info.ModTime()}
+	}
+
+	return snapshot, nil
+}
+
+// diffSnapshots produces the events that would have turned previous into current.
+// Renames can't be told apart from a removal plus a creation, so that's what they're reported as
+func diffSnapshots(directory string, previous, current map[string]fileSnapshot) []fsnotify.Event {
+	var events []fsnotify.Event
+
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			events = append(events, fsnotify.Event{Name: filepath.Join(directory, name), Op: fsnotify.Remove})
+		}
+	}
+
+	for name, snapshot := range current {
+		previousSnapshot, existed := previous[name]
+
+		if !existed {
+			events = append(events, fsnotify.Event{Name: filepath.Join(directory, name), Op: fsnotify.Create})
+		} else if snapshot.size != previousSnapshot.size || !snapshot.modTime.Equal(previousSnapshot.modTime) {
+			events = append(events, fsnotify.Event{Name: filepath.Join(directory, name), Op: fsnotify.Write})
+		}
+	}
+
+	return events
+}
+
+// hybridEventSource routes every directory to either a native or a polling source, and merges their events
+type hybridEventSource struct {
+	native  EventSource
+	polling EventSource
+
+	shouldPoll func(directory string) bool
+
+	events chan fsnotify.Event
+	errors chan error
+
+	lock   sync.Mutex
+	routes map[string]EventSource
+
+	stopChannel chan struct{}
+	closeOnce   sync.Once
+}
+
+func newHybridEventSource(native, polling EventSource, shouldPoll func(directory string) bool) *hybridEventSource {
+	s := &hybridEventSource{
+		native:      native,
+		polling:     polling,
+		shouldPoll:  shouldPoll,
+		events:      make(chan fsnotify.Event, eventChannelSize),
+		errors:      make(chan error, eventChannelSize),
+		routes:      make(map[string]EventSource),
+		stopChannel: make(chan struct{}),
+	}
+
+	go s.forward(native)
+	go s.forward(polling)
+	return s
+}
+
+func (s *hybridEventSource) Events() <-chan fsnotify.Event { return s.events }
+func (s *hybridEventSource) Errors() <-chan error          { return s.errors }
+
+func (s *hybridEventSource) Add(directory string) error {
+	source := s.native
+	if s.shouldPoll(directory) {
+		source = s.polling
+	}
+
+	// if native notifications refuse the directory, polling it is the next best thing
+	if err := source.Add(directory); err != nil {
+		if source == s.polling {
+			return err
+		}
+
+		source = s.polling
+		if err := source.Add(directory); err != nil {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.routes[filepath.Clean(directory)] = source
+	return nil
+}
+
+func (s *hybridEventSource) Remove(directory string) error {
+	s.lock.Lock()
+	source, ok := s.routes[filepath.Clean(directory)]
+	delete(s.routes, filepath.Clean(directory))
+	s.lock.Unlock()
+
+	if !ok {
+		return errors.Errorf("can't remove non-existent watched directory: %s", directory)
+	}
+
+	return source.Remove(directory)
+}
+
+func (s *hybridEventSource) Close() error {
+	var err error
+
+	s.closeOnce.Do(func() {
+		close(s.stopChannel)
+
+		nativeErr := s.native.Close()
+		pollingErr := s.polling.Close()
+
+		if nativeErr != nil {
+			err = nativeErr
+		} else {
+			err = pollingErr
+		}
+	})
+
+	return err
+}
+
+func (s *hybridEventSource) forward(source EventSource) {
+	for {
+		select {
+		case event, ok := <-source.Events():
+			if !ok {
+				return
+			}
+
+			select {
+			case s.events <- event:
+			case <-s.stopChannel:
+				return
+			}
+
+		case err, ok := <-source.Errors():
+			if !ok {
+				return
+			}
+
+			select {
+			case s.errors <- err:
+			case <-s.stopChannel:
+				return
+			}
+
+		case <-s.stopChannel:
+			return
+		}
+	}
+}
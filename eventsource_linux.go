@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// filesystem types that are known to not (reliably) send inotify events for changes made elsewhere
+var remoteFilesystemTypes = map[string]bool{
+	"nfs": true, "nfs4": true, "cifs": true, "smb3": true, "smbfs": true,
+	"9p": true, "drvfs": true, "virtiofs": true, "sshfs": true,
+}
+
+// isRemoteFilesystem finds the mount a directory lives on, and checks if it's a network or FUSE mount
+func isRemoteFilesystem(directory string) bool {
+	mounts, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return false
+	}
+	defer mounts.Close()
+
+	directory = filepath.Clean(directory)
+	longestMountPoint := ""
+	mountType := ""
+
+	scanner := bufio.NewScanner(mounts)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		// spaces and such in mount points are octal-escaped
+		mountPoint := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\134`, `\`).Replace(fields[1])
+
+		if directory != mountPoint && !strings.HasPrefix(directory, strings.TrimSuffix(mountPoint, "/")+"/") {
+			continue
+		}
+
+		if len(mountPoint) >= len(longestMountPoint) {
+			longestMountPoint = mountPoint
+			mountType = fields[2]
+		}
+	}
+
+	return remoteFilesystemTypes[mountType] || strings.HasPrefix(mountType, "fuse")
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// fakeEventSource only sends the events it's told to. Like the real sources, it refuses to watch
+// directories that don't exist
+type fakeEventSource struct {
+	events chan fsnotify.Event
+	errors chan error
+
+	lock    sync.Mutex
+	watched map[string]bool
+	addErr  error
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFakeEventSource() *fakeEventSource {
+	return &fakeEventSource{
+		events:  make(chan fsnotify.Event),
+		errors:  make(chan error),
+		watched: make(map[string]bool),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (s *fakeEventSource) Events() <-chan fsnotify.Event { return s.events }
+func (s *fakeEventSource) Errors() <-chan error          { return s.errors }
+
+func (s *fakeEventSource) Add(directory string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.addErr != nil {
+		return s.addErr
+	}
+
+	if !dirExists(directory) {
+		return os.ErrNotExist
+	}
+
+	s.watched[filepath.Clean(directory)] = true
+	return nil
+}
+
+func (s *fakeEventSource) Remove(directory string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.watched[filepath.Clean(directory)] {
+		return errors.Errorf("not watching %s", directory)
+	}
+
+	delete(s.watched, filepath.Clean(directory))
+	return nil
+}
+
+func (s *fakeEventSource) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+// Watching tells whether the directory is currently watched
+func (s *fakeEventSource) Watching(directory string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.watched[filepath.Clean(directory)]
+}
+
+// WatchedDirectories returns every directory currently watched, sorted
+func (s *fakeEventSource) WatchedDirectories() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	directories := make([]string, 0, len(s.watched))
+	for directory := range s.watched {
+		directories = append(directories, directory)
+	}
+	sort.Strings(directories)
+
+	return directories
+}
+
+// Send delivers an event, waiting until it's taken (or the source is closed). Events for directories that
+// aren't watched (or about anything but them) are dropped, like they would be by a real source
+func (s *fakeEventSource) Send(event fsnotify.Event) bool {
+	if !s.Watching(event.Name) && !s.Watching(filepath.Dir(event.Name)) {
+		return false
+	}
+
+	select {
+	case s.events <- event:
+		return true
+	case <-s.closed:
+		return false
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	directory := "downloads"
+	then := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	later := then.Add(time.Second)
+
+	previous := map[string]fileSnapshot{
+		"unchanged.filter": {size: 10, modTime: then},
+		"grown.filter":     {size: 10, modTime: then},
+		"touched.filter":   {size: 10, modTime: then},
+		"removed.filter":   {size: 10, modTime: then},
+		"renamed.filter":   {size: 10, modTime: then},
+	}
+
+	current := map[string]fileSnapshot{
+		"unchanged.filter":     {size: 10, modTime: then},
+		"grown.filter":         {size: 20, modTime: then},
+		"touched.filter":       {size: 10, modTime: later},
+		"new.filter":           {size: 10, modTime: later},
+		"renamed again.filter": {size: 10, modTime: then},
+	}
+
+	want := []string{
+		"CREATE new.filter",
+		"CREATE renamed again.filter",
+		"REMOVE removed.filter",
+		"REMOVE renamed.filter",
+		"WRITE grown.filter",
+		"WRITE touched.filter",
+	}
+
+	var got []string
+	for _, event := range diffSnapshots(directory, previous, current) {
+		if filepath.Dir(event.Name) != directory {
+			t.Errorf("event for %s isn't in %s", event.Name, directory)
+		}
+
+		got = append(got, event.Op.String()+" "+filepath.Base(event.Name))
+	}
+	sort.Strings(got)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if events := diffSnapshots(directory, current, current); len(events) > 0 {
+		t.Fatalf("got %v between identical snapshots", events)
+	}
+}
+
+// nextEvent waits for the next event the source sends, failing the test if it takes too long
+func nextEvent(t *testing.T, source EventSource) fsnotify.Event {
+	t.Helper()
+
+	select {
+	case event := <-source.Events():
+		return event
+	case err := <-source.Errors():
+		t.Fatalf("got error instead of event: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+
+	return fsnotify.Event{}
+}
+
+func TestPollingEventSource(t *testing.T) {
+	directory := t.TempDir()
+	path := filepath.Join(directory, "NeverSink.filter")
+
+	source := newPollingEventSource(10 * time.Millisecond)
+	defer source.Close()
+
+	if err := source.Add(directory); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(op fsnotify.Op, name string) {
+		t.Helper()
+
+		if event := nextEvent(t, source); event.Op != op || event.Name != name {
+			t.Fatalf("got %s, want %s %s", event, op, name)
+		}
+	}
+
+	writeTestFile(t, path, "Show\n")
+	expect(fsnotify.Create, path)
+
+	writeTestFile(t, path, "Show\nHide\n")
+	expect(fsnotify.Write, path)
+
+	renamed := filepath.Join(directory, "NeverSink-2.filter")
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatal(err)
+	}
+
+	// renames can't be told apart from a removal and a creation, which can come in either order
+	first, second := nextEvent(t, source), nextEvent(t, source)
+	if first.Op == fsnotify.Create {
+		first, second = second, first
+	}
+
+	if first.Op != fsnotify.Remove || first.Name != path || second.Op != fsnotify.Create || second.Name != renamed {
+		t.Fatalf("got %s and %s for a rename", first, second)
+	}
+
+	if err := os.Remove(renamed); err != nil {
+		t.Fatal(err)
+	}
+	expect(fsnotify.Remove, renamed)
+
+	// a directory that's gone is only complained about once, and picked up again when it's back
+	if err := os.Remove(directory); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-source.Errors():
+		if err == nil {
+			t.Fatal("got nil error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error for a missing directory")
+	}
+
+	select {
+	case err := <-source.Errors():
+		t.Fatalf("complained again about the same missing directory: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := os.Mkdir(directory, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, path, "Show\n")
+	expect(fsnotify.Create, path)
+
+	if err := source.Remove(directory); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := source.Remove(directory); err == nil {
+		t.Fatal("removed a directory that wasn't polled")
+	}
+}
+
+func TestHybridEventSource(t *testing.T) {
+	native, polling := newFakeEventSource(), newFakeEventSource()
+	local, remote, refused := t.TempDir(), t.TempDir(), t.TempDir()
+
+	source := newHybridEventSource(native, polling, func(directory string) bool { return directory == remote })
+	defer source.Close()
+
+	for _, directory := range []string{local, remote} {
+		if err := source.Add(directory); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// directories native notifications refuse are polled instead
+	native.lock.Lock()
+	native.addErr = errors.New("too many watches")
+	native.lock.Unlock()
+
+	if err := source.Add(refused); err != nil {
+		t.Fatal(err)
+	}
+
+	if !native.Watching(local) || native.Watching(remote) || native.Watching(refused) {
+		t.Fatal("native source watches the wrong directories")
+	}
+
+	if polling.Watching(local) || !polling.Watching(remote) || !polling.Watching(refused) {
+		t.Fatal("polling source polls the wrong directories")
+	}
+
+	// events from both sources come out of the same channel
+	go native.Send(fsnotify.Event{Name: filepath.Join(local, "a.filter"), Op: fsnotify.Create})
+	if event := nextEvent(t, source); event.Name != filepath.Join(local, "a.filter") {
+		t.Fatalf("got %s from the native source", event)
+	}
+
+	go polling.Send(fsnotify.Event{Name: filepath.Join(remote, "b.filter"), Op: fsnotify.Create})
+	if event := nextEvent(t, source); event.Name != filepath.Join(remote, "b.filter") {
+		t.Fatalf("got %s from the polling source", event)
+	}
+
+	// removing goes to whichever source the directory was routed to
+	if err := source.Remove(refused); err != nil || polling.Watching(refused) {
+		t.Fatalf("refused directory is still polled: %v", err)
+	}
+
+	if err := source.Remove(refused); err == nil {
+		t.Fatal("removed a directory that wasn't watched")
+	}
+}
+
+func TestWatcherReplacesPendingDownloads(t *testing.T) {
+	filtersDirectory, downloadsDirectory := t.TempDir(), t.TempDir()
+	source := newFakeEventSource()
+	events := newRecordingEmitter()
+
+	watcher := newTestWatcher(t, source, events, "", "")
+	watcher.engine.config.Set(configKeyFiltersOverwriteStrategy, string(OverwriteNamedFile))
+	watcher.engine.config.Set(configKeyFiltersSelectedFile, "installed.filter")
+
+	watcher.Start()
+	defer watcher.Stop()
+
+	watcher.SetFiltersDirectory(filtersDirectory)
+	watcher.SetDownloadsDirectory(downloadsDirectory)
+
+	contents := "Show\n\tBaseType == \"Mirror of Kalandra\"\n"
+	partial := filepath.Join(downloadsDirectory, "NeverSink.filter.crdownload")
+	download := filepath.Join(downloadsDirectory, "NeverSink.filter")
+
+	// the way chrome downloads it
+	writeTestFile(t, partial, contents[:10])
+	source.Send(fsnotify.Event{Name: partial, Op: fsnotify.Create})
+	writeTestFile(t, partial, contents)
+	source.Send(fsnotify.Event{Name: partial, Op: fsnotify.Write})
+
+	if err := os.Rename(partial, download); err != nil {
+		t.Fatal(err)
+	}
+	source.Send(fsnotify.Event{Name: partial, Op: fsnotify.Rename})
+	source.Send(fsnotify.Event{Name: download, Op: fsnotify.Create})
+
+	detected := events.WaitFor(t, eventDownloadDetected).(DownloadDetected)
+	if detected.DownloadedFile != "NeverSink.filter" {
+		t.Fatalf("detected %+v", detected)
+	}
+
+	succeeded := events.WaitFor(t, eventReplacementSucceeded).(ReplacementSucceeded)
+	if succeeded.DownloadedFile != "NeverSink.filter" || succeeded.TargetFile != "installed.filter" {
+		t.Fatalf("replaced %+v", succeeded)
+	}
+
+	assertFileContents(t, filepath.Join(filtersDirectory, "installed.filter"), contents)
+
+	if count := watcher.PendingDownloadCount(); count != 0 {
+		t.Fatalf("%d downloads still pending", count)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// isRemoteFilesystem checks whether a directory is on a network share, either by UNC path or a mapped drive
+func isRemoteFilesystem(directory string) bool {
+	// extended-length paths can point at either a local drive or a UNC share
+	if strings.HasPrefix(directory, `\\?\`) {
+		if strings.HasPrefix(strings.ToUpper(directory), `\\?\UNC\`) {
+			return true
+		}
+
+		directory = strings.TrimPrefix(directory, `\\?\`)
+	} else if strings.HasPrefix(directory, `\\`) {
+		return true
+	}
+
+	volume := filepath.VolumeName(directory)
+	if volume == "" {
+		return false
+	}
+
+	root, err := windows.UTF16PtrFromString(volume + `\`)
+	if err != nil {
+		return false
+	}
+
+	return windows.GetDriveType(root) == windows.DRIVE_REMOTE
+}
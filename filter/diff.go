@@ -0,0 +1,380 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Diff is a block-level summary of what changed between two versions of a filter
+type Diff struct {
+	Stats DiffStats `json:"stats"`
+
+	Added    []BlockRef      `json:"added"`
+	Removed  []BlockRef      `json:"removed"`
+	Changed  []BlockChange   `json:"changed"`
+	Sections []SectionChange `json:"sections"`
+
+	// Truncated is set when some of the lists above were cut short (the stats never are)
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type DiffStats struct {
+	Added            int `json:"added"`
+	Removed          int `json:"removed"`
+	Changed          int `json:"changed"`
+	BaseTypesAdded   int `json:"base_types_added"`
+	BaseTypesRemoved int `json:"base_types_removed"`
+	Shown            int `json:"shown"`
+	Hidden           int `json:"hidden"`
+}
+
+// BlockRef identifies a block in one of the two filters being compared
+type BlockRef struct {
+	Key     string    `json:"key"`
+	Kind    BlockKind `json:"kind"`
+	Section string    `json:"section,omitempty"`
+	Line    int       `json:"line"`
+}
+
+// BlockChange is everything that changed in a block that exists in both filters
+type BlockChange struct {
+	Block BlockRef `json:"block"`
+
+	// KindBefore is only set if the block switched between Show, Hide and Minimal
+	KindBefore BlockKind `json:"kind_before,omitempty"`
+
+	BaseTypesAdded   []string          `json:"base_types_added,omitempty"`
+	BaseTypesRemoved []string          `json:"base_types_removed,omitempty"`
+	Conditions       []StatementChange `json:"conditions,omitempty"`
+	Actions          []StatementChange `json:"actions,omitempty"`
+}
+
+// StatementChange is a keyword whose value changed. Before is empty if it was added, and After if it was removed
+type StatementChange struct {
+	Keyword string `json:"keyword"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+}
+
+// SectionChange sums up the block changes in a single section, e.g. how much stricter it got
+type SectionChange struct {
+	Section string `json:"section"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Changed int    `json:"changed"`
+	Shown   int    `json:"shown"`
+	Hidden  int    `json:"hidden"`
+}
+
+// Compare builds a diff that turns before into after. Blocks are matched by their identity: the
+// FilterBlade "$type->... $tier->..." tags in their header comment when they have them, their header
+// comment otherwise, and as a last resort their section and conditions. Visibility and BaseTypes don't
+// take part in identity, so that blocks that got hidden or gained bases count as changed, not replaced
+func Compare(before, after *Filter) *Diff {
+	diff := &Diff{
+		Added:    make([]BlockRef, 0),
+		Removed:  make([]BlockRef, 0),
+		Changed:  make([]BlockChange, 0),
+		Sections: make([]SectionChange, 0),
+	}
+
+	beforeBlocks := identifyBlocks(before)
+	afterBlocks := identifyBlocks(after)
+
+	beforeByKey := make(map[string]identifiedBlock, len(beforeBlocks))
+	for _, block := range beforeBlocks {
+		beforeByKey[block.ref.Key] = block
+	}
+
+	afterKeys := make(map[string]bool, len(afterBlocks))
+	sections := make(map[string]*SectionChange)
+	sectionOrder := make([]string, 0)
+
+	section := func(name string) *SectionChange {
+		if _, ok := sections[name]; !ok {
+			sections[name] = &SectionChange{Section: name}
+			sectionOrder = append(sectionOrder, name)
+		}
+
+		return sections[name]
+	}
+
+	for _, block := range afterBlocks {
+		afterKeys[block.ref.Key] = true
+
+		previous, existed := beforeByKey[block.ref.Key]
+		if !existed {
+			diff.Added = append(diff.Added, block.ref)
+			section(block.ref.Section).Added++
+			continue
+		}
+
+		change, changed := compareBlocks(previous.block, block.block)
+		if !changed {
+			continue
+		}
+
+		change.Block = block.ref
+		diff.Changed = append(diff.Changed, change)
+		diff.Stats.BaseTypesAdded += len(change.BaseTypesAdded)
+		diff.Stats.BaseTypesRemoved += len(change.BaseTypesRemoved)
+
+		sectionChange := section(block.ref.Section)
+		sectionChange.Changed++
+
+		if change.KindBefore != "" {
+			if block.block.Kind == BlockHide {
+				diff.Stats.Hidden++
+				sectionChange.Hidden++
+			} else if change.KindBefore == BlockHide {
+				diff.Stats.Shown++
+				sectionChange.Shown++
+			}
+		}
+	}
+
+	for _, block := range beforeBlocks {
+		if !afterKeys[block.ref.Key] {
+			diff.Removed = append(diff.Removed, block.ref)
+			section(block.ref.Section).Removed++
+		}
+	}
+
+	for _, name := range sectionOrder {
+		diff.Sections = append(diff.Sections, *sections[name])
+	}
+
+	diff.Stats.Added = len(diff.Added)
+	diff.Stats.Removed = len(diff.Removed)
+	diff.Stats.Changed = len(diff.Changed)
+
+	return diff
+}
+
+// Empty tells whether the two filters are the same, as far as the game is concerned
+func (d *Diff) Empty() bool {
+	return d.Stats.Added == 0 && d.Stats.Removed == 0 && d.Stats.Changed == 0
+}
+
+// Summary describes the diff in a single line
+func (d *Diff) Summary() string {
+	if d.Empty() {
+		return "no changes"
+	}
+
+	summary := fmt.Sprintf("%d blocks added, %d removed, %d changed", d.Stats.Added, d.Stats.Removed, d.Stats.Changed)
+
+	if d.Stats.BaseTypesAdded > 0 || d.Stats.BaseTypesRemoved > 0 {
+		summary += fmt.Sprintf(" (BaseTypes +%d -%d)", d.Stats.BaseTypesAdded, d.Stats.BaseTypesRemoved)
+	}
+
+	if d.Stats.Shown > 0 || d.Stats.Hidden > 0 {
+		summary += fmt.Sprintf(", %d newly shown, %d newly hidden", d.Stats.Shown, d.Stats.Hidden)
+	}
+
+	return summary
+}
+
+// Truncate returns a copy of the diff with at most max entries in each of its lists
+func (d *Diff) Truncate(max int) *Diff {
+	truncated := *d
+
+	if len(d.Added) > max {
+		truncated.Added = d.Added[:max]
+		truncated.Truncated = true
+	}
+
+	if len(d.Removed) > max {
+		truncated.Removed = d.Removed[:max]
+		truncated.Truncated = true
+	}
+
+	if len(d.Changed) > max {
+		truncated.Changed = d.Changed[:max]
+		truncated.Truncated = true
+	}
+
+	if len(d.Sections) > max {
+		truncated.Sections = d.Sections[:max]
+		truncated.Truncated = true
+	}
+
+	return &truncated
+}
+
+type identifiedBlock struct {
+	block *Block
+	ref   BlockRef
+}
+
+// identifyBlocks gives every block in the filter a key that's unique within it, and finds its section
+func identifyBlocks(f *Filter) []identifiedBlock {
+	blocks := make([]identifiedBlock, 0)
+	seen := make(map[string]int)
+	section := ""
+
+	for _, node := range f.Nodes {
+		switch node := node.(type) {
+		case *Statement:
+			if id, title, _, ok := node.Section(); ok {
+				section = strings.TrimSpace(fmt.Sprintf("[%s] %s", id, title))
+			}
+
+		case *Block:
+			key := blockIdentity(node, section)
+
+			// identical-looking blocks are told apart by the order they appear in
+			seen[key]++
+			if seen[key] > 1 {
+				key = fmt.Sprintf("%s #%d", key, seen[key])
+			}
+
+			blocks = append(blocks, identifiedBlock{
+				block: node,
+				ref:   BlockRef{Key: key, Kind: node.Kind, Section: section, Line: node.Line},
+			})
+		}
+	}
+
+	return blocks
+}
+
+func blockIdentity(block *Block, section string) string {
+	tags := make([]string, 0)
+	for _, field := range strings.Fields(block.Comment) {
+		if strings.HasPrefix(field, "$") {
+			tags = append(tags, field)
+		}
+	}
+
+	if len(tags) > 0 {
+		return strings.Join(tags, " ")
+	}
+
+	if comment := strings.TrimSpace(block.Comment); comment != "" {
+		return comment
+	}
+
+	conditions := make([]string, 0)
+	for _, statement := range block.Conditions() {
+		if strings.EqualFold(statement.Keyword, "BaseType") {
+			continue
+		}
+
+		conditions = append(conditions, statementValue(statement, true))
+	}
+
+	return strings.TrimSpace(section + " " + strings.Join(conditions, " "))
+}
+
+func compareBlocks(before, after *Block) (BlockChange, bool) {
+	change := BlockChange{}
+
+	if before.Kind != after.Kind {
+		change.KindBefore = before.Kind
+	}
+
+	beforeBaseTypes := baseTypes(before)
+	afterBaseTypes := baseTypes(after)
+	change.BaseTypesAdded = setDifference(afterBaseTypes, beforeBaseTypes)
+	change.BaseTypesRemoved = setDifference(beforeBaseTypes, afterBaseTypes)
+
+	change.Conditions = compareStatements(before.Conditions(), after.Conditions(), "BaseType")
+	change.Actions = compareStatements(append(before.Actions(), before.ofKind(StatementContinue)...),
+		append(after.Actions(), after.ofKind(StatementContinue)...), "")
+
+	changed := change.KindBefore != "" ||
+		len(change.BaseTypesAdded) > 0 || len(change.BaseTypesRemoved) > 0 ||
+		len(change.Conditions) > 0 || len(change.Actions) > 0
+
+	return change, changed
+}
+
+func baseTypes(block *Block) map[string]bool {
+	result := make(map[string]bool)
+	for _, statement := range block.Find("BaseType") {
+		for _, value := range statement.Values {
+			result[value.Text] = true
+		}
+	}
+
+	return result
+}
+
+// setDifference returns what's in a but not in b, sorted
+func setDifference(a, b map[string]bool) []string {
+	result := make([]string, 0)
+	for value := range a {
+		if !b[value] {
+			result = append(result, value)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// compareStatements compares statements keyword by keyword, skipping the given keyword (if any).
+// A keyword used several times in the same block is compared as a whole
+func compareStatements(before, after []*Statement, skipKeyword string) []StatementChange {
+	beforeValues, order := groupStatementValues(before, skipKeyword, nil)
+	afterValues, order := groupStatementValues(after, skipKeyword, order)
+
+	changes := make([]StatementChange, 0)
+	for _, keyword := range order {
+		if beforeValues[keyword] != afterValues[keyword] {
+			changes = append(changes, StatementChange{
+				Keyword: keyword,
+				Before:  beforeValues[keyword],
+				After:   afterValues[keyword],
+			})
+		}
+	}
+
+	return changes
+}
+
+func groupStatementValues(statements []*Statement, skipKeyword string, order []string) (map[string]string, []string) {
+	values := make(map[string]string)
+
+	known := make(map[string]bool, len(order))
+	for _, keyword := range order {
+		known[keyword] = true
+	}
+
+	for _, statement := range statements {
+		if skipKeyword != "" && strings.EqualFold(statement.Keyword, skipKeyword) {
+			continue
+		}
+
+		if values[statement.Keyword] != "" {
+			values[statement.Keyword] += " | "
+		}
+		values[statement.Keyword] += statementValue(statement, false)
+
+		if !known[statement.Keyword] {
+			known[statement.Keyword] = true
+			order = append(order, statement.Keyword)
+		}
+	}
+
+	return values, order
+}
+
+// statementValue is a statement without its comment, and optionally without its keyword
+func statementValue(statement *Statement, withKeyword bool) string {
+	stripped := *statement
+	stripped.Comment = ""
+
+	value := stripped.canonical()
+	if !withKeyword {
+		value = strings.TrimSpace(strings.TrimPrefix(value, statement.Keyword))
+	}
+
+	if value == "" {
+		return statement.Keyword
+	}
+
+	return value
+}
@@ -0,0 +1,200 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, data string) *Filter {
+	t.Helper()
+
+	parsed, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed
+}
+
+func refKeys(refs []BlockRef) []string {
+	keys := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		keys = append(keys, ref.Key)
+	}
+
+	return keys
+}
+
+const diffBefore = `# [[0100]] Currency
+Show # $type->currency $tier->t1
+	Class == "Stackable Currency"
+	BaseType == "Divine Orb" "Exalted Orb"
+	SetFontSize 45
+
+Show # $type->currency $tier->t2
+	Class == "Stackable Currency"
+	BaseType == "Chaos Orb"
+	SetFontSize 40
+
+Show # $type->currency $tier->scrolls
+	Class == "Stackable Currency"
+	BaseType == "Portal Scroll"
+
+# [[0200]] Flasks
+Show
+	Class == "Life Flasks"
+	AreaLevel <= 67
+`
+
+func TestCompareIdenticalFilters(t *testing.T) {
+	diff := Compare(mustParse(t, diffBefore), mustParse(t, diffBefore))
+	if !diff.Empty() || diff.Summary() != "no changes" {
+		t.Fatalf("got %s", diff.Summary())
+	}
+}
+
+func TestCompareAddedRemovedAndChanged(t *testing.T) {
+	after := `# [[0100]] Currency
+Show # $type->currency $tier->t1
+	Class == "Stackable Currency"
+	BaseType == "Divine Orb" "Mirror Shard"
+	SetFontSize 45
+	PlayEffect Red
+
+Show # $type->currency $tier->t2
+	Class == "Stackable Currency"
+	BaseType == "Chaos Orb"
+	SetFontSize 40
+
+Hide # $type->currency $tier->scrolls
+	Class == "Stackable Currency"
+	BaseType == "Portal Scroll"
+
+Show # $type->currency $tier->t0
+	Class == "Stackable Currency"
+	BaseType == "Mirror of Kalandra"
+
+# [[0200]] Flasks
+Show
+	Class == "Life Flasks" "Mana Flasks"
+	AreaLevel <= 67
+`
+
+	diff := Compare(mustParse(t, diffBefore), mustParse(t, after))
+
+	if got, want := refKeys(diff.Added), []string{"$type->currency $tier->t0", `[0200] Flasks Class == "Life Flasks" "Mana Flasks" AreaLevel <= 67`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got added %q, want %q", got, want)
+	}
+
+	// the flask block's identity comes from its conditions, so changing them makes it a different block
+	if got, want := refKeys(diff.Removed), []string{`[0200] Flasks Class == "Life Flasks" AreaLevel <= 67`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got removed %q, want %q", got, want)
+	}
+
+	if len(diff.Changed) != 2 {
+		t.Fatalf("got changes %+v, want two", diff.Changed)
+	}
+
+	t1 := diff.Changed[0]
+	if t1.Block.Key != "$type->currency $tier->t1" || t1.Block.Section != "[0100] Currency" || t1.KindBefore != "" ||
+		!reflect.DeepEqual(t1.BaseTypesAdded, []string{"Mirror Shard"}) ||
+		!reflect.DeepEqual(t1.BaseTypesRemoved, []string{"Exalted Orb"}) ||
+		len(t1.Conditions) != 0 ||
+		!reflect.DeepEqual(t1.Actions, []StatementChange{{Keyword: "PlayEffect", After: "Red"}}) {
+		t.Errorf("got t1 change %+v", t1)
+	}
+
+	scrolls := diff.Changed[1]
+	if scrolls.Block.Key != "$type->currency $tier->scrolls" || scrolls.KindBefore != BlockShow || scrolls.Block.Kind != BlockHide {
+		t.Errorf("got scrolls change %+v", scrolls)
+	}
+
+	wantStats := DiffStats{Added: 2, Removed: 1, Changed: 2, BaseTypesAdded: 1, BaseTypesRemoved: 1, Hidden: 1}
+	if diff.Stats != wantStats {
+		t.Errorf("got stats %+v, want %+v", diff.Stats, wantStats)
+	}
+
+	wantSections := []SectionChange{
+		{Section: "[0100] Currency", Added: 1, Changed: 2, Hidden: 1},
+		{Section: "[0200] Flasks", Added: 1, Removed: 1},
+	}
+	if !reflect.DeepEqual(diff.Sections, wantSections) {
+		t.Errorf("got sections %+v, want %+v", diff.Sections, wantSections)
+	}
+
+	if summary := diff.Summary(); summary != "2 blocks added, 1 removed, 2 changed (BaseTypes +1 -1), 0 newly shown, 1 newly hidden" {
+		t.Errorf("got summary %q", summary)
+	}
+}
+
+func TestCompareChangedStatements(t *testing.T) {
+	before := mustParse(t, "Show # $tier->a\n\tItemLevel >= 75\n\tRarity Rare\n\tSetFontSize 40\n\tPlayAlertSound 1 300\n\tContinue\n")
+	after := mustParse(t, "Show # $tier->a\n\tItemLevel >= 80\n\tRarity Rare # same rarity\n\tSetFontSize 40\n\tMinimapIcon 0 Red Star\n")
+
+	diff := Compare(before, after)
+	if len(diff.Changed) != 1 {
+		t.Fatalf("got changes %+v, want one", diff.Changed)
+	}
+
+	change := diff.Changed[0]
+	if want := []StatementChange{{Keyword: "ItemLevel", Before: ">= 75", After: ">= 80"}}; !reflect.DeepEqual(change.Conditions, want) {
+		t.Errorf("got conditions %+v, want %+v", change.Conditions, want)
+	}
+
+	wantActions := []StatementChange{
+		{Keyword: "PlayAlertSound", Before: "1 300"},
+		{Keyword: "Continue", Before: "Continue"},
+		{Keyword: "MinimapIcon", After: "0 Red Star"},
+	}
+	if !reflect.DeepEqual(change.Actions, wantActions) {
+		t.Errorf("got actions %+v, want %+v", change.Actions, wantActions)
+	}
+}
+
+func TestCompareReorderedBlocks(t *testing.T) {
+	blocks := strings.Split(strings.TrimSuffix(diffBefore, "\n"), "\n\n")
+
+	// blocks are matched by identity, not position, so moving them around isn't a change
+	reordered := strings.Join([]string{blocks[2], blocks[0], blocks[1], blocks[3]}, "\n\n") + "\n"
+	if diff := Compare(mustParse(t, diffBefore), mustParse(t, reordered)); !diff.Empty() {
+		t.Fatalf("got %s: %+v", diff.Summary(), diff)
+	}
+
+	// identical blocks can only be told apart by their order, so swapping them is no change either
+	identical := "Show\n\tRarity Unique\n\tSetFontSize 45\n\nShow\n\tRarity Unique\n\tSetFontSize 45\n"
+	if diff := Compare(mustParse(t, identical), mustParse(t, identical+"\nHide\n")); diff.Stats.Added != 1 || diff.Stats.Changed != 0 {
+		t.Fatalf("got %s", diff.Summary())
+	}
+
+	// but blocks that look the same and only differ in their actions are matched in the order they appear in
+	before := "Show\n\tRarity Unique\n\tSetFontSize 45\n\nShow\n\tRarity Unique\n\tSetFontSize 30\n"
+	after := "Show\n\tRarity Unique\n\tSetFontSize 30\n\nShow\n\tRarity Unique\n\tSetFontSize 45\n"
+
+	diff := Compare(mustParse(t, before), mustParse(t, after))
+	if got, want := refKeys(blockChangeRefs(diff.Changed)), []string{"Rarity Unique", "Rarity Unique #2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got changed %q, want %q", got, want)
+	}
+}
+
+func blockChangeRefs(changes []BlockChange) []BlockRef {
+	refs := make([]BlockRef, 0, len(changes))
+	for _, change := range changes {
+		refs = append(refs, change.Block)
+	}
+
+	return refs
+}
+
+func TestDiffTruncate(t *testing.T) {
+	diff := Compare(mustParse(t, ""), mustParse(t, diffBefore))
+
+	truncated := diff.Truncate(2)
+	if len(truncated.Added) != 2 || !truncated.Truncated || truncated.Stats.Added != 4 {
+		t.Fatalf("got %+v", truncated)
+	}
+
+	if len(diff.Added) != 4 || diff.Truncated {
+		t.Fatal("truncating changed the original diff")
+	}
+}
@@ -0,0 +1,232 @@
+// Package filter parses and prints Path of Exile item filters.
+//
+// Parsing is lossless: printing a parsed filter that wasn't modified reproduces the exact same bytes,
+// down to whitespace, comments and line endings. Lines that were modified are reprinted in a canonical
+// form, keeping their original indentation.
+package filter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Node is a top-level item in a filter: either a *Block, or a *Statement that's an Import, a comment or a blank line
+type Node interface {
+	node()
+}
+
+// Filter is a parsed item filter
+type Filter struct {
+	// BOM is set if the file started with a UTF-8 byte order mark
+	BOM bool
+
+	Nodes []Node
+
+	// the line ending new lines are printed with: the first one found when parsing
+	newline string
+}
+
+// Block is a Show, Hide or Minimal block, along with everything inside it
+type Block struct {
+	Kind       BlockKind
+	Comment    string
+	Statements []*Statement
+
+	// Line is the 1-based line number of the block's header (0 for blocks that weren't parsed)
+	Line int
+
+	header line
+}
+
+func (*Block) node() {}
+
+type StatementKind int
+
+const (
+	StatementCondition StatementKind = iota
+	StatementAction
+	StatementContinue
+	StatementImport
+	StatementComment
+	StatementBlank
+	StatementUnknown
+)
+
+// Statement is a single line in a filter: a condition, an action, Continue, an Import, a comment, or a blank line.
+// Statements with a keyword we don't recognize are kept as StatementUnknown
+type Statement struct {
+	Kind     StatementKind
+	Keyword  string
+	Operator string
+	Values   []Value
+
+	// Comment is everything after the '#' of a trailing comment (or of a comment line)
+	Comment string
+
+	// Line is the 1-based line number of the statement (0 for statements that weren't parsed)
+	Line int
+
+	line line
+}
+
+func (*Statement) node() {}
+
+// Value is a single value of a statement, e.g. a number, a word or a quoted string
+type Value struct {
+	Text   string
+	Quoted bool
+}
+
+// line keeps what a node looked like when it was parsed, so that unmodified nodes print exactly the same
+type line struct {
+	parsed    bool
+	raw       string
+	eol       string
+	indent    string
+	canonical string
+}
+
+// NewBlock creates a block of the given kind, with the given statements
+func NewBlock(kind BlockKind, statements ...*Statement) *Block {
+	return &Block{Kind: kind, Statements: statements}
+}
+
+// NewStatement creates a condition or action statement, categorized by its keyword.
+// Values are quoted if they contain whitespace
+func NewStatement(keyword string, operator string, values ...string) *Statement {
+	statement := &Statement{
+		Kind:     StatementUnknown,
+		Keyword:  keyword,
+		Operator: operator,
+	}
+
+	if canonical, ok := conditionKeywords[strings.ToLower(keyword)]; ok {
+		statement.Kind = StatementCondition
+		statement.Keyword = canonical
+	} else if canonical, ok := actionKeywords[strings.ToLower(keyword)]; ok {
+		statement.Kind = StatementAction
+		statement.Keyword = canonical
+	} else if strings.EqualFold(keyword, continueKeyword) {
+		statement.Kind = StatementContinue
+		statement.Keyword = continueKeyword
+	}
+
+	for _, value := range values {
+		statement.Values = append(statement.Values, Value{
+			Text:   value,
+			Quoted: strings.ContainsAny(value, " \t") || value == "",
+		})
+	}
+
+	return statement
+}
+
+// NewComment creates a comment line (text is whatever comes after the '#')
+func NewComment(text string) *Statement {
+	return &Statement{Kind: StatementComment, Comment: text}
+}
+
+// Blocks returns every block in the filter, in order
+func (f *Filter) Blocks() []*Block {
+	blocks := make([]*Block, 0)
+	for _, node := range f.Nodes {
+		if block, ok := node.(*Block); ok {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks
+}
+
+// Find returns every statement in the block with the given keyword (case-insensitively)
+func (b *Block) Find(keyword string) []*Statement {
+	statements := make([]*Statement, 0)
+	for _, statement := range b.Statements {
+		if statement.Keyword != "" && strings.EqualFold(statement.Keyword, keyword) {
+			statements = append(statements, statement)
+		}
+	}
+
+	return statements
+}
+
+// First returns the first statement in the block with the given keyword, or nil
+func (b *Block) First(keyword string) *Statement {
+	for _, statement := range b.Statements {
+		if statement.Keyword != "" && strings.EqualFold(statement.Keyword, keyword) {
+			return statement
+		}
+	}
+
+	return nil
+}
+
+// Conditions returns the block's known conditions
+func (b *Block) Conditions() []*Statement {
+	return b.ofKind(StatementCondition)
+}
+
+// Actions returns the block's actions
+func (b *Block) Actions() []*Statement {
+	return b.ofKind(StatementAction)
+}
+
+// HasContinue tells whether matching items keep going to the next blocks
+func (b *Block) HasContinue() bool {
+	return len(b.ofKind(StatementContinue)) > 0
+}
+
+func (b *Block) ofKind(kind StatementKind) []*Statement {
+	statements := make([]*Statement, 0)
+	for _, statement := range b.Statements {
+		if statement.Kind == kind {
+			statements = append(statements, statement)
+		}
+	}
+
+	return statements
+}
+
+// Strings returns the text of all of the statement's values
+func (s *Statement) Strings() []string {
+	texts := make([]string, len(s.Values))
+	for idx, value := range s.Values {
+		texts[idx] = value.Text
+	}
+
+	return texts
+}
+
+// IsDirective tells whether a statement is something the game acts on (as opposed to comments and blank lines)
+func (s *Statement) IsDirective() bool {
+	return s.Kind != StatementComment && s.Kind != StatementBlank
+}
+
+// Import returns the file an Import statement pulls in, and whether the game skips it when it's missing.
+// ok is false if the statement isn't an Import
+func (s *Statement) Import() (path string, optional bool, ok bool) {
+	if s.Kind != StatementImport || len(s.Values) == 0 {
+		return "", false, false
+	}
+
+	return s.Values[0].Text, len(s.Values) > 1 && strings.EqualFold(s.Values[1].Text, importOptionalFlag), true
+}
+
+// sectionPattern matches NeverSink-style section markers, e.g. "# [[0100]] Global overriding rules"
+// or "# [0101] Essences"
+var sectionPattern = regexp.MustCompile(`^\s*\[(\[?)(\d+)\]?\]\s*(.*?)\s*$`)
+
+// Section returns the ID and title of a section marker comment, and whether the statement is one at all.
+// Top-level sections use double brackets, and their subsections single ones
+func (s *Statement) Section() (id string, title string, topLevel bool, ok bool) {
+	if s.Kind != StatementComment {
+		return "", "", false, false
+	}
+
+	match := sectionPattern.FindStringSubmatch(s.Comment)
+	if match == nil {
+		return "", "", false, false
+	}
+
+	return match[2], match[3], match[1] == "[", true
+}
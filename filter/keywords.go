@@ -0,0 +1,78 @@
+package filter
+
+import "strings"
+
+// BlockKind is the keyword a block starts with
+type BlockKind string
+
+const (
+	BlockShow    BlockKind = "Show"
+	BlockHide    BlockKind = "Hide"
+	BlockMinimal BlockKind = "Minimal"
+)
+
+var blockKinds = map[string]BlockKind{
+	"show":    BlockShow,
+	"hide":    BlockHide,
+	"minimal": BlockMinimal,
+}
+
+const continueKeyword = "Continue"
+
+const (
+	importKeyword = "Import"
+
+	// importOptionalFlag after an Import's file name makes the game skip the file if it doesn't exist
+	importOptionalFlag = "Optional"
+)
+
+// conditionKeywords maps the lowercased form of every known condition to its canonical spelling
+var conditionKeywords = canonicalize(
+	"AlternateQuality", "AnyEnchantment", "AreaLevel", "ArchnemesisMod", "BaseArmour",
+	"BaseDefencePercentile", "BaseEnergyShield", "BaseEvasion", "BaseType", "BaseWard",
+	"BlightedMap", "Class", "Corrupted", "CorruptedMods", "DropLevel",
+	"ElderItem", "ElderMap", "EnchantmentPassiveNode", "EnchantmentPassiveNum", "FracturedItem",
+	"GemLevel", "GemQualityType", "HasCruciblePassiveTree", "HasEaterOfWorldsImplicit", "HasEnchantment",
+	"HasExplicitMod", "HasImplicitMod", "HasInfluence", "HasSearingExarchImplicit", "Height",
+	"Identified", "ItemLevel", "LinkedSockets", "MapTier", "MemoryStrands",
+	"Mirrored", "Quality", "Rarity", "Replica", "Scourged",
+	"ShapedMap", "ShaperItem", "SocketGroup", "Sockets", "StackSize",
+	"SynthesisedItem", "TransfiguredGem", "UberBlightedMap", "UnidentifiedItemTier", "Width",
+	"ZanaMemory",
+)
+
+// actionKeywords maps the lowercased form of every known action to its canonical spelling
+var actionKeywords = canonicalize(
+	"CustomAlertSound", "CustomAlertSoundOptional", "DisableDropSound", "DisableDropSoundIfAlertSound", "EnableDropSound",
+	"EnableDropSoundIfAlertSound", "MinimapIcon", "PlayAlertSound", "PlayAlertSoundPositional", "PlayEffect",
+	"SetBackgroundColor", "SetBorderColor", "SetFontSize", "SetTextColor",
+)
+
+// flagKeywords take no values at all
+var flagKeywords = canonicalize(
+	"DisableDropSound", "DisableDropSoundIfAlertSound", "EnableDropSound", "EnableDropSoundIfAlertSound",
+)
+
+func canonicalize(keywords ...string) map[string]string {
+	result := make(map[string]string, len(keywords))
+	for _, keyword := range keywords {
+		result[strings.ToLower(keyword)] = keyword
+	}
+
+	return result
+}
+
+// IsCondition tells whether a keyword is a known condition (case-insensitively)
+func IsCondition(keyword string) bool {
+	_, ok := conditionKeywords[strings.ToLower(keyword)]
+	return ok
+}
+
+// IsAction tells whether a keyword is a known action (case-insensitively)
+func IsAction(keyword string) bool {
+	_, ok := actionKeywords[strings.ToLower(keyword)]
+	return ok
+}
+
+// operators, longest first so that prefixes don't shadow them
+var operators = []string{"==", "!=", "<=", ">=", "<", ">", "=", "!"}
@@ -0,0 +1,618 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LintCheck is the kind of problem a lint issue is about
+type LintCheck string
+
+const (
+	LintUnreachable       LintCheck = "unreachable"
+	LintDuplicateBaseType LintCheck = "duplicate_base_type"
+	LintColor             LintCheck = "color"
+	LintFontSize          LintCheck = "font_size"
+	LintSoundID           LintCheck = "sound_id"
+	LintMinimal           LintCheck = "minimal"
+)
+
+// LintIssue is something in a filter that's most likely a mistake, even though the game accepts it
+// (or at least loads the rest of the filter)
+type LintIssue struct {
+	Check   LintCheck `json:"check"`
+	Line    int       `json:"line"`
+	Message string    `json:"message"`
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+const (
+	minFontSize  = 1
+	maxFontSize  = 45
+	maxColorPart = 255
+	maxVolume    = 300
+	maxSoundID   = 16
+)
+
+// namedSoundIDs are the PlayAlertSound IDs that aren't numbers
+var namedSoundIDs = canonicalize(
+	"ShAlchemy", "ShBlessed", "ShChaos", "ShDivine", "ShExalted",
+	"ShFusing", "ShGeneral", "ShMirror", "ShRegal", "ShVaal",
+)
+
+// effectColors are the colors PlayEffect and MinimapIcon can use
+var effectColors = canonicalize(
+	"Red", "Green", "Blue", "Brown", "White", "Yellow", "Cyan", "Grey", "Orange", "Pink", "Purple",
+)
+
+var minimapIconShapes = canonicalize(
+	"Circle", "Diamond", "Hexagon", "Square", "Star", "Triangle", "Cross", "Moon", "Raindrop",
+	"Kite", "Pentagon", "UpsideDownHouse",
+)
+
+// minimalUnsupportedKeywords are actions that draw attention to a drop, which Minimal blocks (meant to
+// show items as unobtrusively as possible) don't support
+var minimalUnsupportedKeywords = canonicalize(
+	"PlayAlertSound", "PlayAlertSoundPositional", "CustomAlertSound", "CustomAlertSoundOptional",
+	"MinimapIcon", "PlayEffect",
+)
+
+// Lint looks for likely mistakes in a filter. Issues are sorted by line
+func Lint(f *Filter) []LintIssue {
+	issues := make([]LintIssue, 0)
+
+	blocks := f.Blocks()
+	for _, block := range blocks {
+		issues = append(issues, lintBlock(block)...)
+	}
+
+	issues = append(issues, findUnreachableBlocks(blocks)...)
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Line < issues[j].Line
+	})
+
+	return issues
+}
+
+func lintBlock(block *Block) []LintIssue {
+	issues := make([]LintIssue, 0)
+
+	for _, statement := range block.Statements {
+		switch strings.ToLower(statement.Keyword) {
+		case "basetype":
+			issues = append(issues, lintDuplicateValues(statement)...)
+		case "settextcolor", "setbordercolor", "setbackgroundcolor":
+			issues = append(issues, lintColor(statement)...)
+		case "setfontsize":
+			issues = append(issues, lintFontSize(statement)...)
+		case "playalertsound", "playalertsoundpositional":
+			issues = append(issues, lintAlertSound(statement)...)
+		case "minimapicon":
+			issues = append(issues, lintMinimapIcon(statement)...)
+		case "playeffect":
+			issues = append(issues, lintEffect(statement)...)
+		}
+
+		if _, ok := minimalUnsupportedKeywords[strings.ToLower(statement.Keyword)]; ok && block.Kind == BlockMinimal {
+			issues = append(issues, LintIssue{
+				Check:   LintMinimal,
+				Line:    statement.Line,
+				Message: fmt.Sprintf("Minimal blocks don't support %s, the game ignores it", statement.Keyword),
+			})
+		}
+	}
+
+	return issues
+}
+
+func lintDuplicateValues(statement *Statement) []LintIssue {
+	issues := make([]LintIssue, 0)
+	seen := make(map[string]bool)
+
+	for _, value := range statement.Values {
+		key := strings.ToLower(value.Text)
+		if seen[key] {
+			issues = append(issues, LintIssue{
+				Check:   LintDuplicateBaseType,
+				Line:    statement.Line,
+				Message: fmt.Sprintf("%q is listed more than once", value.Text),
+			})
+		}
+
+		seen[key] = true
+	}
+
+	return issues
+}
+
+func lintColor(statement *Statement) []LintIssue {
+	if len(statement.Values) != 3 && len(statement.Values) != 4 {
+		return []LintIssue{{
+			Check:   LintColor,
+			Line:    statement.Line,
+			Message: fmt.Sprintf("%s needs 3 or 4 numbers (red, green, blue and optionally alpha), not %d", statement.Keyword, len(statement.Values)),
+		}}
+	}
+
+	for _, value := range statement.Values {
+		if number, err := strconv.Atoi(value.Text); err != nil || number < 0 || number > maxColorPart {
+			return []LintIssue{{
+				Check:   LintColor,
+				Line:    statement.Line,
+				Message: fmt.Sprintf("%s value %q isn't a number between 0 and %d", statement.Keyword, value.Text, maxColorPart),
+			}}
+		}
+	}
+
+	return nil
+}
+
+func lintFontSize(statement *Statement) []LintIssue {
+	if len(statement.Values) == 1 {
+		if size, err := strconv.Atoi(statement.Values[0].Text); err == nil && size >= minFontSize && size <= maxFontSize {
+			return nil
+		}
+	}
+
+	return []LintIssue{{
+		Check:   LintFontSize,
+		Line:    statement.Line,
+		Message: fmt.Sprintf("font size %s isn't a number between %d and %d", strings.Join(statement.Strings(), " "), minFontSize, maxFontSize),
+	}}
+}
+
+func lintAlertSound(statement *Statement) []LintIssue {
+	if len(statement.Values) == 0 {
+		return []LintIssue{{Check: LintSoundID, Line: statement.Line, Message: fmt.Sprintf("%s has no sound ID", statement.Keyword)}}
+	}
+
+	id := statement.Values[0].Text
+	_, named := namedSoundIDs[strings.ToLower(id)]
+	number, err := strconv.Atoi(id)
+
+	if !named && !strings.EqualFold(id, "None") && (err != nil || number < 1 || number > maxSoundID) {
+		return []LintIssue{{
+			Check:   LintSoundID,
+			Line:    statement.Line,
+			Message: fmt.Sprintf("unknown sound ID %q (it's either 1 to %d, or one of the Sh... sounds)", id, maxSoundID),
+		}}
+	}
+
+	if len(statement.Values) > 1 {
+		if volume, err := strconv.Atoi(statement.Values[1].Text); err != nil || volume < 0 || volume > maxVolume {
+			return []LintIssue{{
+				Check:   LintSoundID,
+				Line:    statement.Line,
+				Message: fmt.Sprintf("volume %q isn't a number between 0 and %d", statement.Values[1].Text, maxVolume),
+			}}
+		}
+	}
+
+	return nil
+}
+
+func lintMinimapIcon(statement *Statement) []LintIssue {
+	values := statement.Strings()
+	if len(values) == 1 && values[0] == "-1" {
+		return nil
+	}
+
+	invalid := func(message string) []LintIssue {
+		return []LintIssue{{Check: LintColor, Line: statement.Line, Message: "MinimapIcon " + message}}
+	}
+
+	if len(values) != 3 {
+		return invalid("needs a size, a color and a shape (or -1 to turn it off)")
+	}
+
+	if size, err := strconv.Atoi(values[0]); err != nil || size < 0 || size > 2 {
+		return invalid(fmt.Sprintf("size %q isn't 0, 1 or 2", values[0]))
+	}
+
+	if _, ok := effectColors[strings.ToLower(values[1])]; !ok {
+		return invalid(fmt.Sprintf("has unknown color %q", values[1]))
+	}
+
+	if _, ok := minimapIconShapes[strings.ToLower(values[2])]; !ok {
+		return invalid(fmt.Sprintf("has unknown shape %q", values[2]))
+	}
+
+	return nil
+}
+
+func lintEffect(statement *Statement) []LintIssue {
+	values := statement.Strings()
+	if len(values) == 1 && strings.EqualFold(values[0], "None") {
+		return nil
+	}
+
+	if len(values) == 0 || len(values) > 2 {
+		return []LintIssue{{Check: LintColor, Line: statement.Line, Message: "PlayEffect needs a color, and optionally Temp"}}
+	}
+
+	if _, ok := effectColors[strings.ToLower(values[0])]; !ok {
+		return []LintIssue{{Check: LintColor, Line: statement.Line, Message: fmt.Sprintf("PlayEffect has unknown color %q", values[0])}}
+	}
+
+	if len(values) == 2 && !strings.EqualFold(values[1], "Temp") {
+		return []LintIssue{{Check: LintColor, Line: statement.Line, Message: fmt.Sprintf("PlayEffect takes Temp after its color, not %q", values[1])}}
+	}
+
+	return nil
+}
+
+// findUnreachableBlocks reports blocks that no item can reach, because an earlier block without Continue
+// matches every item they would. Only conditions that can be compared are taken into account (so this
+// misses some unreachable blocks, but shouldn't report reachable ones). Blocks with keywords we don't know
+// never hide later ones, since those keywords could be conditions that make them match fewer items
+func findUnreachableBlocks(blocks []*Block) []LintIssue {
+	issues := make([]LintIssue, 0)
+
+	// only blocks without Continue can hide later ones
+	catching := make([]int, 0, len(blocks))
+	summaries := make([]conditionSummary, len(blocks))
+	keywordBits := make(map[string]uint64)
+
+	for idx, block := range blocks {
+		summaries[idx] = summarizeConditions(block, keywordBits)
+
+		for _, earlierIdx := range catching {
+			if !summaries[earlierIdx].catchesEverything(summaries[idx]) {
+				continue
+			}
+
+			issues = append(issues, LintIssue{
+				Check:   LintUnreachable,
+				Line:    block.Line,
+				Message: fmt.Sprintf("block never matches, the block on line %d (which has no Continue) catches every item it would", blocks[earlierIdx].Line),
+			})
+			break
+		}
+
+		if !block.HasContinue() && !summaries[idx].unknownKeywords {
+			catching = append(catching, idx)
+		}
+	}
+
+	return issues
+}
+
+// conditionSummary is a block's conditions, prepared for comparing with other blocks' over and over
+type conditionSummary struct {
+	conditions []lintCondition
+
+	// keywords has a bit set for every condition keyword in the block, so blocks that can't possibly
+	// catch each other's items are told apart without looking at their values
+	keywords uint64
+
+	// unknownKeywords is set if the block has statements we don't recognize, which Conditions() leaves out
+	unknownKeywords bool
+}
+
+type lintCondition struct {
+	keyword  string
+	operator string
+	values   []string
+	valueSet map[string]bool
+
+	// interval is set for numeric conditions, and rarities (as a bitmask) for Rarity conditions.
+	// Their ok flags are false when the condition has values that can't be compared
+	interval   numericInterval
+	intervalOK bool
+	rarities   int
+	raritiesOK bool
+}
+
+// summarizeConditions prepares a block's conditions for comparison. keywordBits assigns each keyword a bit,
+// and is shared by every block being compared (keywords past the 64th share the last bit)
+func summarizeConditions(block *Block, keywordBits map[string]uint64) conditionSummary {
+	summary := conditionSummary{unknownKeywords: len(block.ofKind(StatementUnknown)) > 0}
+
+	for _, statement := range block.Conditions() {
+		condition := lintCondition{
+			keyword:  strings.ToLower(statement.Keyword),
+			operator: normalizedOperator(statement),
+			values:   make([]string, len(statement.Values)),
+			valueSet: make(map[string]bool, len(statement.Values)),
+		}
+
+		if _, ok := numericKeywords[condition.keyword]; ok {
+			condition.interval, condition.intervalOK = numericRange(statement)
+		} else if condition.keyword == "rarity" {
+			condition.rarities, condition.raritiesOK = raritySet(statement)
+		}
+
+		for idx, value := range statement.Values {
+			condition.values[idx] = strings.ToLower(value.Text)
+			condition.valueSet[condition.values[idx]] = true
+		}
+
+		bit, ok := keywordBits[condition.keyword]
+		if !ok {
+			bit = 1 << minInt(len(keywordBits), 63)
+			keywordBits[condition.keyword] = bit
+		}
+
+		summary.conditions = append(summary.conditions, condition)
+		summary.keywords |= bit
+	}
+
+	return summary
+}
+
+// catchesEverything tells whether every item matching later also matches this block, i.e. every one of
+// this block's conditions is implied by the conditions of later
+func (s conditionSummary) catchesEverything(later conditionSummary) bool {
+	// a condition can only be implied by conditions with the same keyword
+	if s.keywords&^later.keywords != 0 {
+		return false
+	}
+
+	for idx := range s.conditions {
+		if !s.conditions[idx].impliedBy(later.conditions) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// numericKeywords are conditions that compare a single number
+var numericKeywords = canonicalize(
+	"AreaLevel", "BaseArmour", "BaseDefencePercentile", "BaseEnergyShield", "BaseEvasion", "BaseWard",
+	"CorruptedMods", "DropLevel", "EnchantmentPassiveNum", "GemLevel", "Height", "ItemLevel", "LinkedSockets",
+	"MapTier", "MemoryStrands", "Quality", "StackSize", "UnidentifiedItemTier", "Width",
+)
+
+// listKeywords are conditions that match if any of their values match the item's
+var listKeywords = canonicalize("BaseType", "Class")
+
+var rarities = []string{"normal", "magic", "rare", "unique"}
+
+func (c *lintCondition) impliedBy(conditions []lintCondition) bool {
+	for idx := range conditions {
+		if c.sameAs(&conditions[idx]) {
+			return true
+		}
+	}
+
+	switch {
+	case c.intervalOK:
+		narrowed, ok := narrowestNumericRange(c.keyword, conditions)
+		return ok && narrowed.within(c.interval)
+
+	case c.raritiesOK:
+		narrowed, ok := narrowestRaritySet(conditions)
+		return ok && narrowed&^c.rarities == 0
+
+	case listKeywords[c.keyword] != "":
+		for idx := range conditions {
+			if conditions[idx].keyword == c.keyword && c.covers(&conditions[idx]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *lintCondition) sameAs(other *lintCondition) bool {
+	if c.keyword != other.keyword || c.operator != other.operator || len(c.values) != len(other.values) {
+		return false
+	}
+
+	for idx := range c.values {
+		if c.values[idx] != other.values[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func normalizedOperator(statement *Statement) string {
+	if statement.Operator == "" {
+		return "="
+	}
+
+	return statement.Operator
+}
+
+// covers tells whether every value the later list condition accepts is also accepted by this one.
+// "==" matches values exactly, while no operator (or "=") matches any value containing one of them
+func (c *lintCondition) covers(later *lintCondition) bool {
+	if (c.operator != "=" && c.operator != "==") || (later.operator != "=" && later.operator != "==") {
+		return false
+	}
+
+	if len(later.values) == 0 || (c.operator == "==" && later.operator != "==") {
+		return false
+	}
+
+	for _, laterValue := range later.values {
+		if c.operator == "==" {
+			if !c.valueSet[laterValue] {
+				return false
+			}
+			continue
+		}
+
+		covered := false
+		for _, value := range c.values {
+			if strings.Contains(laterValue, value) {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			return false
+		}
+	}
+
+	return true
+}
+
+type numericInterval struct {
+	min, max int
+}
+
+func (i numericInterval) within(other numericInterval) bool {
+	return i.min >= other.min && i.max <= other.max
+}
+
+func numericRange(statement *Statement) (numericInterval, bool) {
+	if len(statement.Values) != 1 {
+		return numericInterval{}, false
+	}
+
+	value, err := strconv.Atoi(statement.Values[0].Text)
+	if err != nil {
+		return numericInterval{}, false
+	}
+
+	switch normalizedOperator(statement) {
+	case "=", "==":
+		return numericInterval{value, value}, true
+	case ">=":
+		return numericInterval{value, math.MaxInt32}, true
+	case ">":
+		return numericInterval{value + 1, math.MaxInt32}, true
+	case "<=":
+		return numericInterval{math.MinInt32, value}, true
+	case "<":
+		return numericInterval{math.MinInt32, value - 1}, true
+	}
+
+	return numericInterval{}, false
+}
+
+// narrowestNumericRange intersects every condition with the given keyword. Returns false if there are none,
+// or if any of them can't be turned into a range
+func narrowestNumericRange(keyword string, conditions []lintCondition) (numericInterval, bool) {
+	result := numericInterval{math.MinInt32, math.MaxInt32}
+	found := false
+
+	for idx := range conditions {
+		condition := &conditions[idx]
+		if condition.keyword != keyword {
+			continue
+		}
+
+		if !condition.intervalOK {
+			return numericInterval{}, false
+		}
+
+		result.min = maxInt(result.min, condition.interval.min)
+		result.max = minInt(result.max, condition.interval.max)
+		found = true
+	}
+
+	return result, found
+}
+
+// raritySet returns the rarities a Rarity condition accepts, as a bitmask in the order of rarities
+func raritySet(statement *Statement) (int, bool) {
+	indices := make([]int, 0, len(statement.Values))
+	for _, value := range statement.Values {
+		idx := -1
+		for rarityIdx, rarity := range rarities {
+			if strings.EqualFold(value.Text, rarity) {
+				idx = rarityIdx
+			}
+		}
+
+		if idx < 0 {
+			return 0, false
+		}
+
+		indices = append(indices, idx)
+	}
+
+	if len(indices) == 0 {
+		return 0, false
+	}
+
+	set := 0
+	operator := normalizedOperator(statement)
+
+	if operator == "=" || operator == "==" {
+		for _, idx := range indices {
+			set |= 1 << idx
+		}
+
+		return set, true
+	}
+
+	if len(indices) != 1 {
+		return 0, false
+	}
+
+	for idx := range rarities {
+		var accepted bool
+		switch operator {
+		case ">=":
+			accepted = idx >= indices[0]
+		case ">":
+			accepted = idx > indices[0]
+		case "<=":
+			accepted = idx <= indices[0]
+		case "<":
+			accepted = idx < indices[0]
+		case "!=", "!":
+			accepted = idx != indices[0]
+		default:
+			return 0, false
+		}
+
+		if accepted {
+			set |= 1 << idx
+		}
+	}
+
+	return set, true
+}
+
+func narrowestRaritySet(conditions []lintCondition) (int, bool) {
+	result := 1<<len(rarities) - 1
+	found := false
+
+	for idx := range conditions {
+		condition := &conditions[idx]
+		if condition.keyword != "rarity" {
+			continue
+		}
+
+		if !condition.raritiesOK {
+			return 0, false
+		}
+
+		result &= condition.rarities
+		found = true
+	}
+
+	return result, found
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,121 @@
+package filter
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+
+		// "<check> <line>" for every issue, in order
+		want []string
+	}{
+		{
+			name:  "clean",
+			lines: []string{"Show", "\tRarity Unique", "\tSetFontSize 45", "\tSetTextColor 255 0 0", "\tPlayAlertSound 16 300", "\tMinimapIcon 0 Red Star"},
+		},
+		{
+			name:  "duplicate base types",
+			lines: []string{"Show", `	BaseType == "Divine Orb" "Exalted Orb" "divine orb"`, `	BaseType "Chaos Orb" "Chaos Orb"`},
+			want:  []string{"duplicate_base_type 2", "duplicate_base_type 3"},
+		},
+		{
+			name: "colors",
+			lines: []string{
+				"Show",
+				"\tSetTextColor 0 0 0",
+				"\tSetBorderColor 255 255 255 255",
+				"\tSetBackgroundColor 256 0 0",
+				"\tSetTextColor 0 -1 0",
+				"\tSetBorderColor 255 0",
+				"\tSetBackgroundColor 0 0 0 0 0",
+				"\tSetTextColor red green blue",
+			},
+			want: []string{"color 4", "color 5", "color 6", "color 7", "color 8"},
+		},
+		{
+			name:  "font sizes",
+			lines: []string{"Show", "\tSetFontSize 1", "\tSetFontSize 45", "\tSetFontSize 0", "\tSetFontSize 46", "\tSetFontSize big", "\tSetFontSize 30 40"},
+			want:  []string{"font_size 4", "font_size 5", "font_size 6", "font_size 7"},
+		},
+		{
+			name: "sound IDs",
+			lines: []string{
+				"Show",
+				"\tPlayAlertSound 1",
+				"\tPlayAlertSound 16 300",
+				"\tPlayAlertSoundPositional ShDivine 100",
+				"\tPlayAlertSound None",
+				"\tPlayAlertSound 0",
+				"\tPlayAlertSound 17",
+				"\tPlayAlertSoundPositional ShNothing",
+				"\tPlayAlertSound 1 301",
+			},
+			want: []string{"sound_id 6", "sound_id 7", "sound_id 8", "sound_id 9"},
+		},
+		{
+			name:  "minimal",
+			lines: []string{"Minimal", "\tRarity Normal", "\tSetFontSize 30", "\tPlayEffect Red", "\tMinimapIcon 2 White Circle", "\tPlayAlertSound 1"},
+			want:  []string{"minimal 4", "minimal 5", "minimal 6"},
+		},
+		{
+			name:  "sounds and effects are fine outside of minimal blocks",
+			lines: []string{"Show", "\tRarity Normal", "\tPlayEffect Red", "\tMinimapIcon 2 White Circle", "\tPlayAlertSound 1"},
+		},
+		{
+			name:  "block caught by an earlier one",
+			lines: []string{"Show", `	Class == "Stackable Currency"`, "Show", `	Class == "Stackable Currency"`, `	BaseType "Divine Orb"`},
+			want:  []string{"unreachable 3"},
+		},
+		{
+			name:  "numeric range caught by a wider one",
+			lines: []string{"Show", "\tItemLevel >= 75", "Show", "\tItemLevel >= 80", "Show", "\tItemLevel >= 70"},
+			want:  []string{"unreachable 3"},
+		},
+		{
+			name:  "earlier block with continue",
+			lines: []string{"Show", "\tRarity Unique", "\tContinue", "Show", "\tRarity Unique"},
+		},
+		{
+			name:  "earlier block with an unknown condition",
+			lines: []string{"Show", `	Class == "Stackable Currency"`, "\tHasFancyNewMod True", "Show", `	Class == "Stackable Currency"`, `	BaseType "Divine Orb"`},
+		},
+		{
+			name:  "earlier block with an unknown condition and nothing else",
+			lines: []string{"Show", "\tHasFancyNewMod True", "Show", "\tRarity Unique"},
+		},
+		{
+			name:  "later block with an unknown condition",
+			lines: []string{"Show", "\tRarity Unique", "Show", "\tRarity Unique", "\tHasFancyNewMod True"},
+			want:  []string{"unreachable 3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := Parse([]byte(strings.Join(test.lines, "\n") + "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0)
+			for _, issue := range Lint(parsed) {
+				got = append(got, fmt.Sprintf("%s %d", issue.Check, issue.Line))
+			}
+
+			want := test.want
+			if want == nil {
+				want = []string{}
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
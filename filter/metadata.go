@@ -0,0 +1,198 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Metadata is what a filter's header comments say about it. NeverSink's filters (and FilterBlade's exports
+// of them) start with lines like "# VERSION: 8.10.3" and "# TYPE: 3-STRICT". Fields the header doesn't
+// mention are left empty
+type Metadata struct {
+	Version    string `json:"version,omitempty"`
+	Strictness string `json:"strictness,omitempty"`
+	Style      string `json:"style,omitempty"`
+	League     string `json:"league,omitempty"`
+	Source     string `json:"source,omitempty"`
+}
+
+// metadataPattern matches "KEY: value" header comments (after the '#')
+var metadataPattern = regexp.MustCompile(`^\s*([A-Za-z][A-Za-z ]*?)\s*:\s*(.*?)\s*$`)
+
+// strictnessPattern matches NeverSink's strictness types, e.g. "3-STRICT" or "6-UBER-PLUS-STRICT"
+var strictnessPattern = regexp.MustCompile(`^\d+\s*-\s*(.+)$`)
+
+var urlPattern = regexp.MustCompile(`\S+://\S+|www\.\S+`)
+
+// Metadata reads the filter's header, i.e. the comments before its first block
+func (f *Filter) Metadata() Metadata {
+	comments := make([]string, 0)
+	for _, node := range f.Nodes {
+		statement, ok := node.(*Statement)
+		if !ok {
+			break
+		}
+
+		if statement.Kind == StatementComment {
+			comments = append(comments, statement.Comment)
+		}
+	}
+
+	return metadataFromComments(comments)
+}
+
+// ReadMetadata reads a filter's header without parsing the rest of it, which is much faster for large filters
+func ReadMetadata(r io.Reader) (Metadata, error) {
+	comments := make([]string, 0)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	first := true
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if first {
+			line = bytes.TrimPrefix(line, utf8BOM)
+			first = false
+		}
+
+		trimmed := strings.TrimSpace(string(line))
+		if trimmed == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+
+		comments = append(comments, trimmed[1:])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return Metadata{}, err
+	}
+
+	return metadataFromComments(comments), nil
+}
+
+func metadataFromComments(comments []string) Metadata {
+	metadata := Metadata{}
+	author := ""
+	mentionsFilterBlade := false
+
+	for _, comment := range comments {
+
+		// links to FilterBlade are in every NeverSink header, so they don't say anything about the source
+		if strings.Contains(strings.ToLower(urlPattern.ReplaceAllString(comment, "")), "filterblade") {
+			mentionsFilterBlade = true
+		}
+
+		match := metadataPattern.FindStringSubmatch(comment)
+		if match == nil || match[2] == "" {
+			continue
+		}
+
+		value := match[2]
+		switch strings.ToUpper(match[1]) {
+		case "VERSION":
+			metadata.Version = strings.TrimPrefix(strings.ToLower(value), "v")
+		case "TYPE", "STRICTNESS":
+			metadata.Strictness = normalizeStrictness(value)
+		case "STYLE":
+			metadata.Style = titleCase(value)
+		case "LEAGUE":
+			metadata.League = value
+		case "SOURCE":
+			metadata.Source = value
+		case "AUTHOR":
+			author = value
+		}
+	}
+
+	if metadata.Source == "" {
+		if mentionsFilterBlade {
+			metadata.Source = "FilterBlade"
+		} else {
+			metadata.Source = author
+		}
+	}
+
+	return metadata
+}
+
+// normalizeStrictness turns "3-STRICT" into "Strict"
+func normalizeStrictness(value string) string {
+	if match := strictnessPattern.FindStringSubmatch(value); match != nil {
+		value = match[1]
+	}
+
+	return titleCase(value)
+}
+
+// titleCase turns "UBER-PLUS-STRICT" into "Uber-Plus-Strict"
+func titleCase(value string) string {
+	runes := []rune(strings.ToLower(value))
+	for idx := range runes {
+		if idx == 0 || strings.ContainsRune(" -_", runes[idx-1]) {
+			runes[idx] = []rune(strings.ToUpper(string(runes[idx])))[0]
+		}
+	}
+
+	return string(runes)
+}
+
+// CompareVersions compares two dotted versions (like "8.10.3") numerically, returning -1, 0 or 1.
+// Anything after the numbers in a part (like "3b") is ignored. Returns false if either isn't a version at all
+func CompareVersions(a, b string) (int, bool) {
+	aParts, aOk := versionParts(a)
+	bParts, bOk := versionParts(b)
+	if !aOk || !bOk {
+		return 0, false
+	}
+
+	for idx := 0; idx < len(aParts) || idx < len(bParts); idx++ {
+		var aPart, bPart int
+		if idx < len(aParts) {
+			aPart = aParts[idx]
+		}
+		if idx < len(bParts) {
+			bPart = bParts[idx]
+		}
+
+		if aPart < bPart {
+			return -1, true
+		} else if aPart > bPart {
+			return 1, true
+		}
+	}
+
+	return 0, true
+}
+
+func versionParts(version string) ([]int, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(strings.ToLower(version)), "v")
+	if version == "" {
+		return nil, false
+	}
+
+	parts := make([]int, 0)
+	for _, part := range strings.Split(version, ".") {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+
+		number, err := strconv.Atoi(part[:end])
+		if err != nil {
+			return nil, false
+		}
+
+		parts = append(parts, number)
+	}
+
+	return parts, true
+}
@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadMetadataNeverSinkExport(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "NeverSink.filter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Metadata{Version: "8.10.3", Strictness: "Strict", Style: "Default", Source: "NeverSink"}
+
+	windows := append(append([]byte{}, utf8BOM...), bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))...)
+	for name, input := range map[string][]byte{"as exported": data, "with a bom and crlf": windows} {
+		t.Run(name, func(t *testing.T) {
+			metadata, err := ReadMetadata(bytes.NewReader(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if metadata != want {
+				t.Fatalf("got %+v, want %+v", metadata, want)
+			}
+
+			// reading just the header gives the same as parsing the whole filter
+			parsed, err := Parse(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if parsedMetadata := parsed.Metadata(); parsedMetadata != metadata {
+				t.Fatalf("got %+v from the parsed filter, want %+v", parsedMetadata, metadata)
+			}
+		})
+	}
+}
+
+func TestReadMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   Metadata
+	}{
+		{name: "no header", header: "Show\n", want: Metadata{}},
+		{name: "filterblade export", header: "# VERSION: v8.10.3b\n# TYPE: 6-UBER-PLUS-STRICT\n# STYLE: dark mode\n# Customized on FilterBlade.xyz\n",
+			want: Metadata{Version: "8.10.3b", Strictness: "Uber-Plus-Strict", Style: "Dark Mode", Source: "FilterBlade"}},
+		{name: "explicit fields", header: "#LEAGUE: Settlers\n#SOURCE: Mine\n#AUTHOR: Someone\n# Edit on https://www.filterblade.xyz\n",
+			want: Metadata{League: "Settlers", Source: "Mine"}},
+		{name: "links don't make it filterblade's", header: "# AUTHOR: NeverSink\n# GET IT ON: https://www.FilterBlade.xyz\n",
+			want: Metadata{Source: "NeverSink"}},
+		{name: "header ends at the first block", header: "# VERSION: 1.0\nShow\n# VERSION: 2.0\n",
+			want: Metadata{Version: "1.0"}},
+		{name: "empty values", header: "# VERSION:\n# TYPE: \n", want: Metadata{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata, err := ReadMetadata(strings.NewReader(test.header))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if metadata != test.want {
+				t.Fatalf("got %+v, want %+v", metadata, test.want)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+		ok   bool
+	}{
+		{a: "8.10.0", b: "8.9.1", want: 1, ok: true},
+		{a: "8.9.1", b: "8.10.0", want: -1, ok: true},
+		{a: "8.10.3", b: "8.10.3", want: 0, ok: true},
+		{a: "8.10", b: "8.10.0", want: 0, ok: true},
+		{a: "8.10.1", b: "8.10", want: 1, ok: true},
+		{a: "v8.10.3", b: "8.10.3", want: 0, ok: true},
+		{a: "8.10.3b", b: "8.10.3a", want: 0, ok: true},
+		{a: "10.0.0", b: "9.99.99", want: 1, ok: true},
+		{a: "", b: "8.10.3"},
+		{a: "8.10.3", b: "latest"},
+		{a: "8..3", b: "8.0.3"},
+	}
+
+	for _, test := range tests {
+		if got, ok := CompareVersions(test.a, test.b); got != test.want || ok != test.ok {
+			t.Errorf("%q vs %q: got %d, %v; want %d, %v", test.a, test.b, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OverlayMode is where an overlay's blocks go when it's applied to a filter
+type OverlayMode string
+
+const (
+	// OverlayPrepend blocks go before everything else, so they win over the filter's own blocks
+	OverlayPrepend OverlayMode = "prepend"
+
+	// OverlayAppend blocks go after everything else, catching whatever the filter didn't
+	OverlayAppend OverlayMode = "append"
+
+	// OverlayPatch blocks change the filter's blocks that have the same identity (see Compare). The patch
+	// block's visibility replaces theirs, and each of its statements replaces theirs with the same keyword
+	OverlayPatch OverlayMode = "patch"
+)
+
+// overlayDirectivePrefix starts a comment line that switches the mode of the blocks after it, e.g. "#!append".
+// Blocks before any directive are prepended
+const overlayDirectivePrefix = "!"
+
+// Overlay is a set of personal changes to re-apply on top of a filter, every time it's replaced
+type Overlay struct {
+	Prepend []*Block
+	Append  []*Block
+	Patch   []*Block
+}
+
+// OverlayReport describes what applying an overlay did, and anything about it that may not work as intended
+type OverlayReport struct {
+	Prepended int               `json:"prepended"`
+	Appended  int               `json:"appended"`
+	Patched   int               `json:"patched"`
+	Conflicts []OverlayConflict `json:"conflicts"`
+}
+
+// OverlayConflict is a single problem with applying an overlay. Lines are 0 when they don't apply
+type OverlayConflict struct {
+	OverlayLine int    `json:"overlay_line"`
+	FilterLine  int    `json:"filter_line,omitempty"`
+	Message     string `json:"message"`
+}
+
+func (c OverlayConflict) String() string {
+	if c.FilterLine > 0 {
+		return fmt.Sprintf("overlay line %d (filter line %d): %s", c.OverlayLine, c.FilterLine, c.Message)
+	}
+
+	return fmt.Sprintf("overlay line %d: %s", c.OverlayLine, c.Message)
+}
+
+// ParseOverlay parses an overlay, which is written just like a filter, with "#!prepend", "#!append" and
+// "#!patch" comment lines deciding what happens to the blocks after them
+func ParseOverlay(data []byte) (*Overlay, error) {
+	parsed, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	overlay := &Overlay{}
+	mode := OverlayPrepend
+
+	for _, node := range parsed.Nodes {
+		switch node := node.(type) {
+		case *Statement:
+			directive, ok := overlayDirective(node)
+			if !ok {
+				continue
+			}
+
+			switch OverlayMode(directive) {
+			case OverlayPrepend, OverlayAppend, OverlayPatch:
+				mode = OverlayMode(directive)
+			default:
+				return nil, ErrorList{{Line: node.Line, Message: fmt.Sprintf("unknown overlay directive %q", directive)}}
+			}
+
+		case *Block:
+			switch mode {
+			case OverlayPrepend:
+				overlay.Prepend = append(overlay.Prepend, node)
+			case OverlayAppend:
+				overlay.Append = append(overlay.Append, node)
+			case OverlayPatch:
+				overlay.Patch = append(overlay.Patch, node)
+			}
+		}
+	}
+
+	return overlay, nil
+}
+
+func overlayDirective(statement *Statement) (string, bool) {
+	if statement.Kind != StatementComment || !strings.HasPrefix(statement.Comment, overlayDirectivePrefix) {
+		return "", false
+	}
+
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(statement.Comment, overlayDirectivePrefix))), true
+}
+
+// Apply merges the overlay into the given filter, in place. The result only depends on the order of
+// things in the overlay and the filter: patches are applied first (in overlay order), then prepended blocks
+// go before the first block and appended ones at the bottom, each group keeping its overlay order
+func (o *Overlay) Apply(f *Filter) *OverlayReport {
+	report := &OverlayReport{Conflicts: make([]OverlayConflict, 0)}
+
+	o.applyPatches(f, report)
+	report.Conflicts = append(report.Conflicts, o.findShadowedBlocks(f)...)
+	report.Conflicts = append(report.Conflicts, o.findUnreachableBlocks(f)...)
+
+	// overlay lines keep their formatting, but shouldn't bring their own line endings into the filter
+	if f.newline != "" {
+		for _, block := range append(o.Prepend, o.Append...) {
+			block.adoptNewline(f.newline)
+		}
+	}
+
+	// prepended blocks go right before the first block, so that the filter's header stays on top
+	if len(o.Prepend) > 0 {
+		firstBlock := len(f.Nodes)
+		for idx, node := range f.Nodes {
+			if _, ok := node.(*Block); ok {
+				firstBlock = idx
+				break
+			}
+		}
+
+		nodes := append([]Node{}, f.Nodes[:firstBlock]...)
+		nodes = append(nodes, NewComment(" filtersnatch overlay: prepended blocks"))
+		for _, block := range o.Prepend {
+			nodes = append(nodes, block, &Statement{Kind: StatementBlank})
+		}
+
+		f.Nodes = append(nodes, f.Nodes[firstBlock:]...)
+		report.Prepended = len(o.Prepend)
+	}
+
+	if len(o.Append) > 0 {
+		f.Nodes = append(f.Nodes, &Statement{Kind: StatementBlank}, NewComment(" filtersnatch overlay: appended blocks"))
+		for _, block := range o.Append {
+			f.Nodes = append(f.Nodes, block, &Statement{Kind: StatementBlank})
+		}
+
+		report.Appended = len(o.Append)
+	}
+
+	return report
+}
+
+func (o *Overlay) applyPatches(f *Filter, report *OverlayReport) {
+	blocks := identifyBlocks(f)
+
+	// which patch last set each keyword of each block, to tell when two patches fight over it
+	patchedBy := make(map[*Block]map[string]int)
+
+	for _, patch := range o.Patch {
+		key := patchIdentity(patch)
+		if key == "" {
+			report.Conflicts = append(report.Conflicts, OverlayConflict{
+				OverlayLine: patch.Line,
+				Message:     "patch block has no header comment to match blocks by",
+			})
+			continue
+		}
+
+		matched := false
+		for _, target := range blocks {
+			if strings.TrimSuffix(target.ref.Key, duplicateSuffix(target.ref.Key)) != key {
+				continue
+			}
+
+			matched = true
+			if patchedBy[target.block] == nil {
+				patchedBy[target.block] = make(map[string]int)
+				report.Patched++
+			}
+
+			target.block.Kind = patch.Kind
+			for _, statement := range patch.Statements {
+				if !statement.IsDirective() {
+					continue
+				}
+
+				keyword := strings.ToLower(statement.Keyword)
+				if previousLine, ok := patchedBy[target.block][keyword]; ok {
+					report.Conflicts = append(report.Conflicts, OverlayConflict{
+						OverlayLine: statement.Line,
+						FilterLine:  target.block.Line,
+						Message:     fmt.Sprintf("%s was already patched on overlay line %d, this patch wins", statement.Keyword, previousLine),
+					})
+				}
+
+				patchedBy[target.block][keyword] = statement.Line
+				replaceStatement(target.block, statement)
+			}
+		}
+
+		if !matched {
+			report.Conflicts = append(report.Conflicts, OverlayConflict{
+				OverlayLine: patch.Line,
+				Message:     fmt.Sprintf("no block matches %q", key),
+			})
+		}
+	}
+}
+
+func (b *Block) adoptNewline(newline string) {
+	if b.header.parsed && b.header.eol != "" {
+		b.header.eol = newline
+	}
+
+	for _, statement := range b.Statements {
+		if statement.line.parsed && statement.line.eol != "" {
+			statement.line.eol = newline
+		}
+	}
+}
+
+// patchIdentity is what a patch block matches filter blocks by, which only comes from its header comment
+func patchIdentity(patch *Block) string {
+	if strings.TrimSpace(patch.Comment) == "" {
+		return ""
+	}
+
+	return blockIdentity(patch, "")
+}
+
+// duplicateSuffix returns the " #N" identifyBlocks adds to repeated keys, so that patches apply to all of them
+func duplicateSuffix(key string) string {
+	idx := strings.LastIndex(key, " #")
+	if idx < 0 {
+		return ""
+	}
+
+	for _, r := range key[idx+2:] {
+		if r < '0' || r > '9' {
+			return ""
+		}
+	}
+
+	return key[idx:]
+}
+
+// replaceStatement puts a copy of the statement where the block's first statement with the same keyword is,
+// dropping any others with that keyword. If there are none, it's added at the end
+func replaceStatement(block *Block, statement *Statement) {
+	replacement := *statement
+	statements := make([]*Statement, 0, len(block.Statements)+1)
+	replaced := false
+
+	for _, existing := range block.Statements {
+		if !strings.EqualFold(existing.Keyword, statement.Keyword) {
+			statements = append(statements, existing)
+			continue
+		}
+
+		if !replaced {
+			replacement.Line, replacement.line = existing.Line, existing.line
+			statements = append(statements, &replacement)
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		replacement.Line, replacement.line = 0, line{}
+		statements = append(statements, &replacement)
+	}
+
+	block.Statements = statements
+}
+
+// findShadowedBlocks reports prepended blocks that take BaseTypes away from filter blocks with a different
+// visibility. That's usually the point of them, but it's good to know when the filter starts handling them
+func (o *Overlay) findShadowedBlocks(f *Filter) []OverlayConflict {
+	conflicts := make([]OverlayConflict, 0)
+
+	for _, prepended := range o.Prepend {
+		if prepended.HasContinue() {
+			continue
+		}
+
+		overridden := baseTypes(prepended)
+		for _, block := range f.Blocks() {
+			if block.Kind == prepended.Kind || len(overridden) == 0 {
+				continue
+			}
+
+			for _, baseType := range setIntersection(overridden, baseTypes(block)) {
+				conflicts = append(conflicts, OverlayConflict{
+					OverlayLine: prepended.Line,
+					FilterLine:  block.Line,
+					Message:     fmt.Sprintf("%s %q, which the filter would %s", strings.ToLower(string(prepended.Kind)), baseType, strings.ToLower(string(block.Kind))),
+				})
+
+				delete(overridden, baseType)
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// findUnreachableBlocks reports appended blocks that no item can ever reach, because the filter ends with
+// a block that catches everything
+func (o *Overlay) findUnreachableBlocks(f *Filter) []OverlayConflict {
+	conflicts := make([]OverlayConflict, 0)
+	if len(o.Append) == 0 {
+		return conflicts
+	}
+
+	for _, block := range f.Blocks() {
+		if len(block.Conditions()) > 0 || block.HasContinue() {
+			continue
+		}
+
+		for _, appended := range o.Append {
+			conflicts = append(conflicts, OverlayConflict{
+				OverlayLine: appended.Line,
+				FilterLine:  block.Line,
+				Message:     "appended block is unreachable, the filter catches every item before it",
+			})
+		}
+
+		break
+	}
+
+	return conflicts
+}
+
+func setIntersection(a, b map[string]bool) []string {
+	result := make([]string, 0)
+	for value := range a {
+		if b[value] {
+			result = append(result, value)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
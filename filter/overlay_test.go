@@ -0,0 +1,182 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testOverlay = `# my overlay
+Show # first
+	BaseType == "Mirror Shard"
+
+Hide # second
+	BaseType == "Chaos Orb"
+
+#!patch
+Show # $type->currency $tier->t2
+	SetFontSize 45
+	PlayEffect Red
+
+Hide # $type->currency $tier->scrolls
+	SetFontSize 18
+
+Show # $type->currency $tier->t2
+	SetFontSize 30
+
+Show
+	SetFontSize 1
+
+Show # $tier->nothing
+	SetFontSize 1
+
+#!append
+Show # leftovers
+	Class == "Maps"
+`
+
+func mustParseOverlay(t *testing.T, data string) *Overlay {
+	t.Helper()
+
+	overlay, err := ParseOverlay([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return overlay
+}
+
+func TestParseOverlay(t *testing.T) {
+	overlay := mustParseOverlay(t, testOverlay)
+
+	if len(overlay.Prepend) != 2 || len(overlay.Patch) != 5 || len(overlay.Append) != 1 {
+		t.Fatalf("got %d prepended, %d patch and %d appended blocks", len(overlay.Prepend), len(overlay.Patch), len(overlay.Append))
+	}
+
+	if _, err := ParseOverlay([]byte("#!replace\nShow\n")); err == nil {
+		t.Fatal("parsed an unknown directive")
+	}
+}
+
+func TestOverlayApply(t *testing.T) {
+	merged := mustParse(t, diffBefore)
+	report := mustParseOverlay(t, testOverlay).Apply(merged)
+
+	want := `# [[0100]] Currency
+# filtersnatch overlay: prepended blocks
+Show # first
+	BaseType == "Mirror Shard"
+
+Hide # second
+	BaseType == "Chaos Orb"
+
+Show # $type->currency $tier->t1
+	Class == "Stackable Currency"
+	BaseType == "Divine Orb" "Exalted Orb"
+	SetFontSize 45
+
+Show # $type->currency $tier->t2
+	Class == "Stackable Currency"
+	BaseType == "Chaos Orb"
+	SetFontSize 30
+	PlayEffect Red
+
+Hide # $type->currency $tier->scrolls
+	Class == "Stackable Currency"
+	BaseType == "Portal Scroll"
+	SetFontSize 18
+
+# [[0200]] Flasks
+Show
+	Class == "Life Flasks"
+	AreaLevel <= 67
+
+# filtersnatch overlay: appended blocks
+Show # leftovers
+	Class == "Maps"
+
+`
+
+	if got := merged.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if report.Prepended != 2 || report.Appended != 1 || report.Patched != 2 {
+		t.Errorf("got %d prepended, %d appended and %d patched", report.Prepended, report.Appended, report.Patched)
+	}
+
+	// patch problems first (in overlay order), then what the prepended and appended blocks run into
+	wantConflicts := []OverlayConflict{
+		{OverlayLine: 17, FilterLine: 7, Message: "SetFontSize was already patched on overlay line 10, this patch wins"},
+		{OverlayLine: 19, Message: "patch block has no header comment to match blocks by"},
+		{OverlayLine: 22, Message: `no block matches "$tier->nothing"`},
+		{OverlayLine: 5, FilterLine: 7, Message: `hide "Chaos Orb", which the filter would show`},
+	}
+	if !reflect.DeepEqual(report.Conflicts, wantConflicts) {
+		t.Errorf("got conflicts %v, want %v", report.Conflicts, wantConflicts)
+	}
+}
+
+func TestOverlayApplyIsDeterministic(t *testing.T) {
+	overlay := `Hide
+	BaseType == "Portal Scroll" "Chaos Orb" "Exalted Orb" "Divine Orb"
+
+#!append
+Show # a
+Show # b
+Show # c
+`
+
+	var first string
+	var firstConflicts []OverlayConflict
+
+	for i := 0; i < 20; i++ {
+		merged := mustParse(t, diffBefore)
+		report := mustParseOverlay(t, overlay).Apply(merged)
+
+		if i == 0 {
+			first, firstConflicts = merged.String(), report.Conflicts
+			continue
+		}
+
+		if merged.String() != first || !reflect.DeepEqual(report.Conflicts, firstConflicts) {
+			t.Fatalf("applying the same overlay gave different results:\n%s\n%v\nand\n%s\n%v", first, firstConflicts, merged.String(), report.Conflicts)
+		}
+	}
+
+	// appended blocks keep their overlay order
+	if a, b, c := strings.Index(first, "# a"), strings.Index(first, "# b"), strings.Index(first, "# c"); !(a < b && b < c) {
+		t.Fatalf("appended blocks out of order:\n%s", first)
+	}
+
+	// shadowed BaseTypes are reported block by block, then by name
+	want := []string{`"Divine Orb"`, `"Exalted Orb"`, `"Chaos Orb"`, `"Portal Scroll"`}
+	if len(firstConflicts) != len(want) {
+		t.Fatalf("got conflicts %v", firstConflicts)
+	}
+
+	for idx, conflict := range firstConflicts {
+		if !strings.Contains(conflict.Message, want[idx]) {
+			t.Errorf("conflict %d is %v, want it about %s", idx, conflict, want[idx])
+		}
+	}
+}
+
+func TestOverlayApplyUnreachableAppend(t *testing.T) {
+	merged := mustParse(t, "Show\n\tRarity Unique\n\nHide\n\tContinue\n\nHide # catch all\n")
+	report := mustParseOverlay(t, "#!append\nShow # mine\n\tClass == \"Maps\"\n").Apply(merged)
+
+	want := []OverlayConflict{{OverlayLine: 2, FilterLine: 7, Message: "appended block is unreachable, the filter catches every item before it"}}
+	if !reflect.DeepEqual(report.Conflicts, want) {
+		t.Fatalf("got conflicts %v, want %v", report.Conflicts, want)
+	}
+}
+
+func TestOverlayApplyAdoptsLineEndings(t *testing.T) {
+	merged := mustParse(t, "# header\r\nShow\r\n\tRarity Unique\r\n")
+	mustParseOverlay(t, "Hide # mine\n\tClass == \"Maps\"\n#!append\nShow # end\n").Apply(merged)
+
+	if printed := merged.String(); strings.Contains(strings.ReplaceAll(printed, "\r\n", ""), "\n") {
+		t.Fatalf("got mixed line endings: %q", printed)
+	}
+}
@@ -0,0 +1,308 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Error is a syntax error on a specific line
+type Error struct {
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// ErrorList is every syntax error found while parsing a filter, in line order
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+
+	return fmt.Sprintf("%s (and %d more errors)", l[0].Error(), len(l)-1)
+}
+
+// Parse parses an item filter. The returned filter is always usable, even when there are syntax errors:
+// lines that couldn't be made sense of are kept as they are. If there were any, the error is an ErrorList
+func Parse(data []byte) (*Filter, error) {
+	p := &parser{filter: &Filter{}}
+
+	if bytes.HasPrefix(data, utf8BOM) {
+		p.filter.BOM = true
+		data = data[len(utf8BOM):]
+	}
+
+	for len(data) > 0 {
+		var raw, eol string
+
+		if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+			raw, eol = string(data[:idx]), "\n"
+			data = data[idx+1:]
+		} else {
+			raw = string(data)
+			data = nil
+		}
+
+		if strings.HasSuffix(raw, "\r") {
+			raw, eol = raw[:len(raw)-1], "\r"+eol
+		}
+
+		if p.filter.newline == "" && eol != "" {
+			p.filter.newline = eol
+		}
+
+		p.lineNumber++
+		p.parseLine(raw, eol)
+	}
+
+	p.closeBlock()
+
+	if len(p.errors) > 0 {
+		return p.filter, p.errors
+	}
+
+	return p.filter, nil
+}
+
+// ParseFile reads and parses an item filter from disk
+func ParseFile(path string) (*Filter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+type parser struct {
+	filter     *Filter
+	block      *Block
+	lineNumber int
+	errors     ErrorList
+}
+
+func (p *parser) errorf(format string, args ...interface{}) {
+	p.errors = append(p.errors, &Error{Line: p.lineNumber, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *parser) parseLine(raw string, eol string) {
+	content := strings.TrimLeft(raw, " \t")
+	indent := raw[:len(raw)-len(content)]
+	parsed := line{raw: raw, eol: eol, indent: indent, parsed: true}
+
+	if strings.TrimSpace(content) == "" {
+		p.addStatement(&Statement{Kind: StatementBlank, Line: p.lineNumber, line: parsed})
+		return
+	}
+
+	if strings.HasPrefix(content, "#") {
+		p.addStatement(&Statement{Kind: StatementComment, Comment: content[1:], Line: p.lineNumber, line: parsed})
+		return
+	}
+
+	keyword, rest := splitKeyword(content)
+
+	if kind, ok := blockKinds[strings.ToLower(keyword)]; ok {
+		p.closeBlock()
+
+		values, comment, err := tokenize(rest)
+		if err != nil {
+			p.errorf("%v", err)
+		} else if len(values) > 0 {
+			p.errorf("unexpected %q after %s", values[0].Text, kind)
+		}
+
+		p.block = &Block{Kind: kind, Comment: comment, Line: p.lineNumber, header: parsed}
+		p.block.header.canonical = p.block.canonical()
+		return
+	}
+
+	if strings.EqualFold(keyword, importKeyword) {
+		p.parseImport(keyword, rest, parsed)
+		return
+	}
+
+	statement := &Statement{Kind: StatementUnknown, Keyword: keyword, Line: p.lineNumber, line: parsed}
+
+	values, comment, err := tokenize(rest)
+	if err != nil {
+		p.errorf("%v", err)
+	}
+
+	statement.Comment = comment
+	statement.Operator, statement.Values = splitOperator(values)
+
+	lowerKeyword := strings.ToLower(keyword)
+	switch {
+	case IsCondition(keyword):
+		statement.Kind = StatementCondition
+	case IsAction(keyword):
+		statement.Kind = StatementAction
+	case lowerKeyword == strings.ToLower(continueKeyword):
+		statement.Kind = StatementContinue
+	}
+
+	switch statement.Kind {
+	case StatementContinue:
+		if len(statement.Values) > 0 || statement.Operator != "" {
+			p.errorf("unexpected %q after %s", strings.TrimSpace(rest), keyword)
+		}
+
+	case StatementCondition, StatementAction:
+		if _, flag := flagKeywords[lowerKeyword]; !flag && len(statement.Values) == 0 && err == nil {
+			p.errorf("%s needs at least one value", keyword)
+		}
+	}
+
+	if p.block == nil {
+		p.errorf("%s outside of a block", keyword)
+	}
+
+	p.addStatement(statement)
+}
+
+// parseImport parses an Import line, e.g. `Import "base.filter" Optional`. Imports only make sense
+// between blocks, so one also ends the block before it
+func (p *parser) parseImport(keyword string, rest string, parsed line) {
+	p.closeBlock()
+
+	statement := &Statement{Kind: StatementImport, Keyword: keyword, Line: p.lineNumber, line: parsed}
+
+	values, comment, err := tokenize(rest)
+	if err != nil {
+		p.errorf("%v", err)
+	}
+
+	statement.Comment = comment
+	statement.Values = values
+
+	switch {
+	case err != nil:
+	case len(values) == 0 || !values[0].Quoted:
+		p.errorf("%s needs a quoted file name", keyword)
+	case len(values) > 2 || (len(values) == 2 && !strings.EqualFold(values[1].Text, importOptionalFlag)):
+		p.errorf("unexpected %q after %s's file name", values[1].Text, keyword)
+	}
+
+	p.addStatement(statement)
+}
+
+func (p *parser) addStatement(statement *Statement) {
+	statement.line.canonical = statement.canonical()
+
+	if p.block == nil {
+		p.filter.Nodes = append(p.filter.Nodes, statement)
+		return
+	}
+
+	p.block.Statements = append(p.block.Statements, statement)
+}
+
+// closeBlock adds the current block to the filter. Comments and blank lines at the end of a block
+// usually introduce whatever comes next (like section markers do), so they're moved out of it
+func (p *parser) closeBlock() {
+	if p.block == nil {
+		return
+	}
+
+	end := len(p.block.Statements)
+	for end > 0 && !p.block.Statements[end-1].IsDirective() {
+		end--
+	}
+
+	trailing := p.block.Statements[end:]
+	p.block.Statements = p.block.Statements[:end]
+
+	p.filter.Nodes = append(p.filter.Nodes, p.block)
+	for _, statement := range trailing {
+		p.filter.Nodes = append(p.filter.Nodes, statement)
+	}
+
+	p.block = nil
+}
+
+// splitKeyword splits a line (without indentation) into its first word and everything after it
+func splitKeyword(content string) (string, string) {
+	end := strings.IndexAny(content, " \t#\"")
+	if end < 0 {
+		end = len(content)
+	}
+
+	// operators can be glued to the keyword, e.g. "ItemLevel>=75"
+	for idx := 0; idx < end; idx++ {
+		if strings.ContainsRune("=!<>", rune(content[idx])) {
+			return content[:idx], content[idx:]
+		}
+	}
+
+	return content[:end], content[end:]
+}
+
+// tokenize splits the rest of a line into values and a trailing comment
+func tokenize(rest string) ([]Value, string, error) {
+	values := make([]Value, 0)
+
+	for idx := 0; idx < len(rest); {
+		switch rest[idx] {
+		case ' ', '\t':
+			idx++
+
+		case '#':
+			return values, rest[idx+1:], nil
+
+		case '"':
+			end := strings.IndexByte(rest[idx+1:], '"')
+			if end < 0 {
+				return values, "", fmt.Errorf("unterminated quoted value %s", rest[idx:])
+			}
+
+			values = append(values, Value{Text: rest[idx+1 : idx+1+end], Quoted: true})
+			idx += end + 2
+
+		default:
+			end := strings.IndexAny(rest[idx:], " \t#\"")
+			if end < 0 {
+				end = len(rest) - idx
+			}
+
+			values = append(values, Value{Text: rest[idx : idx+end]})
+			idx += end
+		}
+	}
+
+	return values, "", nil
+}
+
+// splitOperator takes the operator off the first value, if it has one. Operators can be glued to the value
+// that follows them, e.g. "HasExplicitMod >=2 ..." or "ItemLevel >=75"
+func splitOperator(values []Value) (string, []Value) {
+	if len(values) == 0 || values[0].Quoted {
+		return "", values
+	}
+
+	for _, operator := range operators {
+		if !strings.HasPrefix(values[0].Text, operator) {
+			continue
+		}
+
+		remainder := values[0].Text[len(operator):]
+		if remainder == "" {
+			return operator, values[1:]
+		}
+
+		return operator, append([]Value{{Text: remainder}}, values[1:]...)
+	}
+
+	return "", values
+}
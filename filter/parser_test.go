@@ -0,0 +1,212 @@
+package filter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		blocks int
+		errors int
+	}{
+		{name: "empty", input: ""},
+		{name: "utf-8 bom", input: "\xEF\xBB\xBFShow\n\tRarity Unique\n", blocks: 1},
+		{name: "crlf", input: "# header\r\nShow\r\n\tRarity Unique\r\n\r\nHide\r\n", blocks: 2},
+		{name: "mixed line endings", input: "Show\r\n\tRarity Unique\n\tSetFontSize 40\r\n\nHide\n", blocks: 2},
+		{name: "tabs and spaces", input: "Show\t# trailing\n \t BaseType\t==\t\"Divine Orb\"  \"Exalted Orb\"\t\n    SetFontSize\t45\n", blocks: 1},
+		{name: "glued operators", input: "Show\n\tItemLevel>=75\n\tStackSize >=2\n\tRarity!=Normal\n", blocks: 1},
+		{name: "unterminated quote", input: "Show\n\tBaseType == \"Divine Orb\n\tSetFontSize 45\n", blocks: 1, errors: 1},
+		{name: "no newline at the end", input: "Show\n\tRarity Unique", blocks: 1},
+		{name: "no newline after a comment", input: "Show\n\tRarity Unique\n# the end", blocks: 1},
+		{name: "only comments and blank lines", input: "# one\n\n   \n# two\n"},
+		{name: "statement outside of a block", input: "Rarity Unique\nShow\n", blocks: 1, errors: 1},
+		{name: "unknown keyword", input: "Show\n\tFancyNewCondition True\n", blocks: 1},
+		{name: "imports", input: "Import \"base.filter\"\r\nImport \"extras.filter\" Optional # mine\r\nShow\r\n", blocks: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := Parse([]byte(test.input))
+
+			var errors ErrorList
+			if err != nil {
+				errors = err.(ErrorList)
+			}
+
+			if len(errors) != test.errors {
+				t.Errorf("got %d errors (%v), want %d", len(errors), err, test.errors)
+			}
+
+			if blocks := len(parsed.Blocks()); blocks != test.blocks {
+				t.Errorf("got %d blocks, want %d", blocks, test.blocks)
+			}
+
+			if printed := parsed.String(); printed != test.input {
+				t.Errorf("printed %q, want %q", printed, test.input)
+			}
+		})
+	}
+}
+
+func TestParseNeverSinkExport(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "NeverSink.filter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same filter the way it's saved on windows
+	windows := append(append([]byte{}, utf8BOM...), bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))...)
+
+	for name, input := range map[string][]byte{"as exported": data, "with a bom and crlf": windows} {
+		t.Run(name, func(t *testing.T) {
+			parsed, err := Parse(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(parsed.Bytes(), input) {
+				t.Fatal("printed filter differs from the parsed one")
+			}
+
+			if blocks := len(parsed.Blocks()); blocks != 9 {
+				t.Fatalf("got %d blocks, want 9", blocks)
+			}
+
+			metadata := parsed.Metadata()
+			if metadata.Version != "8.10.3" || metadata.Strictness != "Strict" {
+				t.Fatalf("got %+v", metadata)
+			}
+
+			first := parsed.Blocks()[0]
+			if first.Kind != BlockShow || first.Comment != " $type->globaloverride $tier->exoticbases" {
+				t.Fatalf("first block is %s #%s", first.Kind, first.Comment)
+			}
+
+			if got := first.First("BaseType").Strings(); !reflect.DeepEqual(got, []string{"Mirror of Kalandra", "Mirror Shard"}) {
+				t.Fatalf("first block's BaseType is %v", got)
+			}
+
+			// section markers end up between blocks, not at the end of the block before them
+			for _, block := range parsed.Blocks() {
+				for _, statement := range block.Statements {
+					if _, _, _, ok := statement.Section(); ok {
+						t.Fatalf("section marker on line %d is inside the block on line %d", statement.Line, block.Line)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	parsed, err := Parse([]byte("Show\n\tItemLevel>=75\n\tHasExplicitMod >=2 \"of Haast\" \"Veiled\"\n\tBaseType \"Divine Orb\"\n\tEnableDropSound\n\tContinue\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		kind     StatementKind
+		keyword  string
+		operator string
+		values   []string
+	}{
+		{StatementCondition, "ItemLevel", ">=", []string{"75"}},
+		{StatementCondition, "HasExplicitMod", ">=", []string{"2", "of Haast", "Veiled"}},
+		{StatementCondition, "BaseType", "", []string{"Divine Orb"}},
+		{StatementAction, "EnableDropSound", "", []string{}},
+		{StatementContinue, "Continue", "", []string{}},
+	}
+
+	statements := parsed.Blocks()[0].Statements
+	if len(statements) != len(tests) {
+		t.Fatalf("got %d statements, want %d", len(statements), len(tests))
+	}
+
+	for idx, test := range tests {
+		statement := statements[idx]
+		if statement.Kind != test.kind || statement.Keyword != test.keyword || statement.Operator != test.operator || !reflect.DeepEqual(statement.Strings(), test.values) {
+			t.Errorf("line %d: got %d %s %q %v, want %d %s %q %v", statement.Line,
+				statement.Kind, statement.Keyword, statement.Operator, statement.Strings(),
+				test.kind, test.keyword, test.operator, test.values)
+		}
+	}
+}
+
+func TestParseImports(t *testing.T) {
+	parsed, err := Parse([]byte("Import \"base.filter\"\nShow\n\tRarity Unique\nimport \"extras.filter\" optional\n\tSetFontSize 45\n"))
+
+	// an import ends the block before it, so what comes after it is outside of any block
+	if errors, ok := err.(ErrorList); !ok || len(errors) != 1 || errors[0].Line != 5 {
+		t.Fatalf("got %v, want an error for line 5 only", err)
+	}
+
+	imports := make([]string, 0)
+	for _, node := range parsed.Nodes {
+		statement, ok := node.(*Statement)
+		if !ok {
+			continue
+		}
+
+		if path, optional, ok := statement.Import(); ok {
+			imports = append(imports, path+map[bool]string{true: " (optional)"}[optional])
+		}
+	}
+
+	if !reflect.DeepEqual(imports, []string{"base.filter", "extras.filter (optional)"}) {
+		t.Fatalf("got imports %v", imports)
+	}
+
+	if statements := parsed.Blocks()[0].Statements; len(statements) != 1 {
+		t.Fatalf("block before the import has %d statements, want 1", len(statements))
+	}
+
+	for _, input := range []string{"Import base.filter\n", "Import\n", "Import \"base.filter\" Required\n", "Import \"a.filter\" \"b.filter\"\n"} {
+		parsed, err := Parse([]byte(input))
+		if err == nil {
+			t.Errorf("%q: no error", input)
+		}
+
+		if parsed.String() != input {
+			t.Errorf("%q: printed %q", input, parsed.String())
+		}
+	}
+}
+
+func TestPrintModified(t *testing.T) {
+	input := "Show\r\n  Rarity Unique\n  SetFontSize 40\r\n"
+
+	parsed, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := parsed.Blocks()[0]
+	block.First("Rarity").Values[0].Text = "Rare"
+	block.Statements = append(block.Statements, NewStatement("PlayEffect", "", "Red"))
+
+	// modified lines keep their indentation and line ending, and new ones follow the line before them
+	want := "Show\r\n  Rarity Rare\n  SetFontSize 40\r\n  PlayEffect Red\r\n"
+	if printed := parsed.String(); printed != want {
+		t.Fatalf("printed %q, want %q", printed, want)
+	}
+
+	// a new line after one without a line ending starts on a line of its own
+	parsed, err = Parse([]byte(strings.TrimSuffix(input, "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block = parsed.Blocks()[0]
+	block.Statements = append(block.Statements, NewStatement("PlayEffect", "", "Red"))
+
+	if printed := parsed.String(); printed != "Show\r\n  Rarity Unique\n  SetFontSize 40\n  PlayEffect Red\n" {
+		t.Fatalf("printed %q", printed)
+	}
+}
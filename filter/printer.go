@@ -0,0 +1,148 @@
+package filter
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+const (
+	defaultNewline = "\r\n"
+	defaultIndent  = "    "
+)
+
+// WriteTo prints the filter. Anything that wasn't modified since parsing is printed exactly as it was read
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	p := &printer{newline: f.newline}
+	if p.newline == "" {
+		p.newline = defaultNewline
+	}
+
+	if f.BOM {
+		p.buf.Write(utf8BOM)
+	}
+
+	for _, node := range f.Nodes {
+		switch node := node.(type) {
+		case *Block:
+			p.printBlock(node)
+		case *Statement:
+			p.printStatement(node, "")
+		}
+	}
+
+	return p.buf.WriteTo(w)
+}
+
+// Bytes returns the printed filter
+func (f *Filter) Bytes() []byte {
+	var buf bytes.Buffer
+	f.WriteTo(&buf)
+
+	return buf.Bytes()
+}
+
+func (f *Filter) String() string {
+	return string(f.Bytes())
+}
+
+// String returns the canonical form of the statement, without indentation
+func (s *Statement) String() string {
+	return s.canonical()
+}
+
+func (s *Statement) canonical() string {
+	switch s.Kind {
+	case StatementBlank:
+		return ""
+	case StatementComment:
+		return "#" + s.Comment
+	}
+
+	var builder strings.Builder
+	builder.WriteString(s.Keyword)
+
+	if s.Operator != "" {
+		builder.WriteString(" " + s.Operator)
+	}
+
+	for _, value := range s.Values {
+		builder.WriteString(" ")
+		if value.Quoted {
+			builder.WriteString(`"` + value.Text + `"`)
+		} else {
+			builder.WriteString(value.Text)
+		}
+	}
+
+	if s.Comment != "" {
+		builder.WriteString(" #" + s.Comment)
+	}
+
+	return builder.String()
+}
+
+func (b *Block) canonical() string {
+	if b.Comment != "" {
+		return string(b.Kind) + " #" + b.Comment
+	}
+
+	return string(b.Kind)
+}
+
+type printer struct {
+	buf     bytes.Buffer
+	newline string
+
+	// set when the last line printed had no line ending (which only the last line of a file may lack)
+	unterminated bool
+}
+
+func (p *printer) printLine(original line, canonical string, indent string) {
+	if p.unterminated {
+		p.buf.WriteString(p.newline)
+	}
+
+	// new lines match the line ending of the line before them, in case the file mixes them
+	eol := p.newline
+	if original.parsed {
+		eol = original.eol
+	}
+
+	if eol != "" {
+		p.newline = eol
+	}
+
+	if original.parsed && original.canonical == canonical {
+		p.buf.WriteString(original.raw)
+	} else {
+		if original.parsed {
+			indent = original.indent
+		}
+
+		p.buf.WriteString(indent + canonical)
+	}
+
+	p.buf.WriteString(eol)
+	p.unterminated = eol == ""
+}
+
+func (p *printer) printBlock(b *Block) {
+	p.printLine(b.header, b.canonical(), "")
+
+	indent := defaultIndent
+	for _, statement := range b.Statements {
+		if statement.line.parsed && statement.IsDirective() {
+			indent = statement.line.indent
+			break
+		}
+	}
+
+	for _, statement := range b.Statements {
+		p.printStatement(statement, indent)
+	}
+}
+
+func (p *printer) printStatement(s *Statement, indent string) {
+	p.printLine(s.line, s.canonical(), indent)
+}
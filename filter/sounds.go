@@ -0,0 +1,50 @@
+package filter
+
+import "strings"
+
+// noCustomSound is what CustomAlertSound is set to in order to turn off a sound set by an earlier block
+const noCustomSound = "None"
+
+// SoundReference is a custom alert sound file a filter plays. Paths are as written in the filter,
+// which the game resolves relative to the filters directory
+type SoundReference struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+
+	// Optional is set for CustomAlertSoundOptional, which the game silently skips if the file is missing
+	Optional bool `json:"optional,omitempty"`
+}
+
+// CustomSounds returns every sound file the filter refers to, once each (where it's first referred to).
+// A file that's referred to both ways only counts as optional if it's optional everywhere
+func (f *Filter) CustomSounds() []SoundReference {
+	sounds := make([]SoundReference, 0)
+	seen := make(map[string]int)
+
+	for _, block := range f.Blocks() {
+		for _, statement := range block.Statements {
+			optional := strings.EqualFold(statement.Keyword, "CustomAlertSoundOptional")
+			if !optional && !strings.EqualFold(statement.Keyword, "CustomAlertSound") {
+				continue
+			}
+
+			if len(statement.Values) == 0 || strings.EqualFold(statement.Values[0].Text, noCustomSound) ||
+				strings.TrimSpace(statement.Values[0].Text) == "" {
+				continue
+			}
+
+			path := statement.Values[0].Text
+			key := strings.ToLower(strings.ReplaceAll(path, `\`, "/"))
+
+			if idx, ok := seen[key]; ok {
+				sounds[idx].Optional = sounds[idx].Optional && optional
+				continue
+			}
+
+			seen[key] = len(sounds)
+			sounds = append(sounds, SoundReference{Path: path, Line: statement.Line, Optional: optional})
+		}
+	}
+
+	return sounds
+}
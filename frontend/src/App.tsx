@@ -8,6 +8,15 @@ import {
   SetStartInTrayAndUpdateConfig,
   SetDownloadsStrategyAndUpdateConfig,
   SetFiltersStrategyAndUpdateConfig,
+  SetRememberPausedAndUpdateConfig,
+  SetStrictValidationAndUpdateConfig,
+  SetAllowDowngradeAndUpdateConfig,
+  SetCopySoundsFromDownloadsAndUpdateConfig,
+  GetMissingAssets,
+  IsPaused,
+  TogglePause,
+  GetStatus,
+  GetConfigIssues,
 } from "../wailsjs/go/main/App";
 import { main } from "../wailsjs/go/models";
 import {
@@ -18,6 +27,22 @@ import {
   LogDebug,
 } from "../wailsjs/runtime";
 import FileEntryAndModeSelector from "./FileEntryAndModeSelector";
+import RulesEditor from "./RulesEditor";
+import BackupsMenu from "./BackupsMenu";
+
+// only the directories that actually changed need to be listed again
+const changesIn = (event: main.FileChangesEvent, directory: string) =>
+  event.changes.some((change) => change.directory === directory);
+
+const describeConfigIssue = (issue: main.ConfigIssue) =>
+  issue.key ? `${issue.key}: ${issue.message}` : issue.message;
+
+// what happened to the last download, as shown above the grid
+type DownloadOutcome = {
+  text: string;
+  details?: string;
+  className: string;
+};
 
 const App = () => {
   const [chosenFiltersDir, setChosenFiltersDir] = useState("");
@@ -32,6 +57,17 @@ const App = () => {
     useState("");
 
   const [startInTray, setStartInTray] = useState(false);
+  const [rememberPaused, setRememberPaused] = useState(false);
+  const [strictValidation, setStrictValidation] = useState(false);
+  const [allowDowngrade, setAllowDowngrade] = useState(false);
+  const [copySounds, setCopySounds] = useState(false);
+  const [paused, setPaused] = useState(false);
+  const [status, setStatus] = useState<main.Status>();
+  const [lastQuarantine, setLastQuarantine] =
+    useState<main.DownloadQuarantinedEvent>();
+  const [lastOutcome, setLastOutcome] = useState<DownloadOutcome>();
+  const [missingAssets, setMissingAssets] = useState<main.MissingAsset[]>([]);
+  const [configIssues, setConfigIssues] = useState<main.ConfigIssue[]>([]);
 
   const [filtersInFiltersDir, setFiltersInFiltersDir] =
     useState<main.FileListEntry[]>();
@@ -46,6 +82,7 @@ const App = () => {
       ListFiltersInDir(chosenFiltersDir).then((filters) => {
         setFiltersInFiltersDir(filters as main.FileListEntry[]);
       });
+      GetMissingAssets().then((missing) => setMissingAssets(missing || []));
     }
   };
 
@@ -69,22 +106,111 @@ const App = () => {
       setChosenDownloadsWatchedFile(config.downloads_named_file);
 
       setStartInTray(config.start_in_tray);
+      setRememberPaused(config.remember_paused);
+      setStrictValidation(config.strict_validation);
+      setAllowDowngrade(config.allow_downgrade);
+      setCopySounds(config.copy_sounds_from_downloads);
 
       setConfigLoaded(true);
     });
+
+    IsPaused().then(setPaused);
+    EventsOn("paused_state_changed", (event: main.PausedStateChanged) => {
+      setPaused(event.paused);
+    });
+
+    const refreshConfigIssues = () =>
+      GetConfigIssues().then((issues) => setConfigIssues(issues || []));
+
+    GetStatus().then(setStatus);
+    EventsOn("status_changed", (newStatus: main.Status) => {
+      setStatus(newStatus);
+
+      // directories coming and going changes what's wrong with the config too
+      refreshConfigIssues();
+    });
+
+    refreshConfigIssues();
+    EventsOn("config_changed", refreshConfigIssues);
+
+    EventsOn(
+      "download_quarantined",
+      (event: main.DownloadQuarantinedEvent) => {
+        setLastQuarantine(event);
+      }
+    );
+
+    EventsOn(
+      "replacement_succeeded",
+      (event: main.ReplacementSucceeded) => {
+        setLastOutcome({
+          text: `✔ Replaced ${event.target_file} with ${event.downloaded_file}`,
+          details: [
+            event.changes,
+            event.lint_issues > 0 && `${event.lint_issues} lint warnings`,
+            event.missing_sounds?.length &&
+              `${event.missing_sounds.length} sounds missing`,
+          ]
+            .filter(Boolean)
+            .join(" · "),
+          className: "text-green-400",
+        });
+      }
+    );
+    EventsOn("replacement_failed", (event: main.ReplacementFailed) => {
+      setLastOutcome({
+        text: `✘ Couldn't replace ${event.target_file || "filter"} with ${
+          event.downloaded_file
+        }`,
+        details: event.error,
+        className: "text-red-400",
+      });
+    });
+    EventsOn("download_ignored", (event: main.DownloadIgnored) => {
+      setLastOutcome({
+        text: `Ignored ${event.downloaded_file}`,
+        details: event.reason,
+        className: "text-slate-400",
+      });
+    });
+    return () => {
+      EventsOff("paused_state_changed");
+      EventsOff("status_changed");
+      EventsOff("config_changed");
+      EventsOff("download_quarantined");
+      EventsOff("replacement_succeeded");
+      EventsOff("replacement_failed");
+      EventsOff("download_ignored");
+    };
   }, []);
 
   useEffect(() => {
-    EventsOn("watch_event_triggered", () => {
-      refreshFiltersInDownloadsDir();
-      refreshFiltersInFiltersDir();
+    EventsOn("watch_event_triggered", (event: main.FileChangesEvent) => {
+      if (changesIn(event, "downloads")) {
+        refreshFiltersInDownloadsDir();
+      }
+      if (changesIn(event, "filters")) {
+        refreshFiltersInFiltersDir();
+      }
     });
     EventsOn("filter_file_replaced", () => {
       refreshFiltersInFiltersDir();
     });
+    EventsOn("backup_restored", () => {
+      refreshFiltersInFiltersDir();
+    });
+    EventsOn("directory_restored", (event: main.DirectoryRestored) => {
+      if (event.directory === "downloads") {
+        refreshFiltersInDownloadsDir();
+      } else {
+        refreshFiltersInFiltersDir();
+      }
+    });
     return () => {
       EventsOff("watch_event_triggered");
       EventsOff("filter_file_replaced");
+      EventsOff("backup_restored");
+      EventsOff("directory_restored");
     };
   }, [chosenFiltersDir, chosenDownloadsDir]);
 
@@ -118,7 +244,24 @@ const App = () => {
           </div>
           <div className="flex-1"></div>
           <div className="flex items-center gap-8">
-            <PreferencesPanel startInTrayInitialValue={startInTray} />
+            <button
+              className={`text-xl focus:outline-none ${
+                paused ? "text-orange-400" : "text-slate-500"
+              }`}
+              onClick={() => TogglePause().then(setPaused)}
+            >
+              {paused ? "▶ resume" : "⏸ pause"}
+            </button>
+            {configLoaded && <RulesEditor />}
+            {configLoaded && (
+              <PreferencesPanel
+                startInTrayInitialValue={startInTray}
+                rememberPausedInitialValue={rememberPaused}
+                strictValidationInitialValue={strictValidation}
+                allowDowngradeInitialValue={allowDowngrade}
+                copySoundsInitialValue={copySounds}
+              />
+            )}
           </div>
           <div className="flex-1"></div>
           <div
@@ -140,6 +283,51 @@ const App = () => {
             x
           </div>
         </div>
+        {status && <StatusBar status={status} />}
+        {configIssues.length > 0 && (
+          <div
+            className="-mt-4 truncate text-lg text-red-400"
+            title={configIssues.map(describeConfigIssue).join("\n")}
+          >
+            ⚙ {configIssues.length} problems with config.yaml:{" "}
+            {configIssues.map(describeConfigIssue).join(", ")}
+          </div>
+        )}
+        {missingAssets.some((asset) => !asset.optional) && (
+          <div
+            className="-mt-4 truncate text-lg text-orange-400"
+            title={missingAssets
+              .map((asset) => `${asset.path} (${asset.filter}:${asset.line})`)
+              .join("\n")}
+          >
+            🔇 {missingAssets.filter((asset) => !asset.optional).length} alert
+            sounds missing from the filters directory:{" "}
+            {missingAssets
+              .filter((asset) => !asset.optional)
+              .map((asset) => asset.path)
+              .join(", ")}
+          </div>
+        )}
+        {lastOutcome && (
+          <div
+            className={`-mt-4 truncate text-lg cursor-pointer ${lastOutcome.className}`}
+            title={lastOutcome.details}
+            onClick={() => setLastOutcome(undefined)}
+          >
+            {lastOutcome.text}
+            {lastOutcome.details && `: ${lastOutcome.details}`}
+          </div>
+        )}
+        {lastQuarantine && (
+          <div
+            className="-mt-4 truncate text-lg text-red-400 cursor-pointer"
+            title={lastQuarantine.reason}
+            onClick={() => setLastQuarantine(undefined)}
+          >
+            ⚠ Didn't install {lastQuarantine.downloaded_file}:{" "}
+            {lastQuarantine.reason}
+          </div>
+        )}
         <div className="grid grid-cols-1 grid-flow-col auto-cols-min gap-4">
           <div
             className={[
@@ -200,10 +388,15 @@ const App = () => {
                       }
                     },
                     inputMode: "text",
-                    textInputPrompt: "(Over)write only this filter file:",
+                    textInputPrompt:
+                      "(Over)write only this filter file ({base}, {strictness}, {league}, {date:2006-01-02}...):",
+                    textInputAllowsPlaceholders: true,
                   },
                 ]}
                 selectedMode={chosenFilterOverwriteStrategy}
+                renderSelectedEntryActions={(entry) => (
+                  <BackupsMenu targetFile={entry.name} />
+                )}
                 selectedEntryName={
                   chosenFilterOverwriteStrategy === "selected_file"
                     ? chosenFilterFile
@@ -308,7 +501,78 @@ const App = () => {
   );
 };
 
-const PreferencesPanel = (props: { startInTrayInitialValue: boolean }) => {
+const StatusBar = (props: { status: main.Status }) => {
+  const { status } = props;
+
+  const problems: string[] = [];
+  if (!status.filters_directory.set) {
+    problems.push("filters directory");
+  }
+  if (!status.downloads_directory.set) {
+    problems.push("downloads directory");
+  }
+
+  // directories that went away are watched again as soon as they're back, so there's nothing to set up
+  const missing: string[] = [];
+  if (status.filters_directory.set && !status.filters_directory.exists) {
+    missing.push("filters directory");
+  }
+  if (status.downloads_directory.set && !status.downloads_directory.exists) {
+    missing.push("downloads directory");
+  }
+  if (!status.rules_valid) {
+    problems.push("replacement rules");
+  }
+  if (status.rules === 0) {
+    if (!status.overwrite_strategy_valid || !status.target_file_set) {
+      problems.push("filter to replace");
+    }
+    if (!status.watch_strategy_valid) {
+      problems.push("download mode");
+    }
+  }
+
+  const state =
+    problems.length > 0
+      ? { text: "Needs setup: " + problems.join(", "), color: "text-red-400" }
+      : missing.length > 0
+      ? {
+          text: `Waiting for the ${missing.join(" and ")} to come back`,
+          color: "text-orange-400",
+        }
+      : status.paused
+      ? { text: "Paused", color: "text-orange-400" }
+      : !status.watching
+      ? { text: "Not watching", color: "text-slate-400" }
+      : status.pending_downloads > 0
+      ? {
+          text: `Watching (${status.pending_downloads} downloading)`,
+          color: "text-sky-400",
+        }
+      : { text: "Watching", color: "text-green-400" };
+
+  return (
+    <div className="-my-4 flex gap-4 text-lg">
+      <div className={state.color}>● {state.text}</div>
+      <div className="flex-1"></div>
+      {status.last_replacement && (
+        <div className="truncate text-slate-400 italic">
+          Last replaced{" "}
+          {status.last_replacement.target_path.split(/[\\/]/).pop()} at{" "}
+          {new Date(status.last_replacement.replaced_at).toLocaleString()}
+        </div>
+      )}
+    </div>
+  );
+};
+
+const PreferencesPanel = (props: {
+  startInTrayInitialValue: boolean;
+  rememberPausedInitialValue: boolean;
+  strictValidationInitialValue: boolean;
+  allowDowngradeInitialValue: boolean;
+  copySoundsInitialValue: boolean;
+}) => {
   return (
     <Popover className="relative">
       <Popover.Button className="text-slate-500 focus:outline-none flex gap-1 items-center">
@@ -316,7 +580,7 @@ const PreferencesPanel = (props: { startInTrayInitialValue: boolean }) => {
         <div className="text-xl mb-0.5">settings</div>
       </Popover.Button>
 
-      <Popover.Panel className="absolute z-10 mt-4 -translate-x-[26%] h-48 w-64">
+      <Popover.Panel className="absolute z-10 mt-4 -translate-x-[26%] h-96 w-64">
         <div className="grid grid-cols-1 place-items-center p-6 gap-4 rounded-xl bg-opacity-80 backdrop-blur-md shadow-xl bg-slate-700">
           <ToggleSwitch
             enabled={props.startInTrayInitialValue}
@@ -326,6 +590,38 @@ const PreferencesPanel = (props: { startInTrayInitialValue: boolean }) => {
               SetStartInTrayAndUpdateConfig(newValue);
             }}
           ></ToggleSwitch>
+          <ToggleSwitch
+            enabled={props.rememberPausedInitialValue}
+            label="Remember paused"
+            onChange={(newValue) => {
+              LogDebug("Updating remember paused option to: " + newValue);
+              SetRememberPausedAndUpdateConfig(newValue);
+            }}
+          ></ToggleSwitch>
+          <ToggleSwitch
+            enabled={props.strictValidationInitialValue}
+            label="Strict validation"
+            onChange={(newValue) => {
+              LogDebug("Updating strict validation option to: " + newValue);
+              SetStrictValidationAndUpdateConfig(newValue);
+            }}
+          ></ToggleSwitch>
+          <ToggleSwitch
+            enabled={props.allowDowngradeInitialValue}
+            label="Allow downgrades"
+            onChange={(newValue) => {
+              LogDebug("Updating allow downgrade option to: " + newValue);
+              SetAllowDowngradeAndUpdateConfig(newValue);
+            }}
+          ></ToggleSwitch>
+          <ToggleSwitch
+            enabled={props.copySoundsInitialValue}
+            label="Copy missing sounds"
+            onChange={(newValue) => {
+              LogDebug("Updating copy sounds option to: " + newValue);
+              SetCopySoundsFromDownloadsAndUpdateConfig(newValue);
+            }}
+          ></ToggleSwitch>
         </div>
       </Popover.Panel>
     </Popover>
@@ -0,0 +1,108 @@
+import RelativeTime from "@yaireo/relative-time";
+import { Popover } from "@headlessui/react";
+import { useState } from "react";
+import { ListBackups, RestoreBackup } from "../wailsjs/go/main/App";
+import { main } from "../wailsjs/go/models";
+import { LogDebug } from "../wailsjs/runtime";
+
+// e.g. "1.2 MB"
+const formatSize = (size: number) => {
+  if (size < 1024) {
+    return `${size} B`;
+  }
+
+  if (size < 1024 * 1024) {
+    return `${(size / 1024).toFixed(1)} KB`;
+  }
+
+  return `${(size / 1024 / 1024).toFixed(1)} MB`;
+};
+
+// lists the backups of a filter file, and puts one of them back in its place
+const BackupsMenu = (props: { targetFile: string }) => {
+  const [backups, setBackups] = useState<main.BackupEntry[]>();
+  const [error, setError] = useState("");
+  const [restoredID, setRestoredID] = useState("");
+
+  const load = () => {
+    ListBackups(props.targetFile)
+      .then((listed) => {
+        setBackups(listed || []);
+        setError("");
+      })
+      .catch((err) => setError(String(err)));
+  };
+
+  const restore = (backup: main.BackupEntry) => {
+    LogDebug(`Restoring backup ${backup.id} of ${props.targetFile}`);
+    RestoreBackup(props.targetFile, backup.id)
+      .then(() => {
+        setRestoredID(backup.id);
+        load();
+      })
+      .catch((err) => setError(String(err)));
+  };
+
+  const now = new Date();
+
+  return (
+    // the menu sits inside a file list entry, which mustn't get selected by clicks meant for the menu
+    <Popover className="inline" onClick={(event) => event.stopPropagation()}>
+      <Popover.Button
+        className="ml-1 text-slate-300 focus:outline-none"
+        title="Backups of this filter"
+        onClick={() => {
+          setRestoredID("");
+          load();
+        }}
+      >
+        ⟲
+      </Popover.Button>
+
+      <Popover.Panel className="fixed z-10 mt-2 w-[32rem]">
+        <div className="flex flex-col p-4 gap-2 max-h-80 overflow-y-auto rounded-xl bg-opacity-80 backdrop-blur-md shadow-xl bg-slate-700 not-italic">
+          <div className="text-lg text-slate-300">
+            Backups of {props.targetFile}, taken before it was replaced
+          </div>
+
+          {error && <div className="text-red-400">{error}</div>}
+
+          {backups && backups.length === 0 && (
+            <div className="text-slate-400">No backups yet.</div>
+          )}
+
+          {backups?.map((backup) => (
+            <div
+              key={backup.id}
+              className="flex gap-3 items-center text-slate-200"
+            >
+              <div className="flex-1 truncate" title={backup.created_at}>
+                {new RelativeTime({ options: { dayPeriod: "narrow" } }).from(
+                  new Date(backup.created_at),
+                  now
+                )}
+                <span className="ml-2 text-slate-400">
+                  {formatSize(backup.size)}
+                </span>
+              </div>
+              <button
+                className="px-3 rounded-md bg-slate-600 shadow-md"
+                onClick={() => restore(backup)}
+              >
+                restore
+              </button>
+            </div>
+          ))}
+
+          {restoredID && (
+            <div className="text-green-400">
+              Restored. What was there before is now the newest backup.
+            </div>
+          )}
+        </div>
+      </Popover.Panel>
+    </Popover>
+  );
+};
+
+export default BackupsMenu;
@@ -16,11 +16,15 @@ export interface FileEntryAndModeSelectorProps {
     render: string | (() => JSX.Element);
     inputMode: "entries" | "text" | "singleEntry";
     textInputPrompt?: string;
+    textInputAllowsPlaceholders?: boolean;
     onChosen: (choice: { entryName?: string; text?: string }) => void;
   }[];
   selectedMode?: string;
   selectedEntryName?: string;
   inputText?: string;
+
+  // extra controls shown next to the selected entry (like its backups)
+  renderSelectedEntryActions?: (entry: main.FileListEntry) => JSX.Element;
 }
 
 const FileEntryAndModeSelector = (props: FileEntryAndModeSelectorProps) => {
@@ -59,6 +63,30 @@ const FileEntryAndModeSelector = (props: FileEntryAndModeSelectorProps) => {
     return time;
   };
 
+  // e.g. "v8.10.3 · Strict · Default", from the filter's header (if it has one)
+  const getIdentityString = (entry: main.FileListEntry) => {
+    return [
+      entry.version && `v${entry.version}`,
+      entry.strictness,
+      entry.style,
+    ]
+      .filter(Boolean)
+      .join(" · ");
+  };
+
+  // e.g. "line 12: font size 50 isn't a number between 1 and 45", one per line
+  const getLintTooltip = (entry: main.FileListEntry) => {
+    const lines = (entry.lint || []).map(
+      (issue) => `line ${issue.line}: ${issue.message}`
+    );
+
+    if (entry.lint_issues > lines.length) {
+      lines.push(`...and ${entry.lint_issues - lines.length} more`);
+    }
+
+    return lines.join("\n");
+  };
+
   useEffect(() => {
     const interval = setInterval(() => {
       setNow(new Date());
@@ -157,7 +185,11 @@ const FileEntryAndModeSelector = (props: FileEntryAndModeSelectorProps) => {
                         <div className="flex w-full items-center justify-between">
                           <div
                             className={`flex items-center ${
-                              checked ? "w-5/6" : "w-full"
+                              !checked
+                                ? "w-full"
+                                : props.renderSelectedEntryActions
+                                ? "w-3/4"
+                                : "w-5/6"
                             }`}
                           >
                             <div className="flex truncate text-md">
@@ -168,18 +200,30 @@ const FileEntryAndModeSelector = (props: FileEntryAndModeSelectorProps) => {
                                 }`}
                               >
                                 <div className="truncate">
+                                  {entry.lint_issues > 0 && (
+                                    <span
+                                      className="mr-1 text-orange-400"
+                                      title={getLintTooltip(entry)}
+                                    >
+                                      ⚠ {entry.lint_issues}
+                                    </span>
+                                  )}
                                   {trimFilterExt(entry.name)}
                                   <span className="opacity-60">.filter</span>
                                 </div>
-                                <div className="text-sm opacity-80 italic">
+                                <div className="truncate text-sm opacity-80 italic">
                                   {getRelativeTimeString(entry)}
+                                  {getIdentityString(entry) &&
+                                    ` · ${getIdentityString(entry)}`}
                                 </div>
                               </RadioGroup.Description>
                             </div>
                           </div>
                           {checked && (
-                            <div className="shrink-0">
-                              <CheckIcon className="h-6 w-6" />
+                            <div className="shrink-0 flex items-center">
+                              {props.renderSelectedEntryActions &&
+                                props.renderSelectedEntryActions(entry)}
+                              <CheckIcon className="h-6 w-6 ml-1" />
                             </div>
                           )}
                         </div>
@@ -204,6 +248,20 @@ const FileEntryAndModeSelector = (props: FileEntryAndModeSelectorProps) => {
                 <div className="italic text-lg text-slate-400">
                   {getRelativeTimeString(newestEntry)}
                 </div>
+                {newestEntry.lint_issues > 0 && (
+                  <div
+                    className="text-lg text-orange-400"
+                    title={getLintTooltip(newestEntry)}
+                  >
+                    ⚠ {newestEntry.lint_issues} lint warning
+                    {newestEntry.lint_issues === 1 ? "" : "s"}
+                  </div>
+                )}
+                {getIdentityString(newestEntry) && (
+                  <div className="truncate w-full text-center text-slate-400">
+                    {getIdentityString(newestEntry)}
+                  </div>
+                )}
               </InfoMessage>
             ) : (
               <NoFilesMessage>No filter files downloaded yet.</NoFilesMessage>
@@ -212,6 +270,7 @@ const FileEntryAndModeSelector = (props: FileEntryAndModeSelectorProps) => {
             <div className="flex flex-1">
               <InputFilterNameBox
                 prompt={selectedMode.textInputPrompt}
+                allowPlaceholders={selectedMode.textInputAllowsPlaceholders}
                 value={inputText}
                 onChange={(newValue: string) =>
                   setInputText(newValue ? `${newValue}.filter` : "")
@@ -256,7 +315,12 @@ const InputFilterNameBox = (props: any) => {
       return;
     }
 
-    if (isValidFilename(inputText.trim())) {
+    // placeholders like {date:2006-01-02} are checked when saved, so only the rest of the name is checked here
+    const checkedText = props.allowPlaceholders
+      ? inputText.trim().replace(/\{[^{}]*\}/g, "x")
+      : inputText.trim();
+
+    if (isValidFilename(checkedText)) {
       setValidState("valid");
       return;
     }
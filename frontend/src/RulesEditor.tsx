@@ -0,0 +1,243 @@
+import { Popover } from "@headlessui/react";
+import { useState } from "react";
+import { GetRules, SetRulesAndUpdateConfig } from "../wailsjs/go/main/App";
+import { main } from "../wailsjs/go/models";
+import { LogDebug } from "../wailsjs/runtime";
+
+const matchTypes = ["exact", "glob", "regex", "metadata"];
+const overwriteStrategies = ["selected_file", "named_file"];
+const metadataFields: (keyof main.RuleMetadataMatch)[] = [
+  "source",
+  "strictness",
+  "style",
+  "league",
+];
+
+const newRule = (): main.ReplacementRule =>
+  main.ReplacementRule.createFrom({
+    name: "",
+    enabled: true,
+    match_type: "glob",
+    pattern: "*.filter",
+    metadata: { source: "", strictness: "", style: "", league: "" },
+    target: "",
+    overwrite_strategy: "selected_file",
+  });
+
+const RulesEditor = () => {
+  const [rules, setRules] = useState<main.ReplacementRule[]>([]);
+  const [error, setError] = useState("");
+  const [saved, setSaved] = useState(false);
+
+  const load = () => {
+    GetRules().then((configured) => {
+      setRules(configured || []);
+      setError("");
+      setSaved(false);
+    });
+  };
+
+  const update = (idx: number, changes: Partial<main.ReplacementRule>) => {
+    setRules(
+      rules.map((rule, ruleIdx) =>
+        ruleIdx === idx
+          ? main.ReplacementRule.createFrom({ ...rule, ...changes })
+          : rule
+      )
+    );
+    setSaved(false);
+  };
+
+  const move = (idx: number, offset: number) => {
+    const target = idx + offset;
+    if (target < 0 || target >= rules.length) {
+      return;
+    }
+
+    const reordered = [...rules];
+    [reordered[idx], reordered[target]] = [reordered[target], reordered[idx]];
+    setRules(reordered);
+    setSaved(false);
+  };
+
+  const remove = (idx: number) => {
+    setRules(rules.filter((_, ruleIdx) => ruleIdx !== idx));
+    setSaved(false);
+  };
+
+  const save = () => {
+    LogDebug(`Saving ${rules.length} replacement rules`);
+    SetRulesAndUpdateConfig(rules)
+      .then(() => {
+        setError("");
+        setSaved(true);
+      })
+      .catch((err) => setError(String(err)));
+  };
+
+  const inputClassName =
+    "px-2 py-1 rounded bg-slate-800 text-slate-200 focus:outline-none";
+
+  return (
+    <Popover className="relative">
+      <Popover.Button
+        className="text-slate-500 focus:outline-none flex gap-1 items-center"
+        onClick={load}
+      >
+        <div className="text-3xl">⇄</div>
+        <div className="text-xl mb-0.5">rules</div>
+      </Popover.Button>
+
+      <Popover.Panel className="absolute z-10 mt-4 -translate-x-[60%] w-[44rem]">
+        <div className="flex flex-col p-6 gap-4 max-h-[32rem] overflow-y-auto rounded-xl bg-opacity-80 backdrop-blur-md shadow-xl bg-slate-700">
+          <div className="text-lg text-slate-300">
+            Rules are tried from top to bottom, and the first one matching a
+            download decides which filter it replaces. Without any rules, the
+            filter chosen below is used.
+          </div>
+
+          {rules.map((rule, idx) => (
+            <div
+              key={idx}
+              className={[
+                "flex flex-col gap-2 p-3 rounded-lg border-2",
+                rule.enabled
+                  ? "border-slate-500"
+                  : "border-slate-600 opacity-60",
+              ].join(" ")}
+            >
+              <div className="flex gap-2 items-center">
+                <input
+                  type="checkbox"
+                  checked={rule.enabled}
+                  onChange={(event) =>
+                    update(idx, { enabled: event.currentTarget.checked })
+                  }
+                ></input>
+                <input
+                  value={rule.name}
+                  placeholder="Rule name"
+                  className={`${inputClassName} flex-1`}
+                  onChange={(event) =>
+                    update(idx, { name: event.currentTarget.value })
+                  }
+                ></input>
+                <button
+                  className="text-slate-400 focus:outline-none"
+                  onClick={() => move(idx, -1)}
+                >
+                  ▲
+                </button>
+                <button
+                  className="text-slate-400 focus:outline-none"
+                  onClick={() => move(idx, 1)}
+                >
+                  ▼
+                </button>
+                <button
+                  className="text-red-400 focus:outline-none"
+                  onClick={() => remove(idx)}
+                >
+                  ✕
+                </button>
+              </div>
+
+              <div className="flex gap-2 items-center">
+                <select
+                  value={rule.match_type}
+                  className={inputClassName}
+                  onChange={(event) =>
+                    update(idx, { match_type: event.currentTarget.value })
+                  }
+                >
+                  {matchTypes.map((matchType) => (
+                    <option key={matchType} value={matchType}>
+                      {matchType}
+                    </option>
+                  ))}
+                </select>
+                {rule.match_type === "metadata" ? (
+                  metadataFields.map((field) => (
+                    <input
+                      key={field}
+                      value={rule.metadata[field]}
+                      placeholder={field}
+                      className={`${inputClassName} w-28`}
+                      onChange={(event) =>
+                        update(idx, {
+                          metadata: main.RuleMetadataMatch.createFrom({
+                            ...rule.metadata,
+                            [field]: event.currentTarget.value,
+                          }),
+                        })
+                      }
+                    ></input>
+                  ))
+                ) : (
+                  <input
+                    value={rule.pattern}
+                    placeholder="Downloaded file name"
+                    className={`${inputClassName} flex-1`}
+                    onChange={(event) =>
+                      update(idx, { pattern: event.currentTarget.value })
+                    }
+                  ></input>
+                )}
+              </div>
+
+              <div className="flex gap-2 items-center">
+                <div className="text-slate-400">→</div>
+                <input
+                  value={rule.target}
+                  placeholder="Target filter file"
+                  className={`${inputClassName} flex-1`}
+                  onChange={(event) =>
+                    update(idx, { target: event.currentTarget.value })
+                  }
+                ></input>
+                <select
+                  value={rule.overwrite_strategy}
+                  className={inputClassName}
+                  onChange={(event) =>
+                    update(idx, {
+                      overwrite_strategy: event.currentTarget.value,
+                    })
+                  }
+                >
+                  {overwriteStrategies.map((strategy) => (
+                    <option key={strategy} value={strategy}>
+                      {strategy}
+                    </option>
+                  ))}
+                </select>
+              </div>
+            </div>
+          ))}
+
+          <div className="flex gap-4 items-center">
+            <button
+              className="text-xl text-slate-300 focus:outline-none"
+              onClick={() => {
+                setRules([...rules, newRule()]);
+                setSaved(false);
+              }}
+            >
+              + add rule
+            </button>
+            <div className="flex-1"></div>
+            {error && <div className="truncate text-red-400">{error}</div>}
+            {saved && <div className="text-green-400">Saved</div>}
+            <button
+              className="text-xl text-green-400 focus:outline-none"
+              onClick={save}
+            >
+              save
+            </button>
+          </div>
+        </div>
+      </Popover.Panel>
+    </Popover>
+  );
+};
+
+export default RulesEditor;
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// fileSystem is the small set of filesystem operations used when replacing files.
+// It exists so that failures halfway through a replacement can be simulated
+type fileSystem interface {
+	Open(name string) (readableFile, error)
+	CreateTemp(dir, pattern string) (writableFile, error)
+	Stat(name string) (os.FileInfo, error)
+	Chmod(name string, mode os.FileMode) error
+	Rename(oldPath, newPath string) error
+	Remove(name string) error
+	SyncDirectory(name string) error
+}
+
+type readableFile interface {
+	io.ReadCloser
+	Stat() (os.FileInfo, error)
+}
+
+type writableFile interface {
+	io.WriteCloser
+	Name() string
+	Sync() error
+}
+
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (readableFile, error) {
+	return os.Open(name)
+}
+
+func (osFileSystem) CreateTemp(dir, pattern string) (writableFile, error) {
+	return os.CreateTemp(dir, pattern)
+}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osFileSystem) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFileSystem) SyncDirectory(name string) error {
+	return syncDirectory(name)
+}
+
+// defaultFileSystem is what everything outside of tests should be using
+var defaultFileSystem fileSystem = osFileSystem{}
+
+const defaultFileMode = 0644
+
+func copyFileContents(src, dst string) error {
+	return copyFileContentsAtomic(defaultFileSystem, src, dst)
+}
+
+// copyFileContentsAtomic copies src over dst without ever exposing a partially written dst.
+// The contents are written into a temp file next to dst, flushed to disk and checked for size,
+// and only then renamed over dst. If anything fails before the rename, dst is left untouched
+func copyFileContentsAtomic(fs fileSystem, src, dst string) (err error) {
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	srcInfo, err := in.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat source file")
+	}
+
+	// keep the permissions of the file we're replacing, if there is one
+	mode := os.FileMode(defaultFileMode)
+	if dstInfo, statErr := fs.Stat(dst); statErr == nil {
+		mode = dstInfo.Mode().Perm()
+	}
+
+	// the temp file must be in the same directory, otherwise the rename isn't atomic (or possible)
+	tmp, err := fs.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+
+	tmpName := tmp.Name()
+	tmpClosed := false
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if !tmpClosed {
+			tmp.Close()
+		}
+
+		fs.Remove(tmpName)
+	}()
+
+	written, err := io.Copy(tmp, in)
+	if err != nil {
+		return errors.Wrap(err, "write temp file")
+	}
+
+	if written != srcInfo.Size() {
+		err = errors.Errorf("short write: copied %d out of %d bytes", written, srcInfo.Size())
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return errors.Wrap(err, "sync temp file")
+	}
+
+	tmpClosed = true
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "close temp file")
+	}
+
+	if err = fs.Chmod(tmpName, mode); err != nil {
+		return errors.Wrap(err, "set temp file permissions")
+	}
+
+	if err = fs.Rename(tmpName, dst); err != nil {
+		return errors.Wrap(err, "move temp file into place")
+	}
+
+	// the rename itself only survives a crash once the directory holding it is on disk too
+	if err = fs.SyncDirectory(filepath.Dir(dst)); err != nil {
+		return errors.Wrap(err, "sync directory after moving temp file into place")
+	}
+
+	return nil
+}
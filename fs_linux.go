@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// syncDirectory flushes a directory's entries to disk, so that a file just renamed into it stays renamed after a crash
+func syncDirectory(directory string) error {
+	dir, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	// some filesystems (like a few FUSE ones) can't sync directories at all, which leaves nothing better to do
+	if err := dir.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+var errInjected = errors.New("injected failure")
+
+// faultyFileSystem is the real filesystem, except for the failures it's told to inject
+type faultyFileSystem struct {
+	osFileSystem
+
+	shortWrite bool
+	writeErr   error
+	syncErr    error
+	chmodErr   error
+	renameErr  error
+	syncDirErr error
+
+	// corruptWrites are the contents whose writes come out wrong, without an error
+	corruptWrites []byte
+}
+
+func (fs faultyFileSystem) CreateTemp(dir, pattern string) (writableFile, error) {
+	file, err := fs.osFileSystem.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &faultyFile{writableFile: file, fs: fs}, nil
+}
+
+func (fs faultyFileSystem) Chmod(name string, mode os.FileMode) error {
+	if fs.chmodErr != nil {
+		return fs.chmodErr
+	}
+
+	return fs.osFileSystem.Chmod(name, mode)
+}
+
+func (fs faultyFileSystem) Rename(oldPath, newPath string) error {
+	if fs.renameErr != nil {
+		return fs.renameErr
+	}
+
+	return fs.osFileSystem.Rename(oldPath, newPath)
+}
+
+func (fs faultyFileSystem) SyncDirectory(name string) error {
+	if fs.syncDirErr != nil {
+		return fs.syncDirErr
+	}
+
+	return fs.osFileSystem.SyncDirectory(name)
+}
+
+type faultyFile struct {
+	writableFile
+	fs faultyFileSystem
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if f.fs.writeErr != nil {
+		return 0, f.fs.writeErr
+	}
+
+	// writes some of it, and says so (without an error) like a full disk sometimes does
+	if f.fs.shortWrite && len(p) > 1 {
+		return f.writableFile.Write(p[:len(p)/2])
+	}
+
+	// writes all of it, but not what it was given, like a failing disk
+	if len(f.fs.corruptWrites) > 0 && bytes.Contains(p, f.fs.corruptWrites) {
+		corrupted := append([]byte{}, p...)
+		corrupted[0] ^= 0xFF
+		return f.writableFile.Write(corrupted)
+	}
+
+	return f.writableFile.Write(p)
+}
+
+func (f *faultyFile) Sync() error {
+	if f.fs.syncErr != nil {
+		return f.fs.syncErr
+	}
+
+	return f.writableFile.Sync()
+}
+
+func TestCopyFileContentsAtomicFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		fs   faultyFileSystem
+	}{
+		{name: "short write", fs: faultyFileSystem{shortWrite: true}},
+		{name: "write error", fs: faultyFileSystem{writeErr: errInjected}},
+		{name: "sync error", fs: faultyFileSystem{syncErr: errInjected}},
+		{name: "chmod error", fs: faultyFileSystem{chmodErr: errInjected}},
+		{name: "rename error", fs: faultyFileSystem{renameErr: errInjected}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			src := filepath.Join(directory, "download.filter")
+			dst := filepath.Join(directory, "target.filter")
+
+			writeTestFile(t, src, "Show\n\tBaseType \"Mirror of Kalandra\"\n")
+			writeTestFile(t, dst, "Hide\n")
+
+			if err := copyFileContentsAtomic(test.fs, src, dst); err == nil {
+				t.Fatal("copy succeeded despite the injected failure")
+			}
+
+			assertFileContents(t, dst, "Hide\n")
+			assertNoTempFiles(t, directory)
+		})
+	}
+}
+
+func TestCopyFileContentsAtomic(t *testing.T) {
+	directory := t.TempDir()
+	src := filepath.Join(directory, "download.filter")
+	dst := filepath.Join(directory, "target.filter")
+
+	writeTestFile(t, src, "Show\n")
+
+	// a target that doesn't exist yet is created
+	if err := copyFileContentsAtomic(osFileSystem{}, src, dst); err != nil {
+		t.Fatalf("copy to a new file: %v", err)
+	}
+
+	assertFileContents(t, dst, "Show\n")
+
+	// an existing target is replaced, and keeps its permissions
+	if err := os.Chmod(dst, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, src, "Hide\n")
+	if err := copyFileContentsAtomic(osFileSystem{}, src, dst); err != nil {
+		t.Fatalf("copy over an existing file: %v", err)
+	}
+
+	assertFileContents(t, dst, "Hide\n")
+	assertNoTempFiles(t, directory)
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("target permissions weren't kept: %v", info.Mode())
+	}
+
+	// failing to sync the directory comes after the rename, so the file is in place but the caller still hears of it
+	if err := copyFileContentsAtomic(faultyFileSystem{syncDirErr: errInjected}, src, dst); !errors.Is(err, errInjected) {
+		t.Fatalf("got %v, want the directory sync failure", err)
+	}
+
+	assertNoTempFiles(t, directory)
+}
+
+func assertNoTempFiles(t *testing.T, directory string) {
+	t.Helper()
+
+	leftovers, err := filepath.Glob(filepath.Join(directory, ".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(leftovers) > 0 {
+		t.Fatalf("temp files left behind: %v", leftovers)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/omriharel/filtersnatch/filter"
+	"github.com/pkg/errors"
+)
+
+const (
+	historyDirAndName = "filtersnatch/history.jsonl"
+
+	// bump this whenever HistoryEntry changes in a way readers need to know about
+	historySchemaVersion = 1
+
+	historyMaxFileSize     = 1024 * 1024
+	historyMaxRotatedFiles = 3
+)
+
+type HistoryEntryKind string
+
+const (
+	HistoryDownloadDetected    HistoryEntryKind = "download_detected"
+	HistoryDownloadSkipped     HistoryEntryKind = "download_skipped"
+	HistoryDownloadQuarantined HistoryEntryKind = "download_quarantined"
+	HistoryReplacement         HistoryEntryKind = "replacement"
+	HistoryReplacementFailed   HistoryEntryKind = "replacement_failed"
+	HistoryPaused              HistoryEntryKind = "paused"
+	HistoryResumed             HistoryEntryKind = "resumed"
+)
+
+// HistoryEntry is a single line in the action history journal
+type HistoryEntry struct {
+	SchemaVersion int              `json:"schema_version"`
+	Time          string           `json:"time"`
+	Kind          HistoryEntryKind `json:"kind"`
+
+	DownloadedFile string `json:"downloaded_file,omitempty"`
+	Archive        string `json:"archive,omitempty"`
+	TargetFile     string `json:"target_file,omitempty"`
+	Rule           string `json:"rule,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	Error          string `json:"error,omitempty"`
+
+	Receipt    *ReplacementReceipt   `json:"receipt,omitempty"`
+	Validation []ValidationIssue     `json:"validation,omitempty"`
+	Diff       *filter.Diff          `json:"diff,omitempty"`
+	Overlay    *filter.OverlayReport `json:"overlay,omitempty"`
+	Sounds     []SoundAsset          `json:"sounds,omitempty"`
+	Lint       []filter.LintIssue    `json:"lint,omitempty"`
+}
+
+// HistoryFilter narrows down history queries. Empty fields match everything
+type HistoryFilter struct {
+	Kinds  []string `json:"kinds"`
+	Since  string   `json:"since"`
+	Search string   `json:"search"`
+}
+
+// History is an append-only JSON-lines journal of everything filtersnatch did (or decided not to do).
+// The journal is rotated once it grows past a size limit, keeping a few older files around
+type History struct {
+	path string
+
+	maxFileSize     int64
+	maxRotatedFiles int
+
+	lock sync.Mutex
+}
+
+func NewHistory() (*History, error) {
+	historyPath, err := xdg.StateFile(historyDirAndName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &History{
+		path:            historyPath,
+		maxFileSize:     historyMaxFileSize,
+		maxRotatedFiles: historyMaxRotatedFiles,
+	}, nil
+}
+
+// Append stamps the given entry with the current time and schema version, and writes it to the journal
+func (h *History) Append(entry HistoryEntry) error {
+	entry.SchemaVersion = historySchemaVersion
+	entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if err := h.rotateIfNeeded(); err != nil {
+		return errors.Wrap(err, "rotate history")
+	}
+
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, defaultFileMode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// Query returns up to limit entries matching the given filter, newest first, after skipping offset of them.
+// A non-positive limit means no limit
+func (h *History) Query(limit, offset int, historyFilter HistoryFilter) ([]HistoryEntry, error) {
+	var since time.Time
+	if historyFilter.Since != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, historyFilter.Since); err != nil {
+			return nil, errors.Wrap(err, "parse history filter start time")
+		}
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	results := make([]HistoryEntry, 0)
+	skipped := 0
+
+	// walk from the current journal towards the oldest rotated one
+	for idx := 0; idx <= h.maxRotatedFiles; idx++ {
+		entries, err := readHistoryFile(h.rotatedPath(idx))
+		if err != nil {
+			return nil, err
+		}
+
+		for entryIdx := len(entries) - 1; entryIdx >= 0; entryIdx-- {
+			entry := entries[entryIdx]
+			if !historyFilter.matches(entry, since) {
+				continue
+			}
+
+			if skipped < offset {
+				skipped++
+				continue
+			}
+
+			results = append(results, entry)
+			if limit > 0 && len(results) >= limit {
+				return results, nil
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func (f HistoryFilter) matches(entry HistoryEntry, since time.Time) bool {
+	if len(f.Kinds) > 0 {
+		kindMatches := false
+		for _, kind := range f.Kinds {
+			if kind == string(entry.Kind) {
+				kindMatches = true
+				break
+			}
+		}
+
+		if !kindMatches {
+			return false
+		}
+	}
+
+	if !since.IsZero() {
+		entryTime, err := time.Parse(time.RFC3339Nano, entry.Time)
+		if err != nil || entryTime.Before(since) {
+			return false
+		}
+	}
+
+	if f.Search != "" {
+		search := strings.ToLower(f.Search)
+		haystack := strings.ToLower(strings.Join([]string{entry.DownloadedFile, entry.TargetFile, entry.Reason, entry.Error}, "\n"))
+		if !strings.Contains(haystack, search) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h *History) rotateIfNeeded() error {
+	info, err := os.Stat(h.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if info.Size() < h.maxFileSize {
+		return nil
+	}
+
+	// drop the oldest journal, then shift every other one back by one
+	if err := os.Remove(h.rotatedPath(h.maxRotatedFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for idx := h.maxRotatedFiles - 1; idx >= 0; idx-- {
+		if err := os.Rename(h.rotatedPath(idx), h.rotatedPath(idx+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotatedPath returns the path of the journal rotated idx times (0 being the current one)
+func (h *History) rotatedPath(idx int) string {
+	if idx == 0 {
+		return h.path
+	}
+
+	extension := filepath.Ext(h.path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(h.path, extension), idx, extension)
+}
+
+func readHistoryFile(path string) ([]HistoryEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]HistoryEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var entry HistoryEntry
+
+		// a torn or corrupted line shouldn't hide the rest of the journal
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHistory(t *testing.T) *History {
+	t.Helper()
+
+	return &History{
+		path:            filepath.Join(t.TempDir(), "history.jsonl"),
+		maxFileSize:     historyMaxFileSize,
+		maxRotatedFiles: historyMaxRotatedFiles,
+	}
+}
+
+func historyDownloads(entries []HistoryEntry) []string {
+	downloads := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		downloads = append(downloads, entry.DownloadedFile)
+	}
+
+	return downloads
+}
+
+// appendLargeHistory appends count entries of about 200KB each, so that every 5 or so fill up a journal file
+func appendLargeHistory(t *testing.T, history *History, count int) {
+	t.Helper()
+
+	padding := strings.Repeat("x", 200*1024)
+	for idx := 0; idx < count; idx++ {
+		kind := HistoryReplacement
+		if idx%2 == 1 {
+			kind = HistoryDownloadSkipped
+		}
+
+		if err := history.Append(HistoryEntry{
+			Kind:           kind,
+			DownloadedFile: fmt.Sprintf("%03d.filter", idx),
+			Reason:         padding,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHistoryAppendStampsUTC(t *testing.T) {
+	history := newTestHistory(t)
+
+	if err := history.Append(HistoryEntry{Kind: HistoryPaused}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := history.Query(0, 0, HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].SchemaVersion != historySchemaVersion || !strings.HasSuffix(entries[0].Time, "Z") {
+		t.Fatalf("got %+v", entries)
+	}
+
+	if stamped, err := time.Parse(time.RFC3339Nano, entries[0].Time); err != nil || time.Since(stamped) > time.Minute {
+		t.Fatalf("got time %s, %v", entries[0].Time, err)
+	}
+}
+
+func TestHistoryRotation(t *testing.T) {
+	history := newTestHistory(t)
+	appendLargeHistory(t, history, 30)
+
+	for idx := 0; idx <= historyMaxRotatedFiles; idx++ {
+		info, err := os.Stat(history.rotatedPath(idx))
+		if err != nil {
+			t.Fatalf("journal %d: %v", idx, err)
+		}
+
+		// a journal is only rotated once it's past the limit, so it can go over by an entry
+		if info.Size() > historyMaxFileSize+250*1024 {
+			t.Errorf("journal %d is %d bytes", idx, info.Size())
+		}
+	}
+
+	if _, err := os.Stat(history.rotatedPath(historyMaxRotatedFiles + 1)); !os.IsNotExist(err) {
+		t.Fatalf("kept more than %d rotated journals: %v", historyMaxRotatedFiles, err)
+	}
+
+	// every full journal holds 6 entries (it's rotated once it reaches 1MB), and the current one whatever's left
+	entries, err := history.Query(0, 0, HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downloads := historyDownloads(entries)
+	if len(downloads) != 24 || downloads[0] != "029.filter" || downloads[len(downloads)-1] != "006.filter" {
+		t.Fatalf("got %v, want the newest 24 entries, newest first", downloads)
+	}
+
+	for idx := 1; idx < len(entries); idx++ {
+		if entries[idx].DownloadedFile >= entries[idx-1].DownloadedFile {
+			t.Fatalf("entries out of order: %v", downloads)
+		}
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	history := newTestHistory(t)
+	appendLargeHistory(t, history, 30)
+
+	tests := []struct {
+		name   string
+		limit  int
+		offset int
+		filter HistoryFilter
+		want   []string
+	}{
+		{name: "limit", limit: 3, want: []string{"029.filter", "028.filter", "027.filter"}},
+		{name: "offset across journals", limit: 4, offset: 4,
+			want: []string{"025.filter", "024.filter", "023.filter", "022.filter"}},
+		{name: "offset into the oldest journal", offset: 21, want: []string{"008.filter", "007.filter", "006.filter"}},
+		{name: "offset past the end", limit: 5, offset: 100, want: []string{}},
+		{name: "kind", limit: 3, offset: 2, filter: HistoryFilter{Kinds: []string{string(HistoryDownloadSkipped)}},
+			want: []string{"025.filter", "023.filter", "021.filter"}},
+		{name: "several kinds", limit: 2, filter: HistoryFilter{Kinds: []string{string(HistoryDownloadSkipped), string(HistoryReplacement)}},
+			want: []string{"029.filter", "028.filter"}},
+		{name: "search", filter: HistoryFilter{Search: "00"}, want: []string{"009.filter", "008.filter", "007.filter", "006.filter"}},
+		{name: "search and kind", filter: HistoryFilter{Search: "00", Kinds: []string{string(HistoryReplacement)}},
+			want: []string{"008.filter", "006.filter"}},
+		{name: "since", filter: HistoryFilter{Since: time.Now().Add(time.Hour).Format(time.RFC3339)}, want: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := history.Query(test.limit, test.offset, test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := historyDownloads(entries); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	if _, err := history.Query(0, 0, HistoryFilter{Since: "yesterday"}); err == nil {
+		t.Fatal("queried with a broken start time")
+	}
+}
+
+func TestHistoryQuerySkipsCorruptedLines(t *testing.T) {
+	history := newTestHistory(t)
+	writeTestFile(t, history.path, `{"kind":"paused","downloaded_file":"a"}`+"\n{\"kind\":\"res\n"+`{"kind":"resumed","downloaded_file":"b"}`+"\n")
+
+	entries, err := history.Query(0, 0, HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := historyDownloads(entries); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Fatalf("got %v", got)
+	}
+}
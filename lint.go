@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/omriharel/filtersnatch/filter"
+	"github.com/pkg/errors"
+)
+
+const (
+	// a filter that's full of lint issues doesn't need every one of them in each history entry
+	historyMaxLintIssues = 50
+
+	// file lists only show a summary, with the first few issues
+	fileListMaxLintIssues = 10
+)
+
+// lintFilterFile looks for likely mistakes in a filter file
+func lintFilterFile(path string) ([]filter.LintIssue, error) {
+	parsed, err := filter.ParseFile(path)
+	if parsed == nil {
+		return nil, errors.Wrap(err, "parse filter")
+	}
+
+	return filter.Lint(parsed), nil
+}
+
+// truncateLintIssues returns at most max of the given issues
+func truncateLintIssues(issues []filter.LintIssue, max int) []filter.LintIssue {
+	if len(issues) <= max {
+		return issues
+	}
+
+	return issues[:max]
+}
+
+// lintCache remembers lint results for filter files, so listing the same files over and over doesn't
+// re-lint every one of them. A file is linted again whenever its size or modification time changes
+type lintCache struct {
+	entries map[string]lintCacheEntry
+	lock    sync.Mutex
+}
+
+type lintCacheEntry struct {
+	modTime time.Time
+	size    int64
+	issues  []filter.LintIssue
+}
+
+func newLintCache() *lintCache {
+	return &lintCache{entries: make(map[string]lintCacheEntry)}
+}
+
+func (c *lintCache) lint(path string, info os.FileInfo) ([]filter.LintIssue, error) {
+	c.lock.Lock()
+	entry, ok := c.entries[path]
+	c.lock.Unlock()
+
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.issues, nil
+	}
+
+	issues, err := lintFilterFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.entries[path] = lintCacheEntry{modTime: info.ModTime(), size: info.Size(), issues: issues}
+	c.lock.Unlock()
+
+	return issues, nil
+}
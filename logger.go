@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// Logger is what everything outside of the UI logs through, so that it can run with or without a window
+type Logger interface {
+	Trace(message string)
+	Tracef(format string, args ...interface{})
+	Debug(message string)
+	Debugf(format string, args ...interface{})
+	Info(message string)
+	Infof(format string, args ...interface{})
+	Warning(message string)
+	Warningf(format string, args ...interface{})
+	Error(message string)
+	Errorf(format string, args ...interface{})
+}
+
+// EventEmitter lets whoever's listening (usually the frontend) know that something happened
+type EventEmitter interface {
+	Emit(eventName string, data ...interface{})
+}
+
+type LogLevel int
+
+const (
+	LogLevelTrace LogLevel = iota
+	LogLevelDebug
+	LogLevelInfo
+	LogLevelWarning
+	LogLevelError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogLevelTrace:   "TRACE",
+	LogLevelDebug:   "DEBUG",
+	LogLevelInfo:    "INFO",
+	LogLevelWarning: "WARN",
+	LogLevelError:   "ERROR",
+}
+
+func parseLogLevel(level string) (LogLevel, bool) {
+	switch strings.ToLower(level) {
+	case "trace":
+		return LogLevelTrace, true
+	case "debug":
+		return LogLevelDebug, true
+	case "info":
+		return LogLevelInfo, true
+	case "warning", "warn":
+		return LogLevelWarning, true
+	case "error":
+		return LogLevelError, true
+	}
+
+	return 0, false
+}
+
+// consoleLogger writes timestamped lines to a writer (usually stderr), dropping anything below its level
+type consoleLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+func newConsoleLogger(out io.Writer, level LogLevel) *consoleLogger {
+	return &consoleLogger{
+		logger: log.New(out, "", log.LstdFlags),
+		level:  level,
+	}
+}
+
+func (l *consoleLogger) log(level LogLevel, message string) {
+	if level < l.level {
+		return
+	}
+
+	l.logger.Printf("%-5s | %s", logLevelNames[level], message)
+}
+
+func (l *consoleLogger) Trace(message string) { l.log(LogLevelTrace, message) }
+func (l *consoleLogger) Tracef(format string, args ...interface{}) {
+	l.log(LogLevelTrace, fmt.Sprintf(format, args...))
+}
+func (l *consoleLogger) Debug(message string) { l.log(LogLevelDebug, message) }
+func (l *consoleLogger) Debugf(format string, args ...interface{}) {
+	l.log(LogLevelDebug, fmt.Sprintf(format, args...))
+}
+func (l *consoleLogger) Info(message string) { l.log(LogLevelInfo, message) }
+func (l *consoleLogger) Infof(format string, args ...interface{}) {
+	l.log(LogLevelInfo, fmt.Sprintf(format, args...))
+}
+func (l *consoleLogger) Warning(message string) { l.log(LogLevelWarning, message) }
+func (l *consoleLogger) Warningf(format string, args ...interface{}) {
+	l.log(LogLevelWarning, fmt.Sprintf(format, args...))
+}
+func (l *consoleLogger) Error(message string) { l.log(LogLevelError, message) }
+func (l *consoleLogger) Errorf(format string, args ...interface{}) {
+	l.log(LogLevelError, fmt.Sprintf(format, args...))
+}
+
+// logEmitter stands in for the frontend when there is none, by logging every event it's given
+type logEmitter struct {
+	log Logger
+}
+
+func (e logEmitter) Emit(eventName string, data ...interface{}) {
+	e.log.Tracef("Event: %s %+v", eventName, data)
+}
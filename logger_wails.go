@@ -0,0 +1,45 @@
+//go:build !headless
+
+package main
+
+import (
+	"context"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// wailsLogger sends everything to the Wails runtime logger
+type wailsLogger struct {
+	ctx context.Context
+}
+
+func (l wailsLogger) Trace(message string) { runtime.LogTrace(l.ctx, message) }
+func (l wailsLogger) Tracef(format string, args ...interface{}) {
+	runtime.LogTracef(l.ctx, format, args...)
+}
+func (l wailsLogger) Debug(message string) { runtime.LogDebug(l.ctx, message) }
+func (l wailsLogger) Debugf(format string, args ...interface{}) {
+	runtime.LogDebugf(l.ctx, format, args...)
+}
+func (l wailsLogger) Info(message string) { runtime.LogInfo(l.ctx, message) }
+func (l wailsLogger) Infof(format string, args ...interface{}) {
+	runtime.LogInfof(l.ctx, format, args...)
+}
+func (l wailsLogger) Warning(message string) { runtime.LogWarning(l.ctx, message) }
+func (l wailsLogger) Warningf(format string, args ...interface{}) {
+	runtime.LogWarningf(l.ctx, format, args...)
+}
+func (l wailsLogger) Error(message string) { runtime.LogError(l.ctx, message) }
+func (l wailsLogger) Errorf(format string, args ...interface{}) {
+	runtime.LogErrorf(l.ctx, format, args...)
+}
+
+// wailsEmitter emits events through the Wails runtime, reaching both the frontend and Go listeners.
+// Go code shouldn't rely on the latter though: the frontend's EventsOff removes Go listeners too (see Engine.OnStatusChanged)
+type wailsEmitter struct {
+	ctx context.Context
+}
+
+func (e wailsEmitter) Emit(eventName string, data ...interface{}) {
+	runtime.EventsEmit(e.ctx, eventName, data...)
+}
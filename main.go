@@ -1,37 +1,31 @@
+//go:build !headless
+
 package main
 
 import (
 	"embed"
-	"fmt"
+	"os"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
 	"github.com/wailsapp/wails/v2/pkg/options/windows"
 )
 
-// build tags will populate this
-var (
-	gitCommit  string
-	versionTag string
-	buildType  string
-)
-
 //go:embed frontend/dist
 var assets embed.FS
 
 func main() {
 
+	// Commands run headless, without a window or tray
+	if len(os.Args) > 1 && isCLICommand(os.Args[1]) {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
 	// Create an instance of the app structure
 	app := NewApp()
 
 	// If build tags are available, feed them to the app
-	if buildType != "" && (versionTag != "" || gitCommit != "") {
-		identifier := gitCommit
-		if versionTag != "" {
-			identifier = versionTag
-		}
-
-		versionString := fmt.Sprintf("Version %s-%s", buildType, identifier)
+	if versionString := buildVersionString(); versionString != "" {
 		app.setVersion(versionString)
 	}
 
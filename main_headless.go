@@ -0,0 +1,10 @@
+//go:build headless
+
+package main
+
+import "os"
+
+// Headless builds leave out the window and tray entirely, so they can run on machines without a desktop
+func main() {
+	os.Exit(runCLI(os.Args[1:]))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/omriharel/filtersnatch/filter"
+)
+
+// readFilterMetadata reads what a filter file's header says about it
+func readFilterMetadata(path string) (filter.Metadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return filter.Metadata{}, err
+	}
+	defer file.Close()
+
+	return filter.ReadMetadata(file)
+}
+
+// lazyFilterMetadata returns a function that reads a filter file's metadata the first time it's called,
+// and remembers it from then on
+func lazyFilterMetadata(path string) func() (filter.Metadata, error) {
+	var (
+		metadata filter.Metadata
+		err      error
+		read     bool
+	)
+
+	return func() (filter.Metadata, error) {
+		if !read {
+			metadata, err = readFilterMetadata(path)
+			read = true
+		}
+
+		return metadata, err
+	}
+}
+
+// describeDowngrade tells whether installing incoming over installed would go back to an older version
+// of the same filter, and if so, describes it. Filters from different sources are never compared
+func describeDowngrade(installed, incoming filter.Metadata) (string, bool) {
+	if !strings.EqualFold(installed.Source, incoming.Source) {
+		return "", false
+	}
+
+	comparison, ok := filter.CompareVersions(incoming.Version, installed.Version)
+	if !ok || comparison >= 0 {
+		return "", false
+	}
+
+	return fmt.Sprintf("would downgrade from version %s to %s", installed.Version, incoming.Version), true
+}
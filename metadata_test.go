@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/omriharel/filtersnatch/filter"
+)
+
+func TestDescribeDowngrade(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed filter.Metadata
+		incoming  filter.Metadata
+		want      string // empty if it isn't a downgrade
+	}{
+		{name: "upgrade", installed: filter.Metadata{Source: "NeverSink", Version: "8.9.1"},
+			incoming: filter.Metadata{Source: "NeverSink", Version: "8.10.0"}},
+		{name: "same version", installed: filter.Metadata{Source: "NeverSink", Version: "8.10.3"},
+			incoming: filter.Metadata{Source: "NeverSink", Version: "8.10.3"}},
+		{name: "downgrade", installed: filter.Metadata{Source: "NeverSink", Version: "8.10.0"},
+			incoming: filter.Metadata{Source: "neversink", Version: "8.9.1"},
+			want:     "would downgrade from version 8.10.0 to 8.9.1"},
+		{name: "different sources", installed: filter.Metadata{Source: "FilterBlade", Version: "8.10.0"},
+			incoming: filter.Metadata{Source: "NeverSink", Version: "8.9.1"}},
+		{name: "no installed version", installed: filter.Metadata{Source: "NeverSink"},
+			incoming: filter.Metadata{Source: "NeverSink", Version: "8.9.1"}},
+		{name: "no incoming version", installed: filter.Metadata{Source: "NeverSink", Version: "8.10.0"},
+			incoming: filter.Metadata{Source: "NeverSink"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason, downgrade := describeDowngrade(test.installed, test.incoming)
+			if reason != test.want || downgrade != (test.want != "") {
+				t.Fatalf("got %q, %v; want %q", reason, downgrade, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckDowngrade(t *testing.T) {
+	filtersDirectory, downloadsDirectory := t.TempDir(), t.TempDir()
+	watcher := newTestWatcher(t, newFakeEventSource(), nopEmitter{}, filtersDirectory, downloadsDirectory)
+
+	writeTestFile(t, filepath.Join(filtersDirectory, "installed.filter"), "# VERSION: 8.10.0\n# AUTHOR: NeverSink\nShow\n")
+
+	older := filepath.Join(downloadsDirectory, "older.filter")
+	writeTestFile(t, older, "# VERSION: 8.9.1\n# AUTHOR: NeverSink\nShow\n")
+
+	newer := filepath.Join(downloadsDirectory, "newer.filter")
+	writeTestFile(t, newer, "# VERSION: 8.10.1\n# AUTHOR: NeverSink\nShow\n")
+
+	if _, downgrade := watcher.checkDowngrade(older, "installed.filter"); !downgrade {
+		t.Error("allowed replacing 8.10.0 with 8.9.1")
+	}
+
+	if reason, downgrade := watcher.checkDowngrade(newer, "installed.filter"); downgrade {
+		t.Errorf("refused an upgrade: %s", reason)
+	}
+
+	if reason, downgrade := watcher.checkDowngrade(older, "missing.filter"); downgrade {
+		t.Errorf("refused replacing a filter that isn't there: %s", reason)
+	}
+
+	watcher.engine.config.Set(configKeyFiltersAllowDowngrade, true)
+	if reason, downgrade := watcher.checkDowngrade(older, "installed.filter"); downgrade {
+		t.Errorf("refused a downgrade that's allowed: %s", reason)
+	}
+}
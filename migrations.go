@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// configMigration upgrades the config from one version to the next. It gets every setting in the config
+// file as viper reads it: nested by section (like settings["downloads"]["watch_strategy"]), with lowercase keys
+type configMigration struct {
+	description string
+	migrate     func(settings map[string]interface{}) error
+}
+
+// configMigrations upgrade configs one version at a time: configMigrations[n] takes a config from version n
+// to version n+1. Configs from before versions existed are version 0. New migrations only ever go at the end
+var configMigrations = []configMigration{
+	{
+		description: "rename the any_filter_file download watch mode to newest_filter_file",
+		migrate: func(settings map[string]interface{}) error {
+			downloads, ok := settings["downloads"].(map[string]interface{})
+			if !ok {
+				return nil
+			}
+
+			if downloads["watch_strategy"] == "any_filter_file" {
+				downloads["watch_strategy"] = string(WatchNewestFilterFile)
+			}
+
+			return nil
+		},
+	},
+}
+
+// currentConfigVersion is the version of configs this build reads and writes
+var currentConfigVersion = len(configMigrations)
+
+// migrateConfigFile upgrades the config file at configPath to the current version, if it's older. The original
+// is backed up next to it first, and the upgraded config only replaces it once every migration succeeded
+func migrateConfigFile(configPath string, log Logger) error {
+	if !fileExists(configPath) {
+		return nil
+	}
+
+	raw := viper.New()
+	raw.SetConfigFile(configPath)
+	raw.SetConfigType("yaml")
+
+	if err := raw.ReadInConfig(); err != nil {
+		return errors.Wrap(err, "read config")
+	}
+
+	version := raw.GetInt(configKeyVersion)
+
+	if version > currentConfigVersion {
+		return errors.Errorf("config version %d is newer than this version of filtersnatch supports (%d)",
+			version, currentConfigVersion)
+	}
+
+	if version == currentConfigVersion {
+		return nil
+	}
+
+	log.Infof("Upgrading config from version %d to %d", version, currentConfigVersion)
+
+	settings := raw.AllSettings()
+	for from := version; from < currentConfigVersion; from++ {
+		migration := configMigrations[from]
+
+		log.Debugf("Upgrading config to version %d: %s", from+1, migration.description)
+		if err := migration.migrate(settings); err != nil {
+			return errors.Wrapf(err, "upgrade config to version %d (%s)", from+1, migration.description)
+		}
+	}
+
+	settings[configKeyVersion] = currentConfigVersion
+
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", configPath, version, time.Now().UTC().Format(backupTimeFormat))
+	if err := copyFileContents(configPath, backupPath); err != nil {
+		return errors.Wrap(err, "back up config")
+	}
+
+	log.Infof("Backed up version %d config to %s", version, backupPath)
+
+	upgraded := viper.New()
+	if err := upgraded.MergeConfigMap(settings); err != nil {
+		return errors.Wrap(err, "prepare upgraded config")
+	}
+
+	// written next to the config and renamed over it, so a failed write can't leave a half written config behind
+	upgradedPath := filepath.Join(filepath.Dir(configPath), ".config.upgrading.yaml")
+	if err := upgraded.WriteConfigAs(upgradedPath); err != nil {
+		os.Remove(upgradedPath)
+		return errors.Wrap(err, "write upgraded config")
+	}
+
+	if err := os.Rename(upgradedPath, configPath); err != nil {
+		os.Remove(upgradedPath)
+		return errors.Wrap(err, "replace config with upgraded one")
+	}
+
+	log.Infof("Upgraded config to version %d", currentConfigVersion)
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// useTestMigrations swaps in the given migrations for the duration of the test
+func useTestMigrations(t *testing.T, migrations []configMigration) {
+	t.Helper()
+
+	previousMigrations, previousVersion := configMigrations, currentConfigVersion
+	t.Cleanup(func() {
+		configMigrations, currentConfigVersion = previousMigrations, previousVersion
+	})
+
+	configMigrations, currentConfigVersion = migrations, len(migrations)
+}
+
+func writeTestConfig(t *testing.T, contents string) string {
+	t.Helper()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestFile(t, configPath, contents)
+
+	return configPath
+}
+
+func configBackups(t *testing.T, configPath string) []string {
+	t.Helper()
+
+	backups, err := filepath.Glob(configPath + ".v*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return backups
+}
+
+func TestMigrateConfigFileFromVersion0(t *testing.T) {
+	original := "downloads:\n  watch_strategy: any_filter_file\nfilters:\n  selected_file: installed.filter\n"
+	configPath := writeTestConfig(t, original)
+
+	if err := migrateConfigFile(configPath, newConsoleLogger(io.Discard, LogLevelError)); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated := readTestConfigFile(t, configPath)
+	if version := migrated.GetInt(configKeyVersion); version != currentConfigVersion {
+		t.Errorf("got version %d, want %d", version, currentConfigVersion)
+	}
+
+	if strategy := migrated.GetString(configKeyDownloadsWatchStrategy); strategy != string(WatchNewestFilterFile) {
+		t.Errorf("got watch strategy %s, want %s", strategy, WatchNewestFilterFile)
+	}
+
+	if selected := migrated.GetString(configKeyFiltersSelectedFile); selected != "installed.filter" {
+		t.Errorf("got selected file %s, want the one it had", selected)
+	}
+
+	backups := configBackups(t, configPath)
+	if len(backups) != 1 || !strings.HasPrefix(filepath.Base(backups[0]), "config.yaml.v0-") {
+		t.Fatalf("got backups %v, want one of the version 0 config", backups)
+	}
+
+	assertFileContents(t, backups[0], original)
+	if fileExists(filepath.Join(filepath.Dir(configPath), ".config.upgrading.yaml")) {
+		t.Fatal("upgraded config left behind")
+	}
+}
+
+func TestMigrateConfigFileStepByStep(t *testing.T) {
+	steps := make([]string, 0)
+	step := func(name string) configMigration {
+		return configMigration{
+			description: name,
+			migrate: func(settings map[string]interface{}) error {
+				steps = append(steps, name)
+				settings["steps"] = strings.Join(steps, ",")
+				return nil
+			},
+		}
+	}
+
+	useTestMigrations(t, []configMigration{step("first"), step("second"), step("third")})
+
+	tests := []struct {
+		name      string
+		contents  string
+		wantSteps string
+	}{
+		{name: "from version 0", contents: "steps: none\n", wantSteps: "first,second,third"},
+		{name: "from version 1", contents: "version: 1\nsteps: none\n", wantSteps: "second,third"},
+		{name: "from version 2", contents: "version: 2\nsteps: none\n", wantSteps: "third"},
+		{name: "already current", contents: "version: 3\nsteps: none\n", wantSteps: "none"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			steps = steps[:0]
+			configPath := writeTestConfig(t, test.contents)
+
+			if err := migrateConfigFile(configPath, newConsoleLogger(io.Discard, LogLevelError)); err != nil {
+				t.Fatal(err)
+			}
+
+			migrated := readTestConfigFile(t, configPath)
+			if got := migrated.GetString("steps"); got != test.wantSteps {
+				t.Errorf("got steps %s, want %s", got, test.wantSteps)
+			}
+
+			if version := migrated.GetInt(configKeyVersion); version != 3 {
+				t.Errorf("got version %d, want 3", version)
+			}
+
+			// a config that's already current is left alone
+			if backups := configBackups(t, configPath); (len(backups) == 0) != (test.wantSteps == "none") {
+				t.Errorf("got backups %v", backups)
+			}
+		})
+	}
+}
+
+func TestMigrateConfigFileFailedStepKeepsOriginal(t *testing.T) {
+	useTestMigrations(t, []configMigration{
+		{description: "works", migrate: func(settings map[string]interface{}) error { return nil }},
+		{description: "breaks", migrate: func(settings map[string]interface{}) error { return errInjected }},
+	})
+
+	original := "filters:\n  selected_file: installed.filter\n"
+	configPath := writeTestConfig(t, original)
+
+	if err := migrateConfigFile(configPath, newConsoleLogger(io.Discard, LogLevelError)); !errors.Is(err, errInjected) {
+		t.Fatalf("got %v, want the failed step's error", err)
+	}
+
+	assertFileContents(t, configPath, original)
+	if fileExists(filepath.Join(filepath.Dir(configPath), ".config.upgrading.yaml")) {
+		t.Fatal("upgraded config left behind")
+	}
+}
+
+func TestMigrateConfigFileRefusesNewerVersions(t *testing.T) {
+	original := "version: 99\nfilters:\n  selected_file: installed.filter\n"
+	configPath := writeTestConfig(t, original)
+
+	if err := migrateConfigFile(configPath, newConsoleLogger(io.Discard, LogLevelError)); err == nil {
+		t.Fatal("upgraded a config from a newer version")
+	}
+
+	assertFileContents(t, configPath, original)
+
+	if backups := configBackups(t, configPath); len(backups) != 0 {
+		t.Fatalf("got backups %v, want none", backups)
+	}
+}
+
+func TestMigrateConfigFileWithoutConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := migrateConfigFile(configPath, newConsoleLogger(io.Discard, LogLevelError)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want no config created", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	directory := t.TempDir()
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		wantKeys []string
+	}{
+		{name: "defaults"},
+		{name: "newer version", settings: map[string]interface{}{configKeyVersion: currentConfigVersion + 1},
+			wantKeys: []string{configKeyVersion}},
+		{name: "older version", settings: map[string]interface{}{configKeyVersion: 0},
+			wantKeys: []string{configKeyVersion}},
+		{name: "unknown modes", settings: map[string]interface{}{
+			configKeyFiltersOverwriteStrategy: "somewhere",
+			configKeyDownloadsWatchStrategy:   "everything",
+			configKeyValidationMode:           "paranoid",
+			configKeyWatcherEventSource:       "telepathy",
+		}, wantKeys: []string{configKeyFiltersOverwriteStrategy, configKeyDownloadsWatchStrategy,
+			configKeyValidationMode, configKeyWatcherEventSource}},
+		{name: "missing directories", settings: map[string]interface{}{
+			configKeyFiltersDirectory:   filepath.Join(directory, "filters"),
+			configKeyDownloadsDirectory: filepath.Join(directory, "downloads"),
+		}, wantKeys: []string{configKeyFiltersDirectory, configKeyDownloadsDirectory}},
+		{name: "broken rule", settings: map[string]interface{}{configKeyRules: []ReplacementRule{
+			{Enabled: true, MatchType: RuleMatchRegex, Pattern: "(", Target: "installed.filter", OverwriteStrategy: string(OverwriteSelectedFile)},
+		}}, wantKeys: []string{configKeyRules}},
+		{name: "unreadable rules", settings: map[string]interface{}{configKeyRules: "all of them"},
+			wantKeys: []string{configKeyRules}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := newDefaultConfig()
+			config.Set(configKeyVersion, currentConfigVersion)
+			config.Set(configKeyFiltersDirectory, directory)
+			config.Set(configKeyDownloadsDirectory, directory)
+
+			for key, value := range test.settings {
+				config.Set(key, value)
+			}
+
+			keys := make([]string, 0)
+			for _, issue := range validateConfig(config) {
+				keys = append(keys, issue.Key)
+
+				if issue.Message == "" {
+					t.Errorf("issue with %s has no message", issue.Key)
+				}
+			}
+
+			want := test.wantKeys
+			if want == nil {
+				want = []string{}
+			}
+
+			if strings.Join(keys, " ") != strings.Join(want, " ") {
+				t.Fatalf("got issues with %v, want %v", keys, want)
+			}
+		})
+	}
+}
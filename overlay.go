@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/omriharel/filtersnatch/filter"
+	"github.com/pkg/errors"
+)
+
+const (
+	overlaysDirectoryName = ".filtersnatch-overlays"
+	overlayFileExtension  = ".overlay"
+)
+
+// overlayPathFor returns where the overlay of the given target file lives. Overlays are kept in a hidden
+// directory inside the filters directory (like backups are), named after their target without its extension
+func overlayPathFor(filtersDirectory, targetFile string) string {
+	name := strings.TrimSuffix(targetFile, filepath.Ext(targetFile)) + overlayFileExtension
+	return filepath.Join(filtersDirectory, overlaysDirectoryName, name)
+}
+
+// mergeOverlay applies the overlay at overlayPath to the downloaded filter, and writes the result to a
+// temporary file for the caller to install and then remove. If there's no overlay, there's nothing to merge,
+// and the download itself is returned with a nil report
+func mergeOverlay(downloadPath, overlayPath string) (string, *filter.OverlayReport, error) {
+	overlayContents, err := os.ReadFile(overlayPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return downloadPath, nil, nil
+		}
+
+		return "", nil, errors.Wrap(err, "read overlay")
+	}
+
+	overlay, err := filter.ParseOverlay(overlayContents)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "parse overlay %s", filepath.Base(overlayPath))
+	}
+
+	// the download was already validated, so whatever syntax errors it has are ones we've decided to live with
+	downloaded, _ := filter.ParseFile(downloadPath)
+	if downloaded == nil {
+		return "", nil, errors.New("read downloaded filter")
+	}
+
+	report := overlay.Apply(downloaded)
+
+	merged, err := os.CreateTemp("", "filtersnatch-merged-*.filter")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create merged filter file")
+	}
+	defer merged.Close()
+
+	if _, err := downloaded.WriteTo(merged); err != nil {
+		os.Remove(merged.Name())
+		return "", nil, errors.Wrap(err, "write merged filter file")
+	}
+
+	if err := merged.Close(); err != nil {
+		os.Remove(merged.Name())
+		return "", nil, errors.Wrap(err, "write merged filter file")
+	}
+
+	return merged.Name(), report, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/pkg/errors"
+)
+
+const receiptsDirAndName = "filtersnatch/receipts.json"
+
+// ReplacementReceipt describes a single verified filter replacement, i.e. what's installed right now
+type ReplacementReceipt struct {
+	SourcePath string `json:"source_path"`
+	TargetPath string `json:"target_path"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+
+	// ReplacedAt is an RFC3339 timestamp in UTC
+	ReplacedAt string `json:"replaced_at"`
+}
+
+// replacedTime parses ReplacedAt. Receipts with a broken timestamp count as the oldest
+func (r ReplacementReceipt) replacedTime() time.Time {
+	replacedAt, err := time.Parse(time.RFC3339Nano, r.ReplacedAt)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return replacedAt
+}
+
+// ReceiptStore keeps the latest receipt of every target file, persisted as JSON under the XDG data dir
+type ReceiptStore struct {
+	path string
+
+	lock     sync.Mutex
+	receipts map[string]ReplacementReceipt
+}
+
+func NewReceiptStore() (*ReceiptStore, error) {
+	receiptsPath, err := xdg.DataFile(receiptsDirAndName)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &ReceiptStore{
+		path:     receiptsPath,
+		receipts: make(map[string]ReplacementReceipt),
+	}
+
+	contents, err := os.ReadFile(receiptsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(contents, &store.receipts); err != nil {
+		return nil, errors.Wrap(err, "parse receipts file")
+	}
+
+	return store, nil
+}
+
+// Record saves the given receipt as the latest one for its target, replacing any previous receipt
+func (s *ReceiptStore) Record(receipt ReplacementReceipt) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.receipts[receiptKey(receipt.TargetPath)] = receipt
+
+	contents, err := json.MarshalIndent(s.receipts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, contents, defaultFileMode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, s.path)
+}
+
+// Get returns the latest receipt for the given target path, if there is one
+func (s *ReceiptStore) Get(targetPath string) (ReplacementReceipt, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	receipt, ok := s.receipts[receiptKey(targetPath)]
+	return receipt, ok
+}
+
+// All returns the latest receipt of every target, most recent replacement first
+func (s *ReceiptStore) All() []ReplacementReceipt {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	receipts := make([]ReplacementReceipt, 0, len(s.receipts))
+	for _, receipt := range s.receipts {
+		receipts = append(receipts, receipt)
+	}
+
+	// timestamps are compared as times, since zero fractional seconds are left out of them and they don't sort as strings
+	sort.Slice(receipts, func(i, j int) bool {
+		return receipts[j].replacedTime().Before(receipts[i].replacedTime())
+	})
+
+	return receipts
+}
+
+// target paths are compared case-insensitively, just like file names everywhere else
+func receiptKey(targetPath string) string {
+	return strings.ToLower(filepath.Clean(targetPath))
+}
+
+// hashFile returns the hex-encoded SHA-256 digest of a file, along with its size
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+func newReplacementReceipt(sourcePath, targetPath string, size int64, digest string) ReplacementReceipt {
+	return ReplacementReceipt{
+		SourcePath: sourcePath,
+		TargetPath: targetPath,
+		Size:       size,
+		SHA256:     digest,
+		ReplacedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestReceiptStoreAllOrder(t *testing.T) {
+	store := &ReceiptStore{receipts: map[string]ReplacementReceipt{}}
+
+	// fractional seconds are left out when they're zero, so compared as strings the newest would come second
+	for _, receipt := range []ReplacementReceipt{
+		{TargetPath: "oldest.filter", ReplacedAt: "2026-10-24T21:59:59.25Z"},
+		{TargetPath: "middle.filter", ReplacedAt: "2026-10-24T22:00:00Z"},
+		{TargetPath: "newest.filter", ReplacedAt: "2026-10-24T22:00:00.5Z"},
+		{TargetPath: "broken.filter", ReplacedAt: "yesterday"},
+	} {
+		store.receipts[receiptKey(receipt.TargetPath)] = receipt
+	}
+
+	want := []string{"newest.filter", "middle.filter", "oldest.filter", "broken.filter"}
+
+	got := store.All()
+	for idx, receipt := range got {
+		if receipt.TargetPath != want[idx] {
+			t.Fatalf("receipt %d is %s, want %s (got %v)", idx, receipt.TargetPath, want[idx], got)
+		}
+	}
+}
+
+func TestNewReplacementReceiptIsUTC(t *testing.T) {
+	receipt := newReplacementReceipt("download.filter", "target.filter", 1, "")
+
+	replacedAt := receipt.replacedTime()
+	if replacedAt.IsZero() || replacedAt.Location().String() != "UTC" {
+		t.Fatalf("ReplacedAt %q isn't an RFC3339 time in UTC", receipt.ReplacedAt)
+	}
+}
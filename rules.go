@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/omriharel/filtersnatch/filter"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+type RuleMatchType string
+
+const (
+	RuleMatchExact    RuleMatchType = "exact"
+	RuleMatchGlob     RuleMatchType = "glob"
+	RuleMatchRegex    RuleMatchType = "regex"
+	RuleMatchMetadata RuleMatchType = "metadata"
+)
+
+func parseRuleMatchType(matchType string) (RuleMatchType, bool) {
+	switch matchType {
+	case string(RuleMatchExact):
+		return RuleMatchExact, true
+	case string(RuleMatchGlob):
+		return RuleMatchGlob, true
+	case string(RuleMatchRegex):
+		return RuleMatchRegex, true
+	case string(RuleMatchMetadata):
+		return RuleMatchMetadata, true
+	}
+
+	return "", false
+}
+
+// ReplacementRule decides which filter file a download replaces. Rules are tried in order,
+// and the first one matching a download wins
+type ReplacementRule struct {
+	Name    string `json:"name" mapstructure:"name" yaml:"name"`
+	Enabled bool   `json:"enabled" mapstructure:"enabled" yaml:"enabled"`
+
+	MatchType RuleMatchType `json:"match_type" mapstructure:"match_type" yaml:"match_type"`
+
+	// Pattern is the download name (exact, case-insensitively), a glob or a regular expression
+	Pattern string `json:"pattern" mapstructure:"pattern" yaml:"pattern"`
+
+	// Metadata is matched against the download's header. Empty fields match anything
+	Metadata RuleMetadataMatch `json:"metadata" mapstructure:"metadata" yaml:"metadata"`
+
+	Target            string `json:"target" mapstructure:"target" yaml:"target"`
+	OverwriteStrategy string `json:"overwrite_strategy" mapstructure:"overwrite_strategy" yaml:"overwrite_strategy"`
+}
+
+type RuleMetadataMatch struct {
+	Source     string `json:"source" mapstructure:"source" yaml:"source"`
+	Strictness string `json:"strictness" mapstructure:"strictness" yaml:"strictness"`
+	Style      string `json:"style" mapstructure:"style" yaml:"style"`
+	League     string `json:"league" mapstructure:"league" yaml:"league"`
+}
+
+func (m RuleMetadataMatch) empty() bool {
+	return m.Source == "" && m.Strictness == "" && m.Style == "" && m.League == ""
+}
+
+func (m RuleMetadataMatch) matches(metadata filter.Metadata) bool {
+	for _, field := range [][2]string{
+		{m.Source, metadata.Source},
+		{m.Strictness, metadata.Strictness},
+		{m.Style, metadata.Style},
+		{m.League, metadata.League},
+	} {
+		if field[0] != "" && !strings.EqualFold(field[0], field[1]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m RuleMetadataMatch) String() string {
+	fields := make([]string, 0)
+	for _, field := range [][2]string{
+		{"source", m.Source},
+		{"strictness", m.Strictness},
+		{"style", m.Style},
+		{"league", m.League},
+	} {
+		if field[1] != "" {
+			fields = append(fields, fmt.Sprintf("%s=%s", field[0], field[1]))
+		}
+	}
+
+	return strings.Join(fields, ",")
+}
+
+// displayName is how the rule is referred to in logs and history
+func (r ReplacementRule) displayName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	return r.Target
+}
+
+// validate makes sure the rule can be used as it is
+func (r ReplacementRule) validate() error {
+	if _, ok := parseOverwriteStrategy(r.OverwriteStrategy); !ok {
+		return errors.Errorf("unknown overwrite strategy: %s", r.OverwriteStrategy)
+	}
+
+	validateTarget := validateFilterFileName
+	if r.OverwriteStrategy == string(OverwriteNamedFile) {
+		validateTarget = validateTargetTemplate
+	}
+
+	if err := validateTarget(r.Target); err != nil {
+		return errors.Wrap(err, "invalid target")
+	}
+
+	matchType, ok := parseRuleMatchType(string(r.MatchType))
+	if !ok {
+		return errors.Errorf("unknown match type: %s", r.MatchType)
+	}
+
+	switch matchType {
+	case RuleMatchExact:
+		if r.Pattern == "" {
+			return errors.New("no file name to match")
+		}
+
+	case RuleMatchGlob:
+		if r.Pattern == "" {
+			return errors.New("no glob to match")
+		}
+
+		if _, err := filepath.Match(r.Pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid glob %s", r.Pattern)
+		}
+
+	case RuleMatchRegex:
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return errors.Wrapf(err, "invalid regular expression %s", r.Pattern)
+		}
+
+	case RuleMatchMetadata:
+		if r.Metadata.empty() {
+			return errors.New("no metadata to match")
+		}
+	}
+
+	return nil
+}
+
+// matches tells whether a download is one this rule applies to. metadata is only called for rules that
+// match on it, and should read the download's header
+func (r ReplacementRule) matches(downloadedFile string, metadata func() (filter.Metadata, error)) (bool, error) {
+	switch r.MatchType {
+	case RuleMatchExact:
+		return lowerFileNamesEqual(downloadedFile, r.Pattern), nil
+
+	case RuleMatchGlob:
+		return filepath.Match(strings.ToLower(r.Pattern), strings.ToLower(downloadedFile))
+
+	case RuleMatchRegex:
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return false, err
+		}
+
+		return pattern.MatchString(downloadedFile), nil
+
+	case RuleMatchMetadata:
+		downloadMetadata, err := metadata()
+		if err != nil {
+			return false, err
+		}
+
+		return r.Metadata.matches(downloadMetadata), nil
+	}
+
+	return false, errors.Errorf("unknown match type: %s", r.MatchType)
+}
+
+// validateRules checks every rule, and names the first broken one
+func validateRules(rules []ReplacementRule) error {
+	for idx, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return errors.Wrapf(err, "rule %d (%s)", idx+1, rule.displayName())
+		}
+	}
+
+	return nil
+}
+
+// readRules reads the replacement rules from the config. Rules written by hand without an enabled setting
+// are enabled, since leaving it out reads as "no opinion" rather than "off"
+func readRules(config *Config) ([]ReplacementRule, error) {
+	rules := make([]ReplacementRule, 0)
+	if err := config.UnmarshalKey(configKeyRules, &rules, viper.DecodeHook(enableRulesByDefault)); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func enableRulesByDefault(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	settings, ok := data.(map[string]interface{})
+	if !ok || to != reflect.TypeOf(ReplacementRule{}) {
+		return data, nil
+	}
+
+	for key := range settings {
+		if strings.EqualFold(key, "enabled") {
+			return data, nil
+		}
+	}
+
+	enabled := map[string]interface{}{"enabled": true}
+	for key, value := range settings {
+		enabled[key] = value
+	}
+
+	return enabled, nil
+}
+
+// configuredRules reads the replacement rules from the config, as they are
+func (e *Engine) configuredRules() ([]ReplacementRule, error) {
+	rules, err := readRules(e.config)
+	if err != nil {
+		return nil, errors.Wrap(err, "read replacement rules")
+	}
+
+	return rules, nil
+}
+
+// replacementRules returns the enabled rules to replace filters by. Configs from before rules existed
+// have a single download -> target mapping instead, which turns into a single rule
+func (e *Engine) replacementRules() ([]ReplacementRule, error) {
+	configured, err := e.configuredRules()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(configured) == 0 {
+		return e.legacyRules()
+	}
+
+	if err := validateRules(configured); err != nil {
+		return nil, err
+	}
+
+	rules := make([]ReplacementRule, 0, len(configured))
+	for _, rule := range configured {
+		if rule.Enabled {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, nil
+}
+
+func (e *Engine) legacyRules() ([]ReplacementRule, error) {
+	watchStrategy, ok := parseWatchStrategy(e.config.GetString(configKeyDownloadsWatchStrategy))
+	if !ok {
+		return nil, errors.New("get downloads watch strategy from config")
+	}
+
+	overwriteStrategy, ok := parseOverwriteStrategy(e.config.GetString(configKeyFiltersOverwriteStrategy))
+	if !ok {
+		return nil, errors.New("get filters overwrite strategy from config")
+	}
+
+	target := e.config.GetString(configKeyFiltersSelectedFile)
+	if target == "" {
+		return []ReplacementRule{}, nil
+	}
+
+	rule := ReplacementRule{
+		Enabled:           true,
+		MatchType:         RuleMatchGlob,
+		Pattern:           "*",
+		Target:            target,
+		OverwriteStrategy: string(overwriteStrategy),
+	}
+
+	if watchStrategy == WatchNamedFile {
+		rule.MatchType = RuleMatchExact
+		rule.Pattern = e.config.GetString(configKeyDownloadsNamedFile)
+	}
+
+	return []ReplacementRule{rule}, nil
+}
+
+// setRules validates and saves a new set of replacement rules
+func (e *Engine) setRules(rules []ReplacementRule) error {
+	if err := validateRules(rules); err != nil {
+		return err
+	}
+
+	e.config.Set(configKeyRules, rules)
+	if err := e.writeConfig(configKeyRules); err != nil {
+		return errors.Wrap(err, "write config")
+	}
+
+	e.refreshStatus()
+	return nil
+}
+
+// matchRule finds the first rule that applies to the download
+func matchRule(rules []ReplacementRule, downloadedFile string, metadata func() (filter.Metadata, error)) (ReplacementRule, bool, error) {
+	for _, rule := range rules {
+		matched, err := rule.matches(downloadedFile, metadata)
+		if err != nil {
+			return ReplacementRule{}, false, errors.Wrapf(err, "match rule %s", rule.displayName())
+		}
+
+		if matched {
+			return rule, true, nil
+		}
+	}
+
+	return ReplacementRule{}, false, nil
+}
+
+func (r ReplacementRule) String() string {
+	pattern := r.Pattern
+	if r.MatchType == RuleMatchMetadata {
+		pattern = r.Metadata.String()
+	}
+
+	return fmt.Sprintf("%s (%s %s -> %s)", r.displayName(), r.MatchType, pattern, r.Target)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/omriharel/filtersnatch/filter"
+	"github.com/pkg/errors"
+)
+
+// newTestConfigFromYAML returns a config with the defaults, and whatever the given config file sets
+func newTestConfigFromYAML(t *testing.T, contents string) *Config {
+	t.Helper()
+
+	v := newDefaultViper()
+	if err := v.ReadConfig(strings.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+
+	return newConfig(v)
+}
+
+func TestReadRulesEnabledByDefault(t *testing.T) {
+	config := newTestConfigFromYAML(t, `
+rules:
+  - name: left out
+    match_type: exact
+    pattern: NeverSink.filter
+    target: installed.filter
+    overwrite_strategy: selected_file
+  - name: turned off
+    enabled: false
+    match_type: exact
+    pattern: NeverSink.filter
+    target: installed.filter
+    overwrite_strategy: selected_file
+  - name: turned on
+    enabled: true
+    match_type: exact
+    pattern: NeverSink.filter
+    target: installed.filter
+    overwrite_strategy: selected_file
+`)
+
+	rules, err := readRules(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enabled := make(map[string]bool)
+	for _, rule := range rules {
+		enabled[rule.Name] = rule.Enabled
+	}
+
+	want := map[string]bool{"left out": true, "turned off": false, "turned on": true}
+	if !reflect.DeepEqual(enabled, want) {
+		t.Fatalf("got %v, want %v", enabled, want)
+	}
+
+	// and rules saved by filtersnatch itself read back the way they were saved
+	engine := newTestEngine(t, nopEmitter{})
+	useTestConfigFile(t, engine)
+
+	if err := engine.setRules(rules); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := engine.configuredRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(saved, rules) {
+		t.Fatalf("got %+v, want %+v", saved, rules)
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	valid := ReplacementRule{
+		Enabled:           true,
+		MatchType:         RuleMatchExact,
+		Pattern:           "NeverSink.filter",
+		Target:            "installed.filter",
+		OverwriteStrategy: string(OverwriteSelectedFile),
+	}
+
+	tests := []struct {
+		name   string
+		change func(*ReplacementRule)
+		valid  bool
+	}{
+		{name: "valid", change: func(r *ReplacementRule) {}, valid: true},
+		{name: "glob", change: func(r *ReplacementRule) { r.MatchType, r.Pattern = RuleMatchGlob, "NeverSink*.filter" }, valid: true},
+		{name: "regex", change: func(r *ReplacementRule) { r.MatchType, r.Pattern = RuleMatchRegex, `^NeverSink.*\.filter$` }, valid: true},
+		{name: "metadata", change: func(r *ReplacementRule) {
+			r.MatchType, r.Pattern, r.Metadata = RuleMatchMetadata, "", RuleMetadataMatch{Strictness: "Strict"}
+		}, valid: true},
+		{name: "target template", change: func(r *ReplacementRule) {
+			r.OverwriteStrategy, r.Target = string(OverwriteNamedFile), "{base}-{strictness}.filter"
+		}, valid: true},
+
+		{name: "unknown overwrite strategy", change: func(r *ReplacementRule) { r.OverwriteStrategy = "somewhere" }},
+		{name: "target isn't a filter", change: func(r *ReplacementRule) { r.Target = "installed.txt" }},
+		{name: "broken target template", change: func(r *ReplacementRule) {
+			r.OverwriteStrategy, r.Target = string(OverwriteNamedFile), "{nonsense}.filter"
+		}},
+		{name: "unknown match type", change: func(r *ReplacementRule) { r.MatchType = "vibes" }},
+		{name: "no match type", change: func(r *ReplacementRule) { r.MatchType = "" }},
+		{name: "no exact name", change: func(r *ReplacementRule) { r.Pattern = "" }},
+		{name: "no glob", change: func(r *ReplacementRule) { r.MatchType, r.Pattern = RuleMatchGlob, "" }},
+		{name: "broken glob", change: func(r *ReplacementRule) { r.MatchType, r.Pattern = RuleMatchGlob, "[NeverSink" }},
+		{name: "broken regex", change: func(r *ReplacementRule) { r.MatchType, r.Pattern = RuleMatchRegex, "(NeverSink" }},
+		{name: "no metadata", change: func(r *ReplacementRule) { r.MatchType = RuleMatchMetadata }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := valid
+			test.change(&rule)
+
+			if err := validateRules([]ReplacementRule{valid, rule}); (err == nil) != test.valid {
+				t.Fatalf("got %v, want valid %v", err, test.valid)
+			} else if err != nil && !strings.Contains(err.Error(), "rule 2") {
+				t.Fatalf("got %v, want it to name the second rule", err)
+			}
+		})
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	header := filter.Metadata{Source: "FilterBlade", Strictness: "Very Strict", Style: "Default", League: "Standard"}
+
+	rule := func(name string, matchType RuleMatchType, pattern string) ReplacementRule {
+		return ReplacementRule{Name: name, Enabled: true, MatchType: matchType, Pattern: pattern}
+	}
+
+	metadataRule := func(name string, metadata RuleMetadataMatch) ReplacementRule {
+		return ReplacementRule{Name: name, Enabled: true, MatchType: RuleMatchMetadata, Metadata: metadata}
+	}
+
+	tests := []struct {
+		name           string
+		rules          []ReplacementRule
+		downloadedFile string
+
+		want        string // the name of the matching rule, empty if none does
+		wantErr     bool
+		wantsHeader bool
+	}{
+		{name: "no rules", downloadedFile: "NeverSink.filter"},
+		{name: "exact", rules: []ReplacementRule{rule("exact", RuleMatchExact, "neversink.FILTER")},
+			downloadedFile: "NeverSink.filter", want: "exact"},
+		{name: "exact doesn't match other names", rules: []ReplacementRule{rule("exact", RuleMatchExact, "NeverSink.filter")},
+			downloadedFile: "NeverSink (1).filter"},
+		{name: "glob", rules: []ReplacementRule{rule("glob", RuleMatchGlob, "NeverSink*.filter")},
+			downloadedFile: "neversink (1).filter", want: "glob"},
+		{name: "glob doesn't match", rules: []ReplacementRule{rule("glob", RuleMatchGlob, "NeverSink*.filter")},
+			downloadedFile: "FilterBlade.filter"},
+		{name: "broken glob", rules: []ReplacementRule{rule("glob", RuleMatchGlob, "[NeverSink")},
+			downloadedFile: "NeverSink.filter", wantErr: true},
+		{name: "regex", rules: []ReplacementRule{rule("regex", RuleMatchRegex, `^NeverSink( \(\d+\))?\.filter$`)},
+			downloadedFile: "NeverSink (2).filter", want: "regex"},
+		{name: "regex is case-sensitive", rules: []ReplacementRule{rule("regex", RuleMatchRegex, `^NeverSink`)},
+			downloadedFile: "neversink.filter"},
+		{name: "broken regex", rules: []ReplacementRule{rule("regex", RuleMatchRegex, "(")},
+			downloadedFile: "NeverSink.filter", wantErr: true},
+		{name: "metadata", rules: []ReplacementRule{metadataRule("metadata", RuleMetadataMatch{Source: "filterblade", Strictness: "very strict"})},
+			downloadedFile: "anything.filter", want: "metadata", wantsHeader: true},
+		{name: "metadata doesn't match", rules: []ReplacementRule{metadataRule("metadata", RuleMetadataMatch{Strictness: "Strict"})},
+			downloadedFile: "anything.filter", wantsHeader: true},
+		{name: "unknown match type", rules: []ReplacementRule{rule("unknown", "vibes", "")},
+			downloadedFile: "NeverSink.filter", wantErr: true},
+
+		{name: "first match wins", rules: []ReplacementRule{
+			rule("other", RuleMatchExact, "FilterBlade.filter"),
+			rule("first", RuleMatchGlob, "*.filter"),
+			rule("second", RuleMatchExact, "NeverSink.filter"),
+		}, downloadedFile: "NeverSink.filter", want: "first"},
+		{name: "header is only read for metadata rules", rules: []ReplacementRule{
+			rule("name", RuleMatchExact, "NeverSink.filter"),
+			metadataRule("metadata", RuleMetadataMatch{Strictness: "Very Strict"}),
+		}, downloadedFile: "NeverSink.filter", want: "name"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			readHeader := false
+			metadata := func() (filter.Metadata, error) {
+				readHeader = true
+				return header, nil
+			}
+
+			matched, ok, err := matchRule(test.rules, test.downloadedFile, metadata)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want one %v", err, test.wantErr)
+			}
+
+			if ok != (test.want != "") || matched.Name != test.want {
+				t.Fatalf("got %s (%v), want %q", matched.Name, ok, test.want)
+			}
+
+			if readHeader != test.wantsHeader {
+				t.Fatalf("read the header %v, want %v", readHeader, test.wantsHeader)
+			}
+		})
+	}
+
+	t.Run("unreadable header", func(t *testing.T) {
+		_, _, err := matchRule([]ReplacementRule{metadataRule("metadata", RuleMetadataMatch{League: "Standard"})}, "NeverSink.filter",
+			func() (filter.Metadata, error) { return filter.Metadata{}, errInjected })
+
+		if !errors.Is(err, errInjected) {
+			t.Fatalf("got %v, want the header's error", err)
+		}
+	})
+}
+
+func TestReplacementRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     []ReplacementRule
+		wantErr  bool
+	}{
+		{name: "nothing selected", want: []ReplacementRule{}},
+		{name: "legacy newest filter file", settings: map[string]interface{}{
+			configKeyFiltersSelectedFile: "installed.filter",
+		}, want: []ReplacementRule{{Enabled: true, MatchType: RuleMatchGlob, Pattern: "*", Target: "installed.filter",
+			OverwriteStrategy: string(OverwriteSelectedFile)}}},
+		{name: "legacy named file", settings: map[string]interface{}{
+			configKeyFiltersSelectedFile:      "installed.filter",
+			configKeyFiltersOverwriteStrategy: string(OverwriteNamedFile),
+			configKeyDownloadsWatchStrategy:   string(WatchNamedFile),
+			configKeyDownloadsNamedFile:       "NeverSink.filter",
+		}, want: []ReplacementRule{{Enabled: true, MatchType: RuleMatchExact, Pattern: "NeverSink.filter", Target: "installed.filter",
+			OverwriteStrategy: string(OverwriteNamedFile)}}},
+		{name: "legacy unknown watch strategy", settings: map[string]interface{}{
+			configKeyFiltersSelectedFile:    "installed.filter",
+			configKeyDownloadsWatchStrategy: "everything",
+		}, wantErr: true},
+		{name: "legacy unknown overwrite strategy", settings: map[string]interface{}{
+			configKeyFiltersSelectedFile:      "installed.filter",
+			configKeyFiltersOverwriteStrategy: "somewhere",
+		}, wantErr: true},
+		{name: "rules replace the legacy settings, and only enabled ones are used", settings: map[string]interface{}{
+			configKeyFiltersSelectedFile: "installed.filter",
+			configKeyRules: []ReplacementRule{
+				{Name: "off", MatchType: RuleMatchGlob, Pattern: "*", Target: "off.filter", OverwriteStrategy: string(OverwriteSelectedFile)},
+				{Name: "on", Enabled: true, MatchType: RuleMatchGlob, Pattern: "*", Target: "on.filter", OverwriteStrategy: string(OverwriteSelectedFile)},
+			},
+		}, want: []ReplacementRule{{Name: "on", Enabled: true, MatchType: RuleMatchGlob, Pattern: "*", Target: "on.filter",
+			OverwriteStrategy: string(OverwriteSelectedFile)}}},
+		{name: "broken rule", settings: map[string]interface{}{
+			configKeyRules: []ReplacementRule{{Name: "broken", Enabled: true, MatchType: RuleMatchGlob, Target: "on.filter",
+				OverwriteStrategy: string(OverwriteSelectedFile)}},
+		}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := newTestEngine(t, nopEmitter{})
+			for key, value := range test.settings {
+				engine.config.Set(key, value)
+			}
+
+			rules, err := engine.replacementRules()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want one %v", err, test.wantErr)
+			}
+
+			if !test.wantErr && !reflect.DeepEqual(rules, test.want) {
+				t.Fatalf("got %+v, want %+v", rules, test.want)
+			}
+		})
+	}
+}
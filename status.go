@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const statusRefreshInterval = time.Second * 5
+
+type DirectoryStatus struct {
+	Path   string `json:"path"`
+	Set    bool   `json:"set"`
+	Exists bool   `json:"exists"`
+}
+
+// Status is a snapshot of everything the UI and tray need to tell the user what filtersnatch is up to
+type Status struct {
+	FiltersDirectory   DirectoryStatus `json:"filters_directory"`
+	DownloadsDirectory DirectoryStatus `json:"downloads_directory"`
+
+	OverwriteStrategyValid bool `json:"overwrite_strategy_valid"`
+	WatchStrategyValid     bool `json:"watch_strategy_valid"`
+	TargetFileSet          bool `json:"target_file_set"`
+
+	// Rules is how many replacement rules are configured. When there are any, they're used
+	// instead of the single target file and strategies above
+	Rules      int  `json:"rules"`
+	RulesValid bool `json:"rules_valid"`
+
+	Watching         bool `json:"watching"`
+	Paused           bool `json:"paused"`
+	PendingDownloads int  `json:"pending_downloads"`
+
+	LastReplacement *ReplacementReceipt `json:"last_replacement"`
+}
+
+// Status is also the payload of eventStatusChanged
+func (Status) EventName() string { return eventStatusChanged }
+
+// Misconfigured returns the reasons filtersnatch can't currently replace anything, if there are any
+func (s Status) Misconfigured() []string {
+	reasons := make([]string, 0)
+
+	if !s.FiltersDirectory.Set {
+		reasons = append(reasons, "no filters directory chosen")
+	} else if !s.FiltersDirectory.Exists {
+		reasons = append(reasons, "filters directory missing")
+	}
+
+	if !s.DownloadsDirectory.Set {
+		reasons = append(reasons, "no downloads directory chosen")
+	} else if !s.DownloadsDirectory.Exists {
+		reasons = append(reasons, "downloads directory missing")
+	}
+
+	if !s.RulesValid {
+		reasons = append(reasons, "invalid replacement rules")
+	}
+
+	if s.Rules > 0 {
+		return reasons
+	}
+
+	if !s.OverwriteStrategyValid {
+		reasons = append(reasons, "invalid filter overwrite mode")
+	}
+
+	if !s.WatchStrategyValid {
+		reasons = append(reasons, "invalid download watch mode")
+	}
+
+	if !s.TargetFileSet {
+		reasons = append(reasons, "no filter file to replace")
+	}
+
+	return reasons
+}
+
+// Summary is a short, human-readable version of the status (used for the tray tooltip)
+func (s Status) Summary() string {
+	var state string
+
+	if reasons := s.Misconfigured(); len(reasons) > 0 {
+		state = "needs setup: " + strings.Join(reasons, ", ")
+	} else if s.Paused {
+		state = "paused"
+	} else if !s.Watching {
+		state = "not watching"
+	} else if s.PendingDownloads > 0 {
+		state = fmt.Sprintf("watching (%d downloads in progress)", s.PendingDownloads)
+	} else {
+		state = "watching"
+	}
+
+	summary := "filtersnatch - " + state
+
+	if s.LastReplacement != nil {
+		replacedAt := s.LastReplacement.ReplacedAt
+		if parsed := s.LastReplacement.replacedTime(); !parsed.IsZero() {
+			replacedAt = parsed.Local().Format("Jan 2 15:04")
+		}
+
+		summary += fmt.Sprintf("\nLast replaced: %s (%s)", filepath.Base(s.LastReplacement.TargetPath), replacedAt)
+	}
+
+	return summary
+}
+
+func (e *Engine) computeStatus() Status {
+	status := Status{
+		Paused: e.IsPaused(),
+	}
+
+	status.FiltersDirectory = newDirectoryStatus(e.config.GetString(configKeyFiltersDirectory))
+	status.DownloadsDirectory = newDirectoryStatus(e.config.GetString(configKeyDownloadsDirectory))
+
+	_, status.OverwriteStrategyValid = parseOverwriteStrategy(e.config.GetString(configKeyFiltersOverwriteStrategy))
+	_, status.WatchStrategyValid = parseWatchStrategy(e.config.GetString(configKeyDownloadsWatchStrategy))
+	status.TargetFileSet = e.config.GetString(configKeyFiltersSelectedFile) != ""
+
+	rules, err := e.configuredRules()
+	status.Rules = len(rules)
+	status.RulesValid = err == nil && validateRules(rules) == nil
+
+	if e.watcher != nil {
+		status.Watching = e.watcher.IsRunning()
+		status.PendingDownloads = e.watcher.PendingDownloadCount()
+	}
+
+	if e.receipts != nil {
+		if receipts := e.receipts.All(); len(receipts) > 0 {
+			status.LastReplacement = &receipts[0]
+		}
+	}
+
+	return status
+}
+
+func newDirectoryStatus(path string) DirectoryStatus {
+	return DirectoryStatus{
+		Path:   path,
+		Set:    path != "",
+		Exists: path != "" && dirExists(os.ExpandEnv(path)),
+	}
+}
+
+// refreshStatus recomputes the status, and lets everyone know if it changed since the last time. The status is
+// computed and sent under the same lock, so refreshes racing each other can't send an older status last
+func (e *Engine) refreshStatus() {
+	e.statusLock.Lock()
+	defer e.statusLock.Unlock()
+
+	status := e.computeStatus()
+	if e.lastStatus != nil && reflect.DeepEqual(*e.lastStatus, status) {
+		return
+	}
+
+	e.lastStatus = &status
+	e.emit(status)
+
+	for _, listener := range e.statusListeners() {
+		listener(status)
+	}
+}
+
+// OnStatusChanged calls listener with the new status every time it changes. Like OnPausedStateChanged, it's
+// for Go code that can't rely on listening for eventStatusChanged. Listeners mustn't refresh the status themselves
+func (e *Engine) OnStatusChanged(listener func(status Status)) {
+	e.listenersLock.Lock()
+	defer e.listenersLock.Unlock()
+
+	e.onStatusChanged = append(e.onStatusChanged, listener)
+}
+
+func (e *Engine) statusListeners() []func(status Status) {
+	e.listenersLock.Lock()
+	defer e.listenersLock.Unlock()
+
+	return append([]func(status Status){}, e.onStatusChanged...)
+}
+
+// watchStatus periodically refreshes the status, to catch things nobody tells us about (like a directory going away)
+func (e *Engine) watchStatus() {
+	ticker := time.NewTicker(statusRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.refreshStatus()
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
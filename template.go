@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/omriharel/filtersnatch/filter"
+	"github.com/pkg/errors"
+)
+
+// named target files can be templates, e.g. "{base}-{strictness}.filter". Placeholders are filled in
+// from the download's name and header when it's about to replace the target
+const (
+	templatePlaceholderBase         = "base"
+	templatePlaceholderDownloadName = "download_name"
+	templatePlaceholderVersion      = "version"
+	templatePlaceholderStrictness   = "strictness"
+	templatePlaceholderStyle        = "style"
+	templatePlaceholderLeague       = "league"
+	templatePlaceholderSource       = "source"
+	templatePlaceholderDate         = "date"
+)
+
+const defaultTemplateDateLayout = "2006-01-02"
+
+// browserDuplicatePattern matches the " (1)" browsers add to downloads whose name is already taken
+var browserDuplicatePattern = regexp.MustCompile(`\s*\(\d+\)$`)
+
+// unsafeTemplateValueCharacters are replaced in values that come from downloads, so that a filter's
+// header can't smuggle path separators into a target name
+var unsafeTemplateValueCharacters = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+
+// targetTemplateData is everything a target template can refer to
+type targetTemplateData struct {
+	downloadedFile string
+	metadata       func() (filter.Metadata, error)
+	now            time.Time
+}
+
+// isTargetTemplate tells whether a target name has any placeholders in it
+func isTargetTemplate(target string) bool {
+	return strings.ContainsAny(target, "{}")
+}
+
+// expandTargetTemplate fills in a target template's placeholders, and makes sure the result is a usable
+// filter file name. Names without placeholders are returned as they are
+func expandTargetTemplate(template string, data targetTemplateData) (string, error) {
+	var expanded strings.Builder
+	rest := template
+
+	for rest != "" {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			expanded.WriteString(rest)
+			break
+		}
+
+		if rest[start] == '}' {
+			return "", errors.Errorf("unexpected '}' in %q", template)
+		}
+
+		end := strings.IndexAny(rest[start+1:], "{}")
+		if end < 0 || rest[start+1+end] != '}' {
+			return "", errors.Errorf("unclosed placeholder in %q", template)
+		}
+		end += start + 1
+
+		value, err := templatePlaceholderValue(rest[start+1:end], data)
+		if err != nil {
+			return "", err
+		}
+
+		expanded.WriteString(rest[:start])
+		expanded.WriteString(value)
+		rest = rest[end+1:]
+	}
+
+	name := expanded.String()
+	if err := validateFilterFileName(name); err != nil {
+		return "", errors.Wrapf(err, "template %q", template)
+	}
+
+	return name, nil
+}
+
+func templatePlaceholderValue(placeholder string, data targetTemplateData) (string, error) {
+	name, argument := placeholder, ""
+	if idx := strings.Index(placeholder, ":"); idx >= 0 {
+		name, argument = placeholder[:idx], placeholder[idx+1:]
+	}
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if argument != "" && name != templatePlaceholderDate {
+		return "", errors.Errorf("placeholder {%s} doesn't take a format", name)
+	}
+
+	downloadName := strings.TrimSuffix(data.downloadedFile, filepath.Ext(data.downloadedFile))
+
+	switch name {
+	case templatePlaceholderDate:
+		if argument == "" {
+			argument = defaultTemplateDateLayout
+		}
+
+		// the layout is the user's own, so it isn't sanitized - a layout with slashes in it is a mistake
+		return data.now.Format(argument), nil
+
+	case templatePlaceholderDownloadName:
+		return sanitizeTemplateValue(downloadName), nil
+
+	case templatePlaceholderBase:
+		return sanitizeTemplateValue(browserDuplicatePattern.ReplaceAllString(downloadName, "")), nil
+
+	case templatePlaceholderVersion, templatePlaceholderStrictness, templatePlaceholderStyle,
+		templatePlaceholderLeague, templatePlaceholderSource:
+
+		metadata, err := data.metadata()
+		if err != nil {
+			return "", errors.Wrap(err, "read download metadata")
+		}
+
+		value := map[string]string{
+			templatePlaceholderVersion:    metadata.Version,
+			templatePlaceholderStrictness: metadata.Strictness,
+			templatePlaceholderStyle:      metadata.Style,
+			templatePlaceholderLeague:     metadata.League,
+			templatePlaceholderSource:     metadata.Source,
+		}[name]
+
+		if value == "" {
+			return "", errors.Errorf("download's header doesn't say what its %s is", name)
+		}
+
+		return sanitizeTemplateValue(value), nil
+	}
+
+	return "", errors.Errorf("unknown placeholder {%s}", placeholder)
+}
+
+func sanitizeTemplateValue(value string) string {
+	return strings.TrimSpace(unsafeTemplateValueCharacters.ReplaceAllString(value, "-"))
+}
+
+// validateTargetTemplate makes sure a named target (template or not) can produce a usable file name,
+// by filling it in with made-up values
+func validateTargetTemplate(template string) error {
+	if !isTargetTemplate(template) {
+		return validateFilterFileName(template)
+	}
+
+	_, err := expandTargetTemplate(template, targetTemplateData{
+		downloadedFile: "NeverSink's filter - 3-STRICT.filter",
+		metadata: func() (filter.Metadata, error) {
+			return filter.Metadata{
+				Version:    "8.10.3",
+				Strictness: "Strict",
+				Style:      "Default",
+				League:     "Standard",
+				Source:     "FilterBlade",
+			}, nil
+		},
+		now: time.Now(),
+	})
+
+	return err
+}
+
+// describeTargetTemplatePlaceholders lists the placeholders for usage strings
+func describeTargetTemplatePlaceholders() string {
+	return fmt.Sprintf("{%s}, {%s}, {%s}, {%s}, {%s}, {%s}, {%s} and {%s:%s}",
+		templatePlaceholderBase, templatePlaceholderDownloadName, templatePlaceholderVersion,
+		templatePlaceholderStrictness, templatePlaceholderStyle, templatePlaceholderLeague,
+		templatePlaceholderSource, templatePlaceholderDate, defaultTemplateDateLayout)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/omriharel/filtersnatch/filter"
+	"github.com/pkg/errors"
+)
+
+func TestExpandTargetTemplate(t *testing.T) {
+	now := time.Date(2024, time.March, 7, 23, 30, 0, 0, time.UTC)
+	header := filter.Metadata{
+		Version:    "8.10.3",
+		Strictness: "Strict",
+		Style:      "Default",
+		League:     "Standard",
+		Source:     "FilterBlade",
+	}
+
+	tests := []struct {
+		name           string
+		template       string
+		downloadedFile string
+
+		// changes to the header above
+		metadata    func(*filter.Metadata)
+		metadataErr error
+
+		want string // empty if the template can't be expanded
+	}{
+		{name: "no placeholders", template: "NeverSink.filter", want: "NeverSink.filter"},
+		{name: "base", template: "{base}.filter", downloadedFile: "NeverSink (3).filter", want: "NeverSink.filter"},
+		{name: "download name", template: "{download_name}.filter", downloadedFile: "NeverSink (3).filter", want: "NeverSink (3).filter"},
+		{name: "metadata", template: "{source}-{strictness}-{style}-{league}-{version}.filter",
+			want: "FilterBlade-Strict-Default-Standard-8.10.3.filter"},
+		{name: "placeholder case and spaces", template: "{ Strictness }.filter", want: "Strict.filter"},
+
+		{name: "default date", template: "NeverSink-{date}.filter", want: "NeverSink-2024-03-07.filter"},
+		{name: "date layout", template: "NeverSink-{date:20060102-1504}.filter", want: "NeverSink-20240307-2330.filter"},
+		{name: "date layout with slashes", template: "NeverSink-{date:2006/01/02}.filter"},
+		{name: "date layout with backslashes", template: `NeverSink-{date:2006\01\02}.filter`},
+		{name: "date layout with a colon", template: "NeverSink-{date:15:04}.filter"},
+
+		{name: "slash in the header", template: "{league}.filter",
+			metadata: func(m *filter.Metadata) { m.League = "Settlers/HC" }, want: "Settlers-HC.filter"},
+		{name: "backslash in the header", template: "{league}.filter",
+			metadata: func(m *filter.Metadata) { m.League = `..\..\evil` }, want: "..-..-evil.filter"},
+		{name: "dots in the header", template: "{league}.filter",
+			metadata: func(m *filter.Metadata) { m.League = ".." }, want: "...filter"},
+		{name: "dots as the whole name", template: "{league}",
+			metadata: func(m *filter.Metadata) { m.League = ".." }},
+		{name: "reserved name in the header", template: "{strictness}.filter",
+			metadata: func(m *filter.Metadata) { m.Strictness = "CON" }},
+		{name: "reserved name as part of the name", template: "{strictness}-x.filter",
+			metadata: func(m *filter.Metadata) { m.Strictness = "CON" }, want: "CON-x.filter"},
+		{name: "trailing dot in the header", template: "NeverSink-{version}",
+			metadata: func(m *filter.Metadata) { m.Version = "8.10." }},
+		{name: "trailing dot in the header before the extension", template: "NeverSink-{version}.filter",
+			metadata: func(m *filter.Metadata) { m.Version = "8.10." }, want: "NeverSink-8.10..filter"},
+		{name: "illegal characters in the header", template: "{style}.filter",
+			metadata: func(m *filter.Metadata) { m.Style = "<Dark|Mode>?" }, want: "-Dark-Mode--.filter"},
+		{name: "control characters in the header", template: "{style}.filter",
+			metadata: func(m *filter.Metadata) { m.Style = "Dark\nMode" }, want: "Dark-Mode.filter"},
+
+		{name: "missing metadata", template: "{league}.filter",
+			metadata: func(m *filter.Metadata) { m.League = "" }},
+		{name: "unreadable metadata", template: "{league}.filter", metadataErr: errInjected},
+		{name: "metadata isn't read unless it's needed", template: "{base}.filter", metadataErr: errInjected,
+			want: "NeverSink.filter"},
+
+		{name: "unknown placeholder", template: "{nonsense}.filter"},
+		{name: "format on a placeholder without one", template: "{version:2006}.filter"},
+		{name: "unclosed placeholder", template: "{base.filter"},
+		{name: "nested placeholder", template: "{base{version}}.filter"},
+		{name: "unexpected closing brace", template: "base}.filter"},
+		{name: "not a filter", template: "{base}.txt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			downloadedFile := test.downloadedFile
+			if downloadedFile == "" {
+				downloadedFile = "NeverSink.filter"
+			}
+
+			expanded, err := expandTargetTemplate(test.template, targetTemplateData{
+				downloadedFile: downloadedFile,
+				metadata: func() (filter.Metadata, error) {
+					if test.metadataErr != nil {
+						return filter.Metadata{}, test.metadataErr
+					}
+
+					metadata := header
+					if test.metadata != nil {
+						test.metadata(&metadata)
+					}
+
+					return metadata, nil
+				},
+				now: now,
+			})
+
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("got %q, want an error", expanded)
+				}
+
+				return
+			}
+
+			if err != nil || expanded != test.want {
+				t.Fatalf("got %q, %v; want %q", expanded, err, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandTargetTemplateWrapsMetadataErrors(t *testing.T) {
+	_, err := expandTargetTemplate("{league}.filter", targetTemplateData{
+		downloadedFile: "NeverSink.filter",
+		metadata:       func() (filter.Metadata, error) { return filter.Metadata{}, errInjected },
+	})
+
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("got %v, want the metadata error", err)
+	}
+}
+
+func TestValidateTargetTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{template: "NeverSink.filter", valid: true},
+		{template: "{base}-{strictness}.filter", valid: true},
+		{template: "{source}/{league}/{version}-{style}-{download_name}.filter"},
+		{template: "{date:2006-01-02}-{league}.filter", valid: true},
+		{template: "{date:2006/01/02}.filter"},
+		{template: "{unknown}.filter"},
+		{template: "{base}"},
+		{template: "{base"},
+		{template: "CON.filter"},
+		{template: "NeverSink.filter."},
+		{template: "../NeverSink.filter"},
+	}
+
+	for _, test := range tests {
+		if err := validateTargetTemplate(test.template); (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %v", test.template, err, test.valid)
+		}
+	}
+}
@@ -1,3 +1,5 @@
+//go:build !headless
+
 package main
 
 import (
@@ -10,8 +12,9 @@ import (
 func onTrayReady(app *App) {
 	systray.SetIcon(icon.Data)
 	systray.SetTitle("filtersnatch")
-	systray.SetTooltip("filtersnatch")
+	systray.SetTooltip(app.GetStatus().Summary())
 	menuItemShowWindow := systray.AddMenuItem("Options", "Open configuration UI")
+	menuItemPause := systray.AddMenuItemCheckbox("Pause", "Stop replacing filters until resumed", app.IsPaused())
 	systray.AddSeparator()
 
 	if app.version != "" {
@@ -22,6 +25,19 @@ func onTrayReady(app *App) {
 
 	menuItemQuit := systray.AddMenuItem("Quit", "Quit filtersnatch")
 
+	app.engine.OnStatusChanged(func(status Status) {
+		systray.SetTooltip(status.Summary())
+	})
+
+	// keep the checkbox in sync no matter where the state was changed from
+	app.engine.OnPausedStateChanged(func(paused bool) {
+		if paused {
+			menuItemPause.Check()
+		} else {
+			menuItemPause.Uncheck()
+		}
+	})
+
 	go func() {
 		for {
 			select {
@@ -29,6 +45,8 @@ func onTrayReady(app *App) {
 				systray.Quit()
 			case <-menuItemShowWindow.ClickedCh:
 				runtime.WindowShow(app.ctx)
+			case <-menuItemPause.ClickedCh:
+				app.TogglePause()
 			}
 		}
 	}()
@@ -1,12 +1,12 @@
 package main
 
 import (
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/adrg/xdg"
+	"github.com/pkg/errors"
 )
 
 // maybe use this to allows preferences reset.. idk
@@ -32,22 +32,101 @@ func dirExists(path string) bool {
 	return false
 }
 
-func copyFileContents(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
+// windowsReservedNames are base names that Windows refuses to use for regular files,
+// regardless of their extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// validateFilterFileName makes sure a user-supplied filter file name is a plain .filter file name,
+// with no directory components and nothing the OS would refuse to create
+func validateFilterFileName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.Wrap(errInvalidFileName, "empty file name")
+	}
+
+	if name != strings.TrimSpace(name) {
+		return errors.Wrapf(errInvalidFileName, "leading or trailing whitespace in %q", name)
+	}
+
+	if strings.ContainsAny(name, `/\`) || name != filepath.Base(name) {
+		return errors.Wrapf(errInvalidFileName, "path separators in %q", name)
+	}
+
+	if strings.ContainsAny(name, `<>:"|?*`) {
+		return errors.Wrapf(errInvalidFileName, "illegal characters in %q", name)
+	}
+
+	for _, r := range name {
+		if r < 0x20 {
+			return errors.Wrapf(errInvalidFileName, "control characters in %q", name)
+		}
+	}
+
+	if strings.HasSuffix(name, ".") {
+		return errors.Wrapf(errInvalidFileName, "trailing dot in %q", name)
+	}
+
+	if strings.ToLower(filepath.Ext(name)) != ".filter" {
+		return errors.Wrapf(errInvalidFileName, "%q is not a .filter file", name)
+	}
+
+	baseName := strings.TrimSuffix(name, filepath.Ext(name))
+	if baseName == "" {
+		return errors.Wrapf(errInvalidFileName, "%q has no name before its extension", name)
+	}
+
+	if windowsReservedNames[strings.ToUpper(strings.SplitN(baseName, ".", 2)[0])] {
+		return errors.Wrapf(errInvalidFileName, "%q is a reserved name", name)
 	}
-	defer in.Close()
 
-	out, err := os.Create(dst)
+	return nil
+}
+
+// resolvePathInDirectory joins a file name onto a directory, and makes sure the result
+// still lives directly inside that directory
+func resolvePathInDirectory(directory, name string) (string, error) {
+	absDirectory, err := filepath.Abs(directory)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, in)
+	resolved := filepath.Join(absDirectory, name)
+	if filepath.Dir(resolved) != filepath.Clean(absDirectory) {
+		return "", errors.Wrapf(errPathEscapesDirectory, "%s is not inside %s", resolved, absDirectory)
+	}
+
+	return resolved, nil
+}
+
+// resolvePathUnderDirectory is like resolvePathInDirectory, but allows the name to have subdirectories in it.
+// Absolute names, and names that climb out of the directory (like "../../x") are refused
+func resolvePathUnderDirectory(directory, name string) (string, error) {
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", errors.Wrapf(errPathEscapesDirectory, "%s is an absolute path", name)
+	}
+
+	absDirectory, err := filepath.Abs(directory)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	resolved := filepath.Join(absDirectory, name)
+	relative, err := filepath.Rel(absDirectory, resolved)
+	if err != nil || relative == "." || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", errors.Wrapf(errPathEscapesDirectory, "%s is not inside %s", resolved, absDirectory)
 	}
-	return out.Close()
+
+	return resolved, nil
+}
+
+func fileExists(path string) bool {
+	pathInfo, err := os.Stat(path)
+	if err == nil && !pathInfo.IsDir() {
+		return true
+	}
+
+	return false
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/omriharel/filtersnatch/filter"
+	"github.com/pkg/errors"
+)
+
+const (
+	quarantineDirectoryName = ".filtersnatch-quarantine"
+
+	// how far into a file to look for signs of it being a web page
+	htmlSniffLength = 1024
+
+	// a broken file can have thousands of errors, and nobody needs to see more than a few of them
+	maxIssuesPerCheck = 10
+)
+
+type ValidationCheck string
+
+const (
+	ValidationEmpty          ValidationCheck = "empty"
+	ValidationTruncated      ValidationCheck = "truncated"
+	ValidationHTML           ValidationCheck = "html"
+	ValidationEncoding       ValidationCheck = "encoding"
+	ValidationSyntax         ValidationCheck = "syntax"
+	ValidationUnknownKeyword ValidationCheck = "unknown_keyword"
+)
+
+// ValidationIssue is a single problem found in a downloaded filter.
+// Blocking issues keep the file from being installed, the rest are only reported
+type ValidationIssue struct {
+	Check    ValidationCheck `json:"check"`
+	Line     int             `json:"line,omitempty"`
+	Message  string          `json:"message"`
+	Blocking bool            `json:"blocking"`
+}
+
+// DownloadQuarantinedEvent is sent along with eventDownloadQuarantined
+type DownloadQuarantinedEvent struct {
+	DownloadedFile  string            `json:"downloaded_file"`
+	QuarantinedPath string            `json:"quarantined_path"`
+	Reason          string            `json:"reason"`
+	Issues          []ValidationIssue `json:"issues"`
+}
+
+func (DownloadQuarantinedEvent) EventName() string { return eventDownloadQuarantined }
+
+func (i ValidationIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%s (line %d): %s", i.Check, i.Line, i.Message)
+	}
+
+	return fmt.Sprintf("%s: %s", i.Check, i.Message)
+}
+
+// validateFilterFile checks a downloaded filter for anything that means it shouldn't be installed.
+// Files that aren't filters at all are always rejected. Syntax errors and unknown keywords are only
+// rejected in strict mode, since new game versions regularly add keywords we don't know about yet
+func validateFilterFile(path string, mode ValidationMode) ([]ValidationIssue, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read downloaded filter file")
+	}
+
+	return validateFilterContents(contents, mode), nil
+}
+
+func validateFilterContents(contents []byte, mode ValidationMode) []ValidationIssue {
+	strict := mode == ValidationStrict
+
+	if len(bytes.TrimSpace(bytes.TrimPrefix(contents, []byte("\xEF\xBB\xBF")))) == 0 {
+		return []ValidationIssue{{Check: ValidationEmpty, Message: "file is empty", Blocking: true}}
+	}
+
+	if looksLikeHTML(contents) {
+		return []ValidationIssue{{Check: ValidationHTML, Message: "file is a web page, not a filter", Blocking: true}}
+	}
+
+	if issue, ok := checkEncoding(contents); !ok {
+		return []ValidationIssue{issue}
+	}
+
+	parsed, err := filter.Parse(contents)
+	if len(parsed.Blocks()) == 0 {
+		return []ValidationIssue{{Check: ValidationTruncated, Message: "file has no Show, Hide or Minimal blocks", Blocking: true}}
+	}
+
+	issues := make([]ValidationIssue, 0)
+
+	var syntaxErrors filter.ErrorList
+	if err != nil {
+		syntaxErrors = err.(filter.ErrorList)
+	}
+
+	// a file cut off mid-line leaves its last line broken, and without a line ending
+	lastLine := bytes.Count(contents, []byte("\n")) + 1
+	endsCleanly := bytes.HasSuffix(contents, []byte("\n"))
+
+	if !endsCleanly && len(syntaxErrors) > 0 && syntaxErrors[len(syntaxErrors)-1].Line == lastLine {
+		issues = append(issues, ValidationIssue{
+			Check:    ValidationTruncated,
+			Line:     lastLine,
+			Message:  "file seems to be cut off: " + syntaxErrors[len(syntaxErrors)-1].Message,
+			Blocking: true,
+		})
+
+		syntaxErrors = syntaxErrors[:len(syntaxErrors)-1]
+	}
+
+	for idx, syntaxError := range syntaxErrors {
+		if idx >= maxIssuesPerCheck {
+			issues = append(issues, ValidationIssue{
+				Check:    ValidationSyntax,
+				Message:  fmt.Sprintf("and %d more syntax errors", len(syntaxErrors)-idx),
+				Blocking: strict,
+			})
+			break
+		}
+
+		issues = append(issues, ValidationIssue{
+			Check:    ValidationSyntax,
+			Line:     syntaxError.Line,
+			Message:  syntaxError.Message,
+			Blocking: strict,
+		})
+	}
+
+	for _, statement := range unknownStatements(parsed) {
+
+		// a cut-off keyword on the very last line is truncation, not an unknown keyword
+		if !endsCleanly && statement.Line == lastLine {
+			issues = append(issues, ValidationIssue{
+				Check:    ValidationTruncated,
+				Line:     statement.Line,
+				Message:  fmt.Sprintf("file seems to be cut off at %q", statement.Keyword),
+				Blocking: true,
+			})
+			continue
+		}
+
+		issues = append(issues, ValidationIssue{
+			Check:    ValidationUnknownKeyword,
+			Line:     statement.Line,
+			Message:  fmt.Sprintf("unknown keyword %q", statement.Keyword),
+			Blocking: strict,
+		})
+	}
+
+	return issues
+}
+
+// unknownStatements returns the first statement using each unknown keyword
+func unknownStatements(parsed *filter.Filter) []*filter.Statement {
+	seen := make(map[string]bool)
+	statements := make([]*filter.Statement, 0)
+
+	for _, block := range parsed.Blocks() {
+		for _, statement := range block.Statements {
+			if statement.Kind != filter.StatementUnknown || seen[statement.Keyword] {
+				continue
+			}
+
+			seen[statement.Keyword] = true
+			if len(seen) <= maxIssuesPerCheck {
+				statements = append(statements, statement)
+			}
+		}
+	}
+
+	return statements
+}
+
+func looksLikeHTML(contents []byte) bool {
+	head := contents
+	if len(head) > htmlSniffLength {
+		head = head[:htmlSniffLength]
+	}
+
+	head = bytes.ToLower(bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xEF\xBB\xBF"))))
+
+	for _, prefix := range []string{"<!doctype html", "<html", "<?xml", "<head", "<body"} {
+		if bytes.HasPrefix(head, []byte(prefix)) {
+			return true
+		}
+	}
+
+	return bytes.Contains(head, []byte("<html")) && bytes.Contains(head, []byte("</"))
+}
+
+// checkEncoding makes sure the file is UTF-8, which is the only encoding the game reads filters in
+func checkEncoding(contents []byte) (ValidationIssue, bool) {
+	if bytes.HasPrefix(contents, []byte("\xFF\xFE")) || bytes.HasPrefix(contents, []byte("\xFE\xFF")) {
+		return ValidationIssue{Check: ValidationEncoding, Message: "file is UTF-16, not UTF-8", Blocking: true}, false
+	}
+
+	if bytes.IndexByte(contents, 0) >= 0 {
+		return ValidationIssue{Check: ValidationEncoding, Message: "file contains NUL bytes", Blocking: true}, false
+	}
+
+	if !utf8.Valid(contents) {
+		lineNumber := 1
+		for idx := 0; idx < len(contents); {
+			r, size := utf8.DecodeRune(contents[idx:])
+			if r == utf8.RuneError && size <= 1 {
+				break
+			}
+
+			if r == '\n' {
+				lineNumber++
+			}
+
+			idx += size
+		}
+
+		return ValidationIssue{
+			Check:    ValidationEncoding,
+			Line:     lineNumber,
+			Message:  "file is not valid UTF-8",
+			Blocking: true,
+		}, false
+	}
+
+	return ValidationIssue{}, true
+}
+
+func blockingIssues(issues []ValidationIssue) []ValidationIssue {
+	blocking := make([]ValidationIssue, 0)
+	for _, issue := range issues {
+		if issue.Blocking {
+			blocking = append(blocking, issue)
+		}
+	}
+
+	return blocking
+}
+
+// summarizeIssues turns issues into a single line, fit for logs and history reasons
+func summarizeIssues(issues []ValidationIssue) string {
+	summaries := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		summaries = append(summaries, issue.String())
+	}
+
+	return strings.Join(summaries, "; ")
+}
+
+// quarantineFile moves a rejected download into a hidden directory in the given (downloads) directory, so that
+// it's out of the way but can still be looked at. Returns the path it was moved to
+func quarantineFile(directory, sourcePath string) (string, error) {
+	fileName := filepath.Base(sourcePath)
+
+	quarantineDirectory := filepath.Join(directory, quarantineDirectoryName)
+	if err := os.MkdirAll(quarantineDirectory, 0755); err != nil {
+		return "", errors.Wrap(err, "create quarantine directory")
+	}
+
+	quarantinedPath := filepath.Join(quarantineDirectory,
+		fmt.Sprintf("%s-%s", time.Now().UTC().Format(backupTimeFormat), fileName))
+
+	if err := os.Rename(sourcePath, quarantinedPath); err != nil {
+		return "", errors.Wrap(err, "move file to quarantine")
+	}
+
+	return quarantinedPath, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestValidateFilterContents(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+
+		// "<check> <line>" for every issue, in lenient and in strict mode. Blocking issues are marked with a "!"
+		wantLenient []string
+		wantStrict  []string
+	}{
+		{name: "valid", contents: "Show\n\tRarity Unique\n"},
+		{name: "valid with a bom and crlf", contents: "\xEF\xBB\xBFShow\r\n\tRarity Unique\r\n"},
+		{name: "valid without a newline at the end", contents: "Show\n\tRarity Unique"},
+
+		{name: "empty", contents: "", wantLenient: []string{"!empty 0"}, wantStrict: []string{"!empty 0"}},
+		{name: "only whitespace", contents: " \r\n\t\n", wantLenient: []string{"!empty 0"}, wantStrict: []string{"!empty 0"}},
+		{name: "only a bom", contents: "\xEF\xBB\xBF", wantLenient: []string{"!empty 0"}, wantStrict: []string{"!empty 0"}},
+
+		{name: "html error page", contents: "<!DOCTYPE html>\n<html><body>502 Bad Gateway</body></html>\n",
+			wantLenient: []string{"!html 0"}, wantStrict: []string{"!html 0"}},
+		{name: "html after whitespace and a bom", contents: "\xEF\xBB\xBF\n  <html>\n<head><title>Error</title></head></html>",
+			wantLenient: []string{"!html 0"}, wantStrict: []string{"!html 0"}},
+		{name: "html further in", contents: "\n\n<title>Not Found</title><html></html>",
+			wantLenient: []string{"!html 0"}, wantStrict: []string{"!html 0"}},
+
+		{name: "utf-16 little endian", contents: "\xFF\xFES\x00h\x00o\x00w\x00",
+			wantLenient: []string{"!encoding 0"}, wantStrict: []string{"!encoding 0"}},
+		{name: "utf-16 big endian", contents: "\xFE\xFF\x00S\x00h\x00o\x00w",
+			wantLenient: []string{"!encoding 0"}, wantStrict: []string{"!encoding 0"}},
+		{name: "nul bytes", contents: "Show\n\x00\x00\x00\n",
+			wantLenient: []string{"!encoding 0"}, wantStrict: []string{"!encoding 0"}},
+		{name: "invalid utf-8", contents: "Show\n\tBaseType \"Caf\xE9\"\n",
+			wantLenient: []string{"!encoding 2"}, wantStrict: []string{"!encoding 2"}},
+
+		{name: "no blocks", contents: "# nothing but comments\n",
+			wantLenient: []string{"!truncated 0"}, wantStrict: []string{"!truncated 0"}},
+		{name: "cut off in a quoted value", contents: "Show\n\tRarity Unique\nShow\n\tBaseType == \"Divine",
+			wantLenient: []string{"!truncated 4"}, wantStrict: []string{"!truncated 4"}},
+		{name: "cut off in a keyword", contents: "Show\n\tRarity Unique\n\tSetFontS",
+			wantLenient: []string{"!truncated 3"}, wantStrict: []string{"!truncated 3"}},
+
+		{name: "unknown keyword", contents: "Show\n\tHasFancyNewMod True\n\tRarity Unique\n\tHasFancyNewMod False\n",
+			wantLenient: []string{"unknown_keyword 2"}, wantStrict: []string{"!unknown_keyword 2"}},
+		{name: "unknown keyword that's the last line of a complete file", contents: "Show\n\tHasFancyNewMod True\n",
+			wantLenient: []string{"unknown_keyword 2"}, wantStrict: []string{"!unknown_keyword 2"}},
+		{name: "syntax error", contents: "Show\n\tSetFontSize\n\tRarity Unique\n",
+			wantLenient: []string{"syntax 2"}, wantStrict: []string{"!syntax 2"}},
+	}
+
+	describe := func(issues []ValidationIssue) []string {
+		described := make([]string, 0)
+		for _, issue := range issues {
+			blocking := ""
+			if issue.Blocking {
+				blocking = "!"
+			}
+
+			described = append(described, fmt.Sprintf("%s%s %d", blocking, issue.Check, issue.Line))
+		}
+
+		return described
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for mode, want := range map[ValidationMode][]string{ValidationLenient: test.wantLenient, ValidationStrict: test.wantStrict} {
+				if want == nil {
+					want = []string{}
+				}
+
+				if got := describe(validateFilterContents([]byte(test.contents), mode)); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: got %v, want %v", mode, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateFilterContentsLimitsSyntaxErrors(t *testing.T) {
+	contents := "Show\n" + strings.Repeat("\tSetFontSize\n", maxIssuesPerCheck+5)
+
+	issues := validateFilterContents([]byte(contents), ValidationLenient)
+	if len(issues) != maxIssuesPerCheck+1 {
+		t.Fatalf("got %d issues, want %d and a summary of the rest", len(issues), maxIssuesPerCheck)
+	}
+
+	if last := issues[len(issues)-1]; last.Line != 0 || !strings.Contains(last.Message, "5 more") {
+		t.Fatalf("last issue is %+v", last)
+	}
+}
+
+func TestQuarantineFile(t *testing.T) {
+	downloadsDirectory := t.TempDir()
+	download := filepath.Join(downloadsDirectory, "NeverSink.filter")
+	writeTestFile(t, download, "<html></html>")
+
+	quarantinedPath, err := quarantineFile(downloadsDirectory, download)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Dir(quarantinedPath) != filepath.Join(downloadsDirectory, quarantineDirectoryName) ||
+		!strings.HasSuffix(quarantinedPath, "-NeverSink.filter") {
+		t.Fatalf("quarantined to %s", quarantinedPath)
+	}
+
+	if fileExists(download) {
+		t.Fatal("download is still where it was")
+	}
+
+	assertFileContents(t, quarantinedPath, "<html></html>")
+}
+
+func TestWatcherQuarantinesInvalidDownloads(t *testing.T) {
+	filtersDirectory, downloadsDirectory := t.TempDir(), t.TempDir()
+	source := newFakeEventSource()
+	events := newRecordingEmitter()
+
+	watcher := newTestWatcher(t, source, events, filtersDirectory, downloadsDirectory)
+	watcher.engine.config.Set(configKeyFiltersOverwriteStrategy, string(OverwriteSelectedFile))
+	watcher.engine.config.Set(configKeyFiltersSelectedFile, "installed.filter")
+
+	installed := filepath.Join(filtersDirectory, "installed.filter")
+	writeTestFile(t, installed, "Show\n\tRarity Unique\n")
+
+	watcher.Start()
+	defer watcher.Stop()
+
+	watcher.SetFiltersDirectory(filtersDirectory)
+	watcher.SetDownloadsDirectory(downloadsDirectory)
+
+	contents := "<!DOCTYPE html>\n<html><body>Too Many Requests</body></html>\n"
+	download := filepath.Join(downloadsDirectory, "NeverSink.filter")
+	writeTestFile(t, download, contents)
+	source.Send(fsnotify.Event{Name: download, Op: fsnotify.Create})
+
+	quarantined := events.WaitFor(t, eventDownloadQuarantined).(DownloadQuarantinedEvent)
+	if quarantined.DownloadedFile != "NeverSink.filter" || len(quarantined.Issues) != 1 || quarantined.Issues[0].Check != ValidationHTML {
+		t.Fatalf("got %+v", quarantined)
+	}
+
+	if filepath.Dir(quarantined.QuarantinedPath) != filepath.Join(downloadsDirectory, quarantineDirectoryName) {
+		t.Fatalf("quarantined to %s", quarantined.QuarantinedPath)
+	}
+
+	assertFileContents(t, quarantined.QuarantinedPath, contents)
+	assertFileContents(t, installed, "Show\n\tRarity Unique\n")
+
+	if fileExists(download) {
+		t.Fatal("download is still where it was")
+	}
+
+	if count := events.Count(eventReplacementSucceeded); count != 0 {
+		t.Fatalf("replaced the filter %d times", count)
+	}
+
+	if entries, err := os.ReadDir(filtersDirectory); err != nil || len(entries) != 1 {
+		t.Fatalf("filters directory has %v, %v; want just the installed filter", entries, err)
+	}
+}
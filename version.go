@@ -0,0 +1,24 @@
+package main
+
+import "fmt"
+
+// build tags will populate this
+var (
+	gitCommit  string
+	versionTag string
+	buildType  string
+)
+
+// buildVersionString returns a human-readable version, or the empty string if build tags weren't provided
+func buildVersionString() string {
+	if buildType == "" || (versionTag == "" && gitCommit == "") {
+		return ""
+	}
+
+	identifier := gitCommit
+	if versionTag != "" {
+		identifier = versionTag
+	}
+
+	return fmt.Sprintf("Version %s-%s", buildType, identifier)
+}
@@ -1,74 +1,108 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/omriharel/filtersnatch/filter"
 	"github.com/pkg/errors"
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
-const (
-	internalEmitCooldown      = time.Millisecond * 500
-	internalFlushWaitDuration = time.Millisecond * 300
-
-	downloadTimeout = time.Second * 2
-)
+// directories can disappear without the event source saying anything, so they're checked on every tick of this
+const directoryHealthCheckInterval = time.Second * 2
 
 type Watcher struct {
-	watcher *fsnotify.Watcher
-	app     *App
+	source EventSource
+	engine *Engine
 
-	stopChannel chan bool
+	stopChannel chan struct{}
+	loopDone    chan struct{}
 
-	filtersDirectory   string
-	downloadsDirectory string
+	// the directories are set from UI bindings while the event loop reads them, so they're only
+	// accessed under directoriesLock (see currentFiltersDirectory and currentDownloadsDirectory)
+	directoriesLock    sync.RWMutex
+	filtersDirectory   watchedDirectory
+	downloadsDirectory watchedDirectory
 
 	dryRun bool
 
-	perEventLastEmitTime map[string]time.Time
-	pendingDownloads     map[string]time.Time
+	// only ever touched by the event loop goroutine, so they need no locking
+	downloads *downloadDetector
+
+	// file change events are coalesced into as few UI refreshes as possible, without blocking the event loop
+	coalescer *eventCoalescer
+
+	// guards what the UI and status may read from other goroutines
+	stateLock            sync.Mutex
+	running              bool
+	pendingDownloadCount int
 }
 
-func NewWatcher(app *App) (*Watcher, error) {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, err
+func NewWatcher(engine *Engine, source EventSource) (*Watcher, error) {
+	if source == nil {
+		return nil, errors.New("no event source")
 	}
 
 	return &Watcher{
-		watcher:              watcher,
-		app:                  app,
-		dryRun:               false,
-		perEventLastEmitTime: make(map[string]time.Time),
-		pendingDownloads:     make(map[string]time.Time),
+		source:             source,
+		engine:             engine,
+		filtersDirectory:   watchedDirectory{kind: FileChangeFilters},
+		downloadsDirectory: watchedDirectory{kind: FileChangeDownloads},
+		dryRun:             false,
+		downloads:          newDownloadDetector(".filter", archiveExtension),
 	}, nil
 }
 
 func (w *Watcher) Start() {
-	w.stopChannel = make(chan bool)
+	w.stopChannel = make(chan struct{})
+	w.loopDone = make(chan struct{})
+	w.coalescer = newEventCoalescer(w.engine.events, coalesceQuietPeriod, coalesceMaxDelay)
+	w.setRunning(true)
 
 	go func() {
+		defer close(w.loopDone)
+
+		pollTicker := time.NewTicker(downloadPollInterval)
+		defer pollTicker.Stop()
+
+		healthTicker := time.NewTicker(directoryHealthCheckInterval)
+		defer healthTicker.Stop()
+
 		for {
 			select {
-			case event, ok := <-w.watcher.Events:
+			case event, ok := <-w.source.Events():
 				if !ok {
 					return
 				}
 
+				// a watched directory going away takes its watch with it, so there's no point waiting for the next check
+				if w.isWatchedDirectoryEvent(&event) {
+					w.checkDirectoryHealth()
+					continue
+				}
+
 				if w.shouldHandleEvent(&event) {
 					if err := w.handleEvent(&event); err != nil {
-						runtime.LogErrorf(w.app.ctx, "Failed to handle file watcher event: %w", err)
+						w.engine.log.Errorf("Failed to handle file watcher event: %v", err)
 					}
 				}
 
-			case err, ok := <-w.watcher.Errors:
+			case err, ok := <-w.source.Errors():
 				if !ok {
 					return
 				}
-				runtime.LogErrorf(w.app.ctx, "Got error from file watcher: %w", err)
+				w.engine.log.Errorf("Got error from file watcher: %v", err)
+
+			case <-pollTicker.C:
+				w.checkPendingDownloads()
+
+			case <-healthTicker.C:
+				w.checkDirectoryHealth()
 
 			case <-w.stopChannel:
 				return
@@ -77,58 +111,85 @@ func (w *Watcher) Start() {
 	}()
 }
 
+// Stop ends the event loop, waiting for whatever it's in the middle of (like a replacement) to finish
 func (w *Watcher) Stop() error {
-	runtime.LogInfo(w.app.ctx, "Stopping file watcher")
-	w.stopChannel <- true
+	w.engine.log.Info("Stopping file watcher")
+
+	if w.stopChannel != nil {
+		close(w.stopChannel)
+		<-w.loopDone
+		w.stopChannel = nil
+
+		// the loop is done adding changes, so whatever it added last still gets sent
+		w.coalescer.Stop()
+	}
 
-	if err := w.watcher.Close(); err != nil {
-		runtime.LogErrorf(w.app.ctx, "Failed to stop file watcher: %w", err)
+	w.setRunning(false)
+
+	if err := w.source.Close(); err != nil {
+		w.engine.log.Errorf("Failed to stop file watcher: %v", err)
 		return err
 	}
 
-	runtime.LogDebug(w.app.ctx, "Stopped file watcher")
+	w.engine.log.Debug("Stopped file watcher")
 	return nil
 }
 
+// SetFiltersDirectory moves the filters directory watch to the given directory. Safe to call from any goroutine
 func (w *Watcher) SetFiltersDirectory(directory string) {
-	if w.filtersDirectory == directory {
-		runtime.LogDebug(w.app.ctx, "Filters directory unchanged")
-		return
-	}
+	w.setDirectory(&w.filtersDirectory, directory)
+}
 
-	if w.filtersDirectory != "" {
-		runtime.LogDebugf(w.app.ctx, "Removing watch on previous filters directory %s", w.filtersDirectory)
-		w.watcher.Remove(w.filtersDirectory)
-	}
+// SetDownloadsDirectory moves the downloads directory watch to the given directory. Safe to call from any goroutine
+func (w *Watcher) SetDownloadsDirectory(directory string) {
+	w.setDirectory(&w.downloadsDirectory, directory)
+}
+
+func (w *Watcher) IsRunning() bool {
+	w.stateLock.Lock()
+	defer w.stateLock.Unlock()
 
-	runtime.LogDebugf(w.app.ctx, "Now watching filters directory %s", directory)
-	w.watcher.Add(directory)
-	w.filtersDirectory = directory
+	return w.running
 }
 
-func (w *Watcher) SetDownloadsDirectory(directory string) {
-	if w.downloadsDirectory == directory {
-		runtime.LogDebug(w.app.ctx, "Downloads directory unchanged")
-		return
-	}
+func (w *Watcher) PendingDownloadCount() int {
+	w.stateLock.Lock()
+	defer w.stateLock.Unlock()
 
-	if w.downloadsDirectory != "" {
-		runtime.LogDebugf(w.app.ctx, "Removing watch on previous downloads directory %s", w.downloadsDirectory)
-		w.watcher.Remove(w.downloadsDirectory)
-	}
+	return w.pendingDownloadCount
+}
+
+func (w *Watcher) setRunning(running bool) {
+	w.stateLock.Lock()
+	w.running = running
+	w.stateLock.Unlock()
 
-	runtime.LogDebugf(w.app.ctx, "Now watching downloads directory %s", directory)
-	w.watcher.Add(directory)
-	w.downloadsDirectory = directory
+	w.engine.refreshStatus()
+}
+
+// syncPendingDownloadCount publishes the detector's pending count for other goroutines to read
+func (w *Watcher) syncPendingDownloadCount() {
+	count := w.downloads.PendingCount()
+
+	w.stateLock.Lock()
+	changed := w.pendingDownloadCount != count
+	w.pendingDownloadCount = count
+	w.stateLock.Unlock()
+
+	if changed {
+		w.engine.refreshStatus()
+	}
 }
 
 func (w *Watcher) shouldHandleEvent(event *fsnotify.Event) bool {
-	if w.app.Paused {
+	filtersDirectory, downloadsDirectory := w.currentFiltersDirectory(), w.currentDownloadsDirectory()
+	if downloadsDirectory == "" || filtersDirectory == "" {
 		return false
 	}
 
-	if w.downloadsDirectory == "" || w.filtersDirectory == "" {
-		return false
+	// partial downloads matter too, since browsers rename them into their final .filter (or .zip) name when done
+	if strings.HasPrefix(event.Name, downloadsDirectory) {
+		return w.downloads.Relevant(event.Name)
 	}
 
 	if strings.ToLower(filepath.Ext(event.Name)) != ".filter" {
@@ -139,12 +200,12 @@ func (w *Watcher) shouldHandleEvent(event *fsnotify.Event) bool {
 }
 
 func (w *Watcher) handleEvent(event *fsnotify.Event) error {
-	eventInFiltersDirectory := strings.HasPrefix(event.Name, w.filtersDirectory)
-	eventInDownloadsDirectory := strings.HasPrefix(event.Name, w.downloadsDirectory)
+	eventInFiltersDirectory := strings.HasPrefix(event.Name, w.currentFiltersDirectory())
+	eventInDownloadsDirectory := strings.HasPrefix(event.Name, w.currentDownloadsDirectory())
 
 	if eventInFiltersDirectory {
-		runtime.LogDebugf(w.app.ctx, "File watcher event in filters directory: %s (%s)", filepath.Base(event.Name), event.Op)
-		w.emitWatchEventTriggered()
+		w.engine.log.Debugf("File watcher event in filters directory: %s (%s)", filepath.Base(event.Name), event.Op)
+		w.emitWatchEventTriggered(FileChangeFilters, event.Name, event.Op.String())
 		return nil
 	}
 
@@ -152,109 +213,662 @@ func (w *Watcher) handleEvent(event *fsnotify.Event) error {
 		return errors.Errorf("Event doesn't seem to be in filters or downloads directory: %s", event)
 	}
 
-	now := time.Now()
+	// completion is decided later, once the download settles (see checkPendingDownloads)
+	if w.downloads.Observe(*event) {
+		finalName, _ := splitPartialDownloadName(event.Name)
 
-	// if this is a new file, that means a download has been started - store time and wait for further events
-	if event.Op&fsnotify.Create == fsnotify.Create {
-		runtime.LogDebugf(w.app.ctx, "Detected new filter download: %s", filepath.Base(event.Name))
-		w.pendingDownloads[event.Name] = now
-		return nil
+		w.engine.log.Debugf("Detected new filter download: %s", filepath.Base(finalName))
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryDownloadDetected,
+			DownloadedFile: filepath.Base(finalName),
+		})
 	}
 
-	// if this is a modify event, that means a download has been started - store time and wait for further events
-	if event.Op&fsnotify.Write == fsnotify.Write {
-		downloadStartTime, pendingDownload := w.pendingDownloads[event.Name]
-		if !pendingDownload {
-			runtime.LogTracef(w.app.ctx, "Modify event for a file we're probably done downloading: %s", filepath.Base(event.Name))
-			return nil
-		}
+	w.syncPendingDownloadCount()
+
+	// if another non-create, non-modify event happened, we should give the app a chance to know
+	if event.Op&fsnotify.Remove == fsnotify.Remove ||
+		event.Op&fsnotify.Rename == fsnotify.Rename ||
+		event.Op&fsnotify.Chmod == fsnotify.Chmod {
+
+		w.engine.log.Tracef("Other watch-event-trigger-worthy file operation: %s (%s)", filepath.Base(event.Name), event.Op)
+		w.emitWatchEventTriggered(FileChangeDownloads, event.Name, event.Op.String())
+	}
+
+	return nil
+}
 
-		if now.Sub(downloadStartTime) > downloadTimeout {
-			runtime.LogDebugf(w.app.ctx, "Modify event after download timeout exceeded, ignoring: %s", filepath.Base(event.Name))
-			delete(w.pendingDownloads, event.Name)
-			return nil
+// checkPendingDownloads replaces the filter file for every download that completed since the last check
+func (w *Watcher) checkPendingDownloads() {
+	if w.downloads.PendingCount() == 0 {
+		return
+	}
+
+	completed, abandoned := w.downloads.Poll()
+	w.syncPendingDownloadCount()
+
+	for _, name := range abandoned {
+		w.engine.log.Debugf("Download never completed, no longer tracking it: %s", filepath.Base(name))
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryDownloadSkipped,
+			DownloadedFile: filepath.Base(name),
+			Reason:         "download never completed",
+		})
+	}
+
+	for _, download := range completed {
+		w.engine.log.Debugf("Download completed: %s (time since start: %s)", filepath.Base(download.Path), download.Duration)
+		w.emitWatchEventTriggered(FileChangeDownloads, download.Path, fsnotify.Create.String())
+
+		if w.engine.IsPaused() {
+			w.engine.log.Debugf("Paused, not replacing filter file with %s", filepath.Base(download.Path))
+			w.engine.report(HistoryEntry{
+				Kind:           HistoryDownloadSkipped,
+				DownloadedFile: filepath.Base(download.Path),
+				Reason:         "paused",
+			})
+			continue
 		}
 
-		runtime.LogDebugf(w.app.ctx, "Download completed: %s (time since start: %s)", filepath.Base(event.Name), now.Sub(downloadStartTime))
-		delete(w.pendingDownloads, event.Name)
+		if isArchive(download.Path) {
+			if err := w.replaceFromArchive(download.Path); err != nil {
+				w.engine.log.Errorf("Failed to replace filter files from archive: %s", err)
+			}
+			continue
+		}
 
-		w.emitWatchEventTriggered()
-		err := w.replaceFilterFileIfNeeded(event.Name)
-		if err != nil {
-			runtime.LogErrorf(w.app.ctx, "Failed to replace filter file: %s", err)
+		if err := w.replaceFilterFileIfNeeded(download.Path, nil); err != nil {
+			w.engine.log.Errorf("Failed to replace filter file: %s", err)
 		}
+	}
+}
 
-		// if another non-create, non-modify event happened, we should give the app a chance to know
-	} else if event.Op&fsnotify.Remove == fsnotify.Remove ||
-		event.Op&fsnotify.Rename == fsnotify.Rename ||
-		event.Op&fsnotify.Chmod == fsnotify.Chmod {
+// replaceFilterFileIfNeeded replaces the filter file a downloaded filter is meant for, according to the
+// replacement rules. archive is the archive the filter was extracted from, or nil if it was downloaded by itself
+func (w *Watcher) replaceFilterFileIfNeeded(downloadPath string, archive *archiveDownload) error {
+	downloadedFileName := filepath.Base(downloadPath)
+
+	rules, err := w.engine.replacementRules()
+	if err != nil {
+		w.engine.log.Errorf("Failed to get replacement rules from config: %v", err)
+		return err
+	}
 
-		runtime.LogTracef(w.app.ctx, "Other watch-event-trigger-worthy file operation: %s (%s)", filepath.Base(event.Name), event.Op)
-		w.emitWatchEventTriggered()
+	if len(rules) == 0 {
+		w.engine.log.Debug("No filter file to replace selected, doing nothing")
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryDownloadSkipped,
+			DownloadedFile: downloadedFileName,
+			Archive:        archive.fileName(),
+			Reason:         "no filter file to replace selected",
+		})
 		return nil
 	}
 
+	downloadMetadata := lazyFilterMetadata(downloadPath)
+
+	rule, matched, err := matchRule(rules, downloadedFileName, downloadMetadata)
+	if err != nil {
+		w.engine.log.Errorf("Failed to match downloaded file against replacement rules: %v", err)
+		return err
+	}
+
+	if !matched {
+		w.engine.log.Debugf("Downloaded file doesn't match any replacement rule: %s", downloadedFileName)
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryDownloadSkipped,
+			DownloadedFile: downloadedFileName,
+			Archive:        archive.fileName(),
+			Reason:         "doesn't match any replacement rule",
+		})
+		return nil
+	}
+
+	w.engine.log.Debugf("Downloaded file %s matches replacement rule %s", downloadedFileName, rule)
+
+	// rules were validated when they were read, so this can't fail
+	overwriteStrategy, _ := parseOverwriteStrategy(rule.OverwriteStrategy)
+
+	issues, err := w.validateDownload(downloadPath)
+	if err != nil {
+		w.engine.log.Errorf("Failed to validate downloaded filter file: %v", err)
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryReplacementFailed,
+			DownloadedFile: downloadedFileName,
+			Archive:        archive.fileName(),
+			Rule:           rule.displayName(),
+			TargetFile:     rule.Target,
+			Error:          err.Error(),
+		})
+		return err
+	}
+
+	if len(blockingIssues(issues)) > 0 {
+		w.quarantineDownload(downloadPath, archive, issues)
+		return nil
+	}
+
+	targetFileName := rule.Target
+	if overwriteStrategy == OverwriteNamedFile {
+		targetFileName, err = expandTargetTemplate(rule.Target, targetTemplateData{
+			downloadedFile: downloadedFileName,
+			metadata:       downloadMetadata,
+			now:            time.Now(),
+		})
+	}
+
+	if err == nil {
+		targetFileName, err = w.resolveTargetFile(overwriteStrategy, targetFileName)
+	}
+
+	if err != nil {
+		w.engine.log.Errorf("Failed to resolve target filter file: %v", err)
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryReplacementFailed,
+			DownloadedFile: downloadedFileName,
+			Archive:        archive.fileName(),
+			Rule:           rule.displayName(),
+			TargetFile:     rule.Target,
+			Error:          err.Error(),
+		})
+		return err
+	}
+
+	if replacedBy, ok := archive.replacedTarget(targetFileName); ok {
+		w.engine.log.Infof("Not replacing %s with %s, %s from the same archive already replaced it", targetFileName, downloadedFileName, replacedBy)
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryDownloadSkipped,
+			DownloadedFile: downloadedFileName,
+			Archive:        archive.fileName(),
+			Rule:           rule.displayName(),
+			TargetFile:     targetFileName,
+			Reason:         fmt.Sprintf("%s from the same archive already replaced it", replacedBy),
+		})
+		return nil
+	}
+
+	if reason, downgrade := w.checkDowngrade(downloadPath, targetFileName); downgrade {
+		w.engine.log.Warningf("Not replacing %s with %s: %s", targetFileName, downloadedFileName, reason)
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryDownloadSkipped,
+			DownloadedFile: downloadedFileName,
+			Archive:        archive.fileName(),
+			Rule:           rule.displayName(),
+			TargetFile:     targetFileName,
+			Reason:         reason,
+		})
+		return nil
+	}
+
+	installPath, overlayReport, err := w.applyOverlay(downloadPath, targetFileName)
+	if err != nil {
+		w.engine.log.Errorf("Failed to apply overlay, not replacing filter file: %v", err)
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryReplacementFailed,
+			DownloadedFile: downloadedFileName,
+			Archive:        archive.fileName(),
+			Rule:           rule.displayName(),
+			TargetFile:     targetFileName,
+			Error:          err.Error(),
+		})
+		return err
+	}
+
+	if overlayReport != nil {
+		defer os.Remove(installPath)
+	}
+
+	diff := w.diffAgainstInstalled(installPath, targetFileName)
+
+	receipt, err := w.performActualReplacement(downloadPath, installPath, targetFileName)
+	if err != nil {
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryReplacementFailed,
+			DownloadedFile: downloadedFileName,
+			Archive:        archive.fileName(),
+			Rule:           rule.displayName(),
+			TargetFile:     targetFileName,
+			Error:          err.Error(),
+			Diff:           diff,
+			Overlay:        overlayReport,
+		})
+		return err
+	}
+
+	archive.recordReplacement(targetFileName, downloadedFileName)
+	w.installSounds(archive.takeSounds())
+
+	sounds := w.checkSounds(installPath)
+	lint := w.lintInstalledFilter(installPath)
+
+	w.engine.report(HistoryEntry{
+		Kind:           HistoryReplacement,
+		DownloadedFile: downloadedFileName,
+		Archive:        archive.fileName(),
+		Rule:           rule.displayName(),
+		TargetFile:     targetFileName,
+		Receipt:        receipt,
+		Validation:     issues,
+		Diff:           diff,
+		Overlay:        overlayReport,
+		Sounds:         sounds,
+		Lint:           truncateLintIssues(lint, historyMaxLintIssues),
+	})
 	return nil
+}
+
+// replaceFromArchive extracts a downloaded archive, and handles every filter in it as if it was downloaded by
+// itself. The archive's sounds are put next to the filters once any of them replaces something
+func (w *Watcher) replaceFromArchive(archivePath string) error {
+	archiveName := filepath.Base(archivePath)
 
+	extracted, err := extractArchive(archivePath, filepath.Join(w.currentDownloadsDirectory(), extractedDirectoryName))
+	if err != nil {
+		w.engine.log.Errorf("Failed to extract downloaded archive %s: %v", archiveName, err)
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryReplacementFailed,
+			DownloadedFile: archiveName,
+			Error:          err.Error(),
+		})
+		return err
+	}
+	defer os.RemoveAll(extracted.directory)
+
+	if len(extracted.filters) == 0 {
+		w.engine.log.Debugf("Downloaded archive has no filters in it: %s", archiveName)
+		w.engine.report(HistoryEntry{
+			Kind:           HistoryDownloadSkipped,
+			DownloadedFile: archiveName,
+			Reason:         "archive has no filters in it",
+		})
+		return nil
+	}
+
+	w.engine.log.Infof("Extracted %d filters and %d sounds from %s", len(extracted.filters), len(extracted.sounds), archiveName)
+	for _, filterPath := range extracted.filters {
+		w.engine.log.Debugf("Found filter in archive: %s", filepath.Base(filterPath))
+	}
+
+	archive := newArchiveDownload(archiveName, extracted.sounds)
+	for _, filterPath := range extracted.filters {
+		if err := w.replaceFilterFileIfNeeded(filterPath, archive); err != nil {
+			w.engine.log.Errorf("Failed to replace filter file from archive: %s", err)
+		}
+	}
+
+	return nil
 }
 
-func (w *Watcher) replaceFilterFileIfNeeded(downloadedFile string) error {
-	downloadsWatchStrategy, ok := parseWatchStrategy(w.app.config.GetString(configKeyDownloadsWatchStrategy))
-	if !ok {
-		runtime.LogErrorf(w.app.ctx, "Failed to get downloads watch strategy from config")
-		return errors.New("get downloads watch strategy from config")
+// installSounds copies sounds extracted from an archive into the filters directory, overwriting older copies
+func (w *Watcher) installSounds(sounds []extractedSound) {
+	if len(sounds) == 0 {
+		return
 	}
 
-	filtersTargetFile := w.app.config.GetString(configKeyFiltersSelectedFile)
-	if filtersTargetFile == "" {
-		runtime.LogDebug(w.app.ctx, "No filter file to replace selected, doing nothing")
+	if w.dryRun {
+		w.engine.log.Debug("Dry run, not actually installing sound files")
+		return
+	}
+
+	filtersDirectory := w.currentFiltersDirectory()
+	installed := 0
+
+	for _, sound := range sounds {
+		targetPath, err := resolvePathUnderDirectory(filtersDirectory, sound.relativePath)
+		if err != nil {
+			w.engine.log.Warningf("Not installing sound file %s: %v", sound.relativePath, err)
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			w.engine.log.Warningf("Failed to create directory for sound file %s: %v", sound.relativePath, err)
+			continue
+		}
+
+		if err := copyFileContents(sound.path, targetPath); err != nil {
+			w.engine.log.Warningf("Failed to install sound file %s: %v", sound.relativePath, err)
+			continue
+		}
+
+		installed++
+	}
+
+	if installed > 0 {
+		w.engine.log.Infof("Installed %d sound files into the filters directory", installed)
+	}
+}
+
+// checkSounds lists the custom sounds a newly installed filter plays, and warns about the ones missing from the
+// filters directory. If enabled, missing sounds found in the downloads directory are copied over
+func (w *Watcher) checkSounds(filterPath string) []SoundAsset {
+	filtersDirectory, downloadsDirectory := w.currentFiltersDirectory(), w.currentDownloadsDirectory()
+
+	sounds, err := checkSoundAssets(filterPath, filtersDirectory)
+	if err != nil {
+		w.engine.log.Warningf("Failed to check the filter's custom sounds: %v", err)
 		return nil
 	}
 
-	downloadedFileName := filepath.Base(downloadedFile)
-	downloadsNamedFile := w.app.config.GetString(configKeyDownloadsNamedFile)
+	copyFromDownloads := w.engine.config.GetBool(configKeyAssetsCopyFromDownloads)
+	missing := 0
+
+	for idx := range sounds {
+		sound := &sounds[idx]
+		if sound.Exists {
+			continue
+		}
+
+		if copyFromDownloads {
+			if sourcePath, ok := findSoundInDirectory(downloadsDirectory, sound.Path); ok {
+				if w.dryRun {
+					w.engine.log.Debugf("Dry run, not actually copying sound file %s", filepath.Base(sourcePath))
+				} else if err := copyMissingSound(filtersDirectory, sound.Path, sourcePath); err != nil {
+					w.engine.log.Warningf("Failed to copy sound file %s from downloads: %v", filepath.Base(sourcePath), err)
+				} else {
+					w.engine.log.Infof("Copied missing sound file %s from downloads", sound.Path)
+					sound.Exists = true
+					sound.CopiedFrom = sourcePath
+					continue
+				}
+			}
+		}
+
+		missing++
+		if sound.Optional {
+			w.engine.log.Infof("Filter plays optional sound %s (line %d), which isn't in the filters directory", sound.Path, sound.Line)
+		} else {
+			w.engine.log.Warningf("Filter plays sound %s (line %d), which isn't in the filters directory", sound.Path, sound.Line)
+		}
+	}
+
+	if len(sounds) > 0 {
+		w.engine.log.Infof("Filter plays %d custom sounds, %d of them missing", len(sounds), missing)
+	}
+
+	return sounds
+}
 
-	if downloadsWatchStrategy == WatchNamedFile && !lowerFileNamesEqual(downloadedFileName, downloadsNamedFile) {
-		runtime.LogDebugf(w.app.ctx, "Downloaded file name doesn't match exact watched file name: %s != %s", downloadedFileName, downloadsNamedFile)
+// lintInstalledFilter looks for likely mistakes in a newly installed filter. They're only warned about, since
+// the game loads the filter anyway
+func (w *Watcher) lintInstalledFilter(filterPath string) []filter.LintIssue {
+	issues, err := lintFilterFile(filterPath)
+	if err != nil {
+		w.engine.log.Warningf("Failed to lint the filter: %v", err)
 		return nil
 	}
 
-	return w.performActualReplacement(downloadedFileName, filtersTargetFile)
+	if len(issues) == 0 {
+		w.engine.log.Debugf("Filter has no lint warnings")
+		return issues
+	}
+
+	w.engine.log.Warningf("Filter has %d lint warnings", len(issues))
+	for _, issue := range truncateLintIssues(issues, historyMaxLintIssues) {
+		w.engine.log.Debugf("Lint warning (%s) at %s", issue.Check, issue)
+	}
+
+	return issues
 }
 
-func (w *Watcher) performActualReplacement(downloadedFile, targetFile string) error {
-	runtime.LogInfof(w.app.ctx, "Replacing filter file: %s -> %s", downloadedFile, targetFile)
+// checkDowngrade tells whether the download is an older version of the installed filter, unless downgrades
+// are allowed. Files without versions in their headers are never considered downgrades
+func (w *Watcher) checkDowngrade(downloadPath, targetFile string) (string, bool) {
+	if w.engine.config.GetBool(configKeyFiltersAllowDowngrade) {
+		return "", false
+	}
+
+	targetPath := filepath.Join(w.currentFiltersDirectory(), targetFile)
+	if !fileExists(targetPath) {
+		return "", false
+	}
+
+	installed, err := readFilterMetadata(targetPath)
+	if err != nil {
+		w.engine.log.Warningf("Failed to read installed filter's metadata: %v", err)
+		return "", false
+	}
+
+	incoming, err := readFilterMetadata(downloadPath)
+	if err != nil {
+		w.engine.log.Warningf("Failed to read downloaded filter's metadata: %v", err)
+		return "", false
+	}
+
+	w.engine.log.Debugf("Installed filter: %+v, downloaded filter: %+v", installed, incoming)
+	return describeDowngrade(installed, incoming)
+}
+
+// applyOverlay merges the target's overlay (if it has one) into the download.
+// Returns the path of the file to install, and a report of the merge (nil if there was no overlay)
+func (w *Watcher) applyOverlay(downloadPath, targetFile string) (string, *filter.OverlayReport, error) {
+	overlayPath := overlayPathFor(w.currentFiltersDirectory(), targetFile)
+
+	installPath, report, err := mergeOverlay(downloadPath, overlayPath)
+	if err != nil || report == nil {
+		return installPath, report, err
+	}
+
+	w.engine.log.Infof("Applied overlay %s: %d blocks prepended, %d appended, %d patched",
+		filepath.Base(overlayPath), report.Prepended, report.Appended, report.Patched)
+
+	for _, conflict := range report.Conflicts {
+		w.engine.log.Warningf("Overlay conflict: %s", conflict)
+	}
+
+	return installPath, report, nil
+}
+
+// diffAgainstInstalled compares the filter that's about to be replaced with the file replacing it.
+// Returns nil if there's nothing installed yet, or if they couldn't be compared
+func (w *Watcher) diffAgainstInstalled(installPath, targetFile string) *filter.Diff {
+	targetPath := filepath.Join(w.currentFiltersDirectory(), targetFile)
+	if !fileExists(targetPath) {
+		return nil
+	}
+
+	diff, err := diffFilterFiles(targetPath, installPath)
+	if err != nil {
+		w.engine.log.Warningf("Failed to diff installed filter with downloaded one: %v", err)
+		return nil
+	}
+
+	w.engine.log.Infof("Changes in %s: %s", targetFile, diff.Summary())
+	return diff.Truncate(historyMaxDiffEntries)
+}
+
+// validateDownload checks a downloaded filter according to the configured validation mode,
+// and logs whatever it finds
+func (w *Watcher) validateDownload(downloadPath string) ([]ValidationIssue, error) {
+	mode, ok := parseValidationMode(w.engine.config.GetString(configKeyValidationMode))
+	if !ok {
+		w.engine.log.Warningf("Unknown validation mode '%s' in config, using %s", w.engine.config.GetString(configKeyValidationMode), ValidationLenient)
+		mode = ValidationLenient
+	}
+
+	issues, err := validateFilterFile(downloadPath, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadedFile := filepath.Base(downloadPath)
+
+	for _, issue := range issues {
+		if issue.Blocking {
+			w.engine.log.Warningf("Downloaded filter %s is invalid: %s", downloadedFile, issue)
+		} else {
+			w.engine.log.Infof("Downloaded filter %s has a problem: %s", downloadedFile, issue)
+		}
+	}
+
+	return issues, nil
+}
+
+// quarantineDownload moves an invalid download out of the way instead of installing it, and reports why
+func (w *Watcher) quarantineDownload(downloadPath string, archive *archiveDownload, issues []ValidationIssue) {
+	downloadedFile := filepath.Base(downloadPath)
+	reason := summarizeIssues(blockingIssues(issues))
+	quarantinedPath := ""
 
 	if !w.dryRun {
-		if err := copyFileContents(filepath.Join(w.downloadsDirectory, downloadedFile), filepath.Join(w.filtersDirectory, targetFile)); err != nil {
-			runtime.LogErrorf(w.app.ctx, "Failed to replace filter file: %s", err)
-			return err
+		var err error
+		quarantinedPath, err = quarantineFile(w.currentDownloadsDirectory(), downloadPath)
+		if err != nil {
+			w.engine.log.Errorf("Failed to quarantine invalid filter file %s: %v", downloadedFile, err)
+		} else {
+			w.engine.log.Infof("Quarantined invalid filter file %s to %s", downloadedFile, quarantinedPath)
 		}
 	} else {
-		runtime.LogDebug(w.app.ctx, "Dry run, not actually replacing filter file")
+		w.engine.log.Debug("Dry run, not actually quarantining filter file")
 	}
 
-	runtime.LogDebugf(w.app.ctx, "Successfully replaced filter file: %s -> %s", downloadedFile, targetFile)
-	w.emitFilterFileReplaced()
-	return nil
+	w.engine.report(HistoryEntry{
+		Kind:           HistoryDownloadQuarantined,
+		DownloadedFile: downloadedFile,
+		Archive:        archive.fileName(),
+		Reason:         reason,
+		Validation:     issues,
+	})
+
+	w.engine.emit(DownloadQuarantinedEvent{
+		DownloadedFile:  downloadedFile,
+		QuarantinedPath: quarantinedPath,
+		Reason:          reason,
+		Issues:          issues,
+	})
 }
 
-func (w *Watcher) emitWatchEventTriggered() {
-	w.emitEvent(eventWatchEventTriggered)
+// resolveTargetFile figures out which file in the filters directory should be overwritten,
+// according to the given overwrite strategy. Returns the target's file name (not its full path)
+func (w *Watcher) resolveTargetFile(strategy OverwriteStrategy, fileName string) (string, error) {
+	if err := validateFilterFileName(fileName); err != nil {
+		return "", err
+	}
+
+	targetPath, err := resolvePathInDirectory(w.currentFiltersDirectory(), fileName)
+	if err != nil {
+		return "", err
+	}
+
+	switch strategy {
+
+	// the selected file was picked from the directory listing, so it has to still be there
+	case OverwriteSelectedFile:
+		if !fileExists(targetPath) {
+			return "", errors.Errorf("selected filter file %s no longer exists", fileName)
+		}
+
+	// the named file is whatever the user typed in - it's fine for it to not exist yet
+	case OverwriteNamedFile:
+		if !fileExists(targetPath) {
+			w.engine.log.Infof("Named filter file %s doesn't exist yet, it will be created", fileName)
+		}
+
+	default:
+		return "", errors.Errorf("unknown overwrite strategy: %s", strategy)
+	}
+
+	return filepath.Base(targetPath), nil
 }
 
-func (w *Watcher) emitFilterFileReplaced() {
-	w.emitEvent(eventFilterFileReplaced)
+// performActualReplacement copies the file at installPath (the download, or the download merged with
+// an overlay) over the target, and verifies the result. Returns a receipt of the replacement, or nil in dry runs
+func (w *Watcher) performActualReplacement(sourcePath, installPath, targetFile string) (*ReplacementReceipt, error) {
+	downloadedFile := filepath.Base(sourcePath)
+	w.engine.log.Infof("Replacing filter file: %s -> %s", downloadedFile, targetFile)
+
+	filtersDirectory := w.currentFiltersDirectory()
+	targetPath := filepath.Join(filtersDirectory, targetFile)
+
+	var receipt *ReplacementReceipt
+
+	if !w.dryRun {
+		sourceHash, sourceSize, err := hashFile(installPath)
+		if err != nil {
+			w.engine.log.Errorf("Failed to hash downloaded filter file: %v", err)
+			return nil, err
+		}
+
+		var backup BackupEntry
+		if w.engine.config.GetBool(configKeyBackupsEnabled) {
+			backup, err = w.engine.newBackupStore(filtersDirectory).Backup(targetFile)
+			if err != nil {
+				w.engine.log.Errorf("Failed to back up filter file, not replacing it: %v", err)
+				return nil, err
+			}
+
+			if backup.ID != "" {
+				w.engine.log.Debugf("Backed up %s (backup ID: %s)", targetFile, backup.ID)
+			}
+		}
+
+		if err := copyFileContents(installPath, targetPath); err != nil {
+			w.engine.log.Errorf("Failed to replace filter file: %s", err)
+			return nil, err
+		}
+
+		if err := w.verifyReplacement(targetPath, sourceHash, sourceSize); err != nil {
+			w.engine.log.Errorf("Replaced filter file doesn't match the downloaded one: %v", err)
+
+			if backup.ID != "" {
+				if restoreErr := w.engine.newBackupStore(filtersDirectory).Restore(targetFile, backup.ID); restoreErr != nil {
+					w.engine.log.Errorf("Failed to restore backup %s after bad replacement: %v", backup.ID, restoreErr)
+				} else {
+					w.engine.log.Warningf("Restored backup %s after bad replacement", backup.ID)
+				}
+			} else {
+				// with nothing to go back to, no filter is better than one the game may choke on
+				if removeErr := defaultFileSystem.Remove(targetPath); removeErr != nil {
+					w.engine.log.Errorf("Failed to remove bad replacement %s: %v", targetFile, removeErr)
+				} else {
+					w.engine.log.Warningf("Removed bad replacement %s, there was no backup to restore", targetFile)
+				}
+			}
+
+			return nil, err
+		}
+
+		newReceipt := newReplacementReceipt(sourcePath, targetPath, sourceSize, sourceHash)
+		receipt = &newReceipt
+
+		if w.engine.receipts != nil {
+			if err := w.engine.receipts.Record(newReceipt); err != nil {
+				w.engine.log.Warningf("Failed to record replacement receipt: %v", err)
+			}
+		}
+
+		w.engine.log.Debugf("Verified replaced filter file (%d bytes, sha256 %s)", receipt.Size, receipt.SHA256)
+	} else {
+		w.engine.log.Debug("Dry run, not actually replacing filter file")
+	}
+
+	w.engine.log.Debugf("Successfully replaced filter file: %s -> %s", downloadedFile, targetFile)
+	w.emitFilterFileReplaced(targetFile)
+	w.engine.refreshStatus()
+	return receipt, nil
 }
 
-func (w *Watcher) emitEvent(eventName string) {
-	now := time.Now()
+// verifyReplacement makes sure the file now at targetPath is exactly what we meant to put there
+func (w *Watcher) verifyReplacement(targetPath string, expectedHash string, expectedSize int64) error {
+	targetHash, targetSize, err := hashFile(targetPath)
+	if err != nil {
+		return errors.Wrap(err, "hash replaced filter file")
+	}
 
-	if lastEmitTime, ok := w.perEventLastEmitTime[eventName]; ok && now.Sub(lastEmitTime) < internalEmitCooldown {
-		return
+	if targetSize != expectedSize {
+		return errors.Errorf("size mismatch: expected %d bytes, got %d", expectedSize, targetSize)
 	}
 
-	w.perEventLastEmitTime[eventName] = now
-	<-time.After(internalFlushWaitDuration)
-	runtime.EventsEmit(w.app.ctx, eventName, nil)
+	if targetHash != expectedHash {
+		return errors.Errorf("sha256 mismatch: expected %s, got %s", expectedHash, targetHash)
+	}
+
+	return nil
+}
+
+func (w *Watcher) emitWatchEventTriggered(directory FileChangeDirectory, path string, op string) {
+	w.coalescer.Add(eventWatchEventTriggered, FileChange{Directory: directory, File: filepath.Base(path), Op: op})
+}
+
+func (w *Watcher) emitFilterFileReplaced(targetFile string) {
+	w.coalescer.Add(eventFilterFileReplaced, FileChange{Directory: FileChangeFilters, File: targetFile, Op: fsnotify.Write.String()})
 }
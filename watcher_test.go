@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// newTestEngine returns an engine with the default config, that logs nowhere and keeps no history or receipts
+func newTestEngine(t *testing.T, events EventEmitter) *Engine {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return &Engine{
+		ctx:    ctx,
+		config: newDefaultConfig(),
+		log:    newConsoleLogger(io.Discard, LogLevelError),
+		events: events,
+	}
+}
+
+// newTestWatcher returns a watcher (not started) whose engine watches filtersDirectory and downloadsDirectory
+func newTestWatcher(t *testing.T, source EventSource, events EventEmitter, filtersDirectory, downloadsDirectory string) *Watcher {
+	t.Helper()
+
+	engine := newTestEngine(t, events)
+	engine.config.Set(configKeyFiltersDirectory, filtersDirectory)
+	engine.config.Set(configKeyDownloadsDirectory, downloadsDirectory)
+
+	watcher, err := NewWatcher(engine, source)
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+
+	engine.watcher = watcher
+	watcher.filtersDirectory.path = filtersDirectory
+	watcher.downloadsDirectory.path = downloadsDirectory
+
+	return watcher
+}
+
+func writeTestFile(t *testing.T, path, contents string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveTargetFile(t *testing.T) {
+	filtersDirectory := t.TempDir()
+	outsideDirectory := t.TempDir()
+
+	writeTestFile(t, filepath.Join(filtersDirectory, "existing.filter"), "Show\n")
+	writeTestFile(t, filepath.Join(filtersDirectory, "sub", "nested.filter"), "Show\n")
+	writeTestFile(t, filepath.Join(outsideDirectory, "outside.filter"), "Show\n")
+
+	source := newPollingEventSource(time.Hour)
+	defer source.Close()
+
+	watcher := newTestWatcher(t, source, nopEmitter{}, filtersDirectory, t.TempDir())
+
+	tests := []struct {
+		name     string
+		strategy OverwriteStrategy
+		fileName string
+		want     string
+		wantErr  error
+	}{
+		{name: "selected file that exists", strategy: OverwriteSelectedFile, fileName: "existing.filter", want: "existing.filter"},
+		{name: "selected file that's gone", strategy: OverwriteSelectedFile, fileName: "missing.filter", wantErr: errAny},
+		{name: "named file that doesn't exist yet", strategy: OverwriteNamedFile, fileName: "new.filter", want: "new.filter"},
+		{name: "named file that exists", strategy: OverwriteNamedFile, fileName: "existing.filter", want: "existing.filter"},
+		{name: "unknown strategy", strategy: "whatever", fileName: "existing.filter", wantErr: errAny},
+
+		{name: "reserved name", strategy: OverwriteNamedFile, fileName: "CON.filter", wantErr: errInvalidFileName},
+		{name: "reserved name in lowercase", strategy: OverwriteNamedFile, fileName: "com1.filter", wantErr: errInvalidFileName},
+		{name: "reserved name with more dots", strategy: OverwriteNamedFile, fileName: "NUL.strict.filter", wantErr: errInvalidFileName},
+		{name: "empty name", strategy: OverwriteNamedFile, fileName: "", wantErr: errInvalidFileName},
+		{name: "blank name", strategy: OverwriteNamedFile, fileName: "   ", wantErr: errInvalidFileName},
+		{name: "missing extension", strategy: OverwriteNamedFile, fileName: "existing", wantErr: errInvalidFileName},
+		{name: "wrong extension", strategy: OverwriteNamedFile, fileName: "existing.txt", wantErr: errInvalidFileName},
+		{name: "only an extension", strategy: OverwriteNamedFile, fileName: ".filter", wantErr: errInvalidFileName},
+		{name: "trailing dot", strategy: OverwriteNamedFile, fileName: "existing.filter.", wantErr: errInvalidFileName},
+		{name: "surrounding whitespace", strategy: OverwriteNamedFile, fileName: " existing.filter", wantErr: errInvalidFileName},
+		{name: "illegal characters", strategy: OverwriteNamedFile, fileName: "a?b.filter", wantErr: errInvalidFileName},
+
+		{name: "parent directory", strategy: OverwriteNamedFile, fileName: "../outside.filter", wantErr: errInvalidFileName},
+		{name: "subdirectory", strategy: OverwriteSelectedFile, fileName: "sub/nested.filter", wantErr: errInvalidFileName},
+		{name: "subdirectory with backslash", strategy: OverwriteSelectedFile, fileName: `sub\nested.filter`, wantErr: errInvalidFileName},
+		{name: "absolute path", strategy: OverwriteSelectedFile, fileName: filepath.Join(outsideDirectory, "outside.filter"), wantErr: errInvalidFileName},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := watcher.resolveTargetFile(test.strategy, test.fileName)
+
+			if test.wantErr == nil {
+				if err != nil || got != test.want {
+					t.Fatalf("got %q, %v; want %q", got, err, test.want)
+				}
+
+				return
+			}
+
+			if err == nil || (test.wantErr != errAny && !errors.Is(err, test.wantErr)) {
+				t.Fatalf("got %q, %v; want error %v", got, err, test.wantErr)
+			}
+		})
+	}
+
+	// resolving a named file never creates it, that only happens when it's replaced
+	if fileExists(filepath.Join(filtersDirectory, "new.filter")) {
+		t.Fatal("resolving a named file created it")
+	}
+}
+
+func TestResolvePathInDirectory(t *testing.T) {
+	directory := t.TempDir()
+
+	for _, name := range []string{"../x.filter", "sub/x.filter", filepath.Join(t.TempDir(), "x.filter"), "..", "."} {
+		if resolved, err := resolvePathInDirectory(directory, name); !errors.Is(err, errPathEscapesDirectory) {
+			t.Errorf("%q: got %q, %v; want it refused", name, resolved, err)
+		}
+	}
+
+	resolved, err := resolvePathInDirectory(directory, "x.filter")
+	if err != nil || resolved != filepath.Join(directory, "x.filter") {
+		t.Errorf("got %q, %v; want %q", resolved, err, filepath.Join(directory, "x.filter"))
+	}
+
+	for _, name := range []string{"../x.filter", "sub/../../x.filter", filepath.Join(t.TempDir(), "x.filter"), "."} {
+		if resolved, err := resolvePathUnderDirectory(directory, name); !errors.Is(err, errPathEscapesDirectory) {
+			t.Errorf("under %q: got %q, %v; want it refused", name, resolved, err)
+		}
+	}
+
+	resolved, err = resolvePathUnderDirectory(directory, "sub/x.filter")
+	if err != nil || resolved != filepath.Join(directory, "sub", "x.filter") {
+		t.Errorf("under: got %q, %v; want %q", resolved, err, filepath.Join(directory, "sub", "x.filter"))
+	}
+}
+
+func TestWatcherDirectoryChangesWhileHandlingEvents(t *testing.T) {
+	filtersDirectories := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+	downloadsDirectories := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+	allDirectories := append(append([]string{}, filtersDirectories...), downloadsDirectories...)
+
+	source := newFakeEventSource()
+	watcher := newTestWatcher(t, source, newRecordingEmitter(), "", "")
+
+	watcher.Start()
+
+	var changers, senders sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		changers.Add(1)
+		go func(worker int) {
+			defer changers.Done()
+
+			for idx := 0; idx < 50; idx++ {
+				watcher.SetFiltersDirectory(filtersDirectories[(worker+idx)%len(filtersDirectories)])
+				watcher.SetDownloadsDirectory(downloadsDirectories[(worker*idx)%len(downloadsDirectories)])
+			}
+		}(worker)
+
+		senders.Add(1)
+		go func(worker int) {
+			defer senders.Done()
+
+			for idx := 0; idx < 200; idx++ {
+				directory := allDirectories[(worker+idx)%len(allDirectories)]
+				op := []fsnotify.Op{fsnotify.Create, fsnotify.Write, fsnotify.Remove}[idx%3]
+				source.Send(fsnotify.Event{Name: filepath.Join(directory, "NeverSink.filter"), Op: op})
+			}
+		}(worker)
+	}
+
+	changers.Wait()
+	senders.Wait()
+
+	// whatever order the changes landed in, only the last directories set are left watched
+	watcher.SetFiltersDirectory(filtersDirectories[0])
+	watcher.SetDownloadsDirectory(downloadsDirectories[0])
+
+	want := []string{filtersDirectories[0], downloadsDirectories[0]}
+	sort.Strings(want)
+
+	if watched := source.WatchedDirectories(); !reflect.DeepEqual(watched, want) {
+		t.Fatalf("watching %v, want %v", watched, want)
+	}
+
+	if err := watcher.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if watcher.IsRunning() {
+		t.Fatal("still running after Stop")
+	}
+}
+
+func TestWatcherRearmsDirectoryThatComesBack(t *testing.T) {
+	filtersDirectory, downloadsDirectory := t.TempDir(), t.TempDir()
+	source := newFakeEventSource()
+	events := newRecordingEmitter()
+
+	watcher := newTestWatcher(t, source, events, filtersDirectory, downloadsDirectory)
+	watcher.Start()
+	defer watcher.Stop()
+
+	watcher.SetFiltersDirectory(filtersDirectory)
+	watcher.SetDownloadsDirectory(downloadsDirectory)
+
+	if err := os.Remove(filtersDirectory); err != nil {
+		t.Fatal(err)
+	}
+	source.Send(fsnotify.Event{Name: filtersDirectory, Op: fsnotify.Remove})
+
+	missing := events.WaitFor(t, eventDirectoryMissing).(DirectoryMissing)
+	if missing != (DirectoryMissing{Directory: FileChangeFilters, Path: filtersDirectory}) {
+		t.Fatalf("got %+v", missing)
+	}
+
+	if source.Watching(filtersDirectory) {
+		t.Fatal("still watching the missing directory")
+	}
+
+	if status := watcher.engine.computeStatus(); status.FiltersDirectory.Exists || !status.DownloadsDirectory.Exists {
+		t.Fatalf("status says %+v and %+v", status.FiltersDirectory, status.DownloadsDirectory)
+	}
+
+	// nothing tells the watcher the directory is back, so it's up to the periodic check to notice
+	if err := os.Mkdir(filtersDirectory, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := events.WaitFor(t, eventDirectoryRestored).(DirectoryRestored)
+	if restored != (DirectoryRestored{Directory: FileChangeFilters, Path: filtersDirectory}) {
+		t.Fatalf("got %+v", restored)
+	}
+
+	if !source.Watching(filtersDirectory) {
+		t.Fatal("not watching the directory that came back")
+	}
+
+	if status := watcher.engine.computeStatus(); !status.FiltersDirectory.Exists {
+		t.Fatalf("status says %+v", status.FiltersDirectory)
+	}
+
+	path := filepath.Join(filtersDirectory, "NeverSink.filter")
+	writeTestFile(t, path, "Show\n")
+	if !source.Send(fsnotify.Event{Name: path, Op: fsnotify.Create}) {
+		t.Fatal("event for the directory that came back was dropped")
+	}
+
+	changes := events.WaitFor(t, eventWatchEventTriggered).(FileChangesEvent).Changes
+	if len(changes) != 1 || changes[0].Directory != FileChangeFilters || filepath.Base(changes[0].File) != "NeverSink.filter" {
+		t.Fatalf("got %+v", changes)
+	}
+}
+
+func TestConfigChangesWhileWatching(t *testing.T) {
+	filtersDirectory, downloadsDirectory := t.TempDir(), t.TempDir()
+	source := newFakeEventSource()
+	events := newRecordingEmitter()
+
+	watcher := newTestWatcher(t, source, events, filtersDirectory, downloadsDirectory)
+	engine := watcher.engine
+	useTestConfigFile(t, engine)
+	engine.config.Set(configKeyFiltersOverwriteStrategy, string(OverwriteNamedFile))
+	engine.config.Set(configKeyFiltersSelectedFile, "installed.filter")
+	engine.config.Set(configKeyWatcherRememberPaused, true)
+
+	watcher.Start()
+	defer watcher.Stop()
+
+	watcher.SetFiltersDirectory(filtersDirectory)
+	watcher.SetDownloadsDirectory(downloadsDirectory)
+
+	done := make(chan struct{})
+	var background sync.WaitGroup
+
+	// what the UI's settings do, over and over
+	background.Add(1)
+	go func() {
+		defer background.Done()
+
+		for idx := 0; ; idx++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			engine.config.Set(configKeyFiltersAllowDowngrade, idx%2 == 0)
+			engine.config.Set(configKeyValidationMode, []string{string(ValidationLenient), string(ValidationStrict)}[idx%2])
+			if err := engine.writeConfig(configKeyFiltersAllowDowngrade, configKeyValidationMode); err != nil {
+				t.Error(err)
+				return
+			}
+
+			if err := engine.setRules([]ReplacementRule{}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// what the status ticker does
+	background.Add(1)
+	go func() {
+		defer background.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				engine.refreshStatus()
+			}
+		}
+	}()
+
+	// pausing persists the paused state, since remember_paused is on
+	for idx := 0; idx < 20; idx++ {
+		engine.TogglePause()
+	}
+	engine.SetPaused(false)
+
+	contents := "Show\n\tBaseType == \"Mirror of Kalandra\"\n"
+	download := filepath.Join(downloadsDirectory, "NeverSink.filter")
+	writeTestFile(t, download, contents)
+	source.Send(fsnotify.Event{Name: download, Op: fsnotify.Create})
+
+	events.WaitFor(t, eventReplacementSucceeded)
+	close(done)
+	background.Wait()
+
+	assertFileContents(t, filepath.Join(filtersDirectory, "installed.filter"), contents)
+}
+
+// errAny stands in for any error at all in test tables
+var errAny = errors.New("any error")
+
+type nopEmitter struct{}
+
+func (nopEmitter) Emit(string, ...interface{}) {}
+
+type recordedEvent struct {
+	name string
+	data interface{}
+}
+
+// recordingEmitter keeps every event sent through it, for tests to wait for
+type recordingEmitter struct {
+	lock   sync.Mutex
+	events []recordedEvent
+}
+
+func newRecordingEmitter() *recordingEmitter {
+	return &recordingEmitter{}
+}
+
+func (e *recordingEmitter) Emit(eventName string, data ...interface{}) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	event := recordedEvent{name: eventName}
+	if len(data) > 0 {
+		event.data = data[0]
+	}
+
+	e.events = append(e.events, event)
+}
+
+// Count returns how many events with the given name were sent so far
+func (e *recordingEmitter) Count(eventName string) int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	count := 0
+	for _, event := range e.events {
+		if event.name == eventName {
+			count++
+		}
+	}
+
+	return count
+}
+
+// WaitFor waits for the first event with the given name to be sent, and returns its payload
+func (e *recordingEmitter) WaitFor(t *testing.T, eventName string) interface{} {
+	t.Helper()
+	return e.WaitForNth(t, eventName, 1)
+}
+
+// WaitForNth waits for the nth event (counting from 1) with the given name to be sent, and returns its payload
+func (e *recordingEmitter) WaitForNth(t *testing.T, eventName string, n int) interface{} {
+	t.Helper()
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		e.lock.Lock()
+		seen := 0
+		for _, event := range e.events {
+			if event.name == eventName {
+				if seen++; seen == n {
+					e.lock.Unlock()
+					return event.data
+				}
+			}
+		}
+		e.lock.Unlock()
+	}
+
+	t.Fatalf("%s was sent fewer than %d times", eventName, n)
+	return nil
+}
+
+func TestReplacementFailingVerificationLeavesNoBadFilter(t *testing.T) {
+	tests := []struct {
+		name           string
+		backups        bool
+		installed      string // empty if there's no filter to replace yet
+		wantInstalled  string // empty if the bad replacement should be gone
+		wantBackupsLen int
+	}{
+		{name: "restored from the backup", backups: true, installed: "Show\n", wantInstalled: "Show\n", wantBackupsLen: 2},
+		{name: "nothing was installed", backups: true},
+		{name: "backups are off", installed: "Show\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filtersDirectory, downloadsDirectory := t.TempDir(), t.TempDir()
+			watcher := newTestWatcher(t, newFakeEventSource(), nopEmitter{}, filtersDirectory, downloadsDirectory)
+			watcher.engine.config.Set(configKeyBackupsEnabled, test.backups)
+
+			installed := filepath.Join(filtersDirectory, "installed.filter")
+			if test.installed != "" {
+				writeTestFile(t, installed, test.installed)
+			}
+
+			download := filepath.Join(downloadsDirectory, "NeverSink.filter")
+			writeTestFile(t, download, "Hide\n")
+
+			previousFileSystem := defaultFileSystem
+			defaultFileSystem = faultyFileSystem{corruptWrites: []byte("Hide\n")}
+			defer func() { defaultFileSystem = previousFileSystem }()
+
+			if receipt, err := watcher.performActualReplacement(download, download, "installed.filter"); err == nil {
+				t.Fatalf("got receipt %+v for a replacement that doesn't match the download", receipt)
+			}
+
+			defaultFileSystem = previousFileSystem
+
+			if test.wantInstalled == "" {
+				if fileExists(installed) {
+					t.Fatal("bad replacement left in place")
+				}
+			} else {
+				assertFileContents(t, installed, test.wantInstalled)
+			}
+
+			assertNoTempFiles(t, filtersDirectory)
+
+			// restoring backs up the bad replacement too, so it can be looked at
+			backups, err := watcher.engine.newBackupStore(filtersDirectory).List("installed.filter")
+			if err != nil || len(backups) != test.wantBackupsLen {
+				t.Fatalf("got backups %+v, %v; want %d", backups, err, test.wantBackupsLen)
+			}
+		})
+	}
+}